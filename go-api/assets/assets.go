@@ -0,0 +1,93 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Prefix is where the static files are mounted
+const Prefix = "/static/"
+
+// Server serves the static files. Outside dev mode every file is also served
+// under a name carrying a hash of its content, eg. styles.3f2a1b9c0d.css,
+// which can be cached forever because a changed file gets a new name.
+type Server struct {
+	fsys fs.FS
+	dev  bool
+	// name -> hash and hashed name -> name
+	hashes map[string]string
+	names  map[string]string
+}
+
+// New hashes every file in fsys up front. In dev mode nothing is hashed so
+// edits to files on disk show up on the next reload.
+func New(fsys fs.FS, dev bool) (*Server, error) {
+	s := &Server{
+		fsys:   fsys,
+		dev:    dev,
+		hashes: make(map[string]string),
+		names:  make(map[string]string),
+	}
+	if dev {
+		return s, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:5])
+		s.hashes[name] = hash
+		s.names[hashedName(name, hash)] = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Path is the URL to use for name in templates, the hashed one when there is one
+func (s *Server) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hash, ok := s.hashes[name]; ok {
+		return Prefix + hashedName(name, hash)
+	}
+	return Prefix + name
+}
+
+// ServeHTTP expects the Prefix already stripped
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if original, ok := s.names[name]; ok {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeFileFS(w, r, s.fsys, original)
+		return
+	}
+
+	// the plain name still works but has to be revalidated every time
+	info, err := fs.Stat(s.fsys, name)
+	if name == "" || err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	if hash, ok := s.hashes[name]; ok {
+		w.Header().Set("ETag", `"`+hash+`"`)
+	}
+	http.ServeFileFS(w, r, s.fsys, name)
+}
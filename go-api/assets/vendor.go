@@ -0,0 +1,145 @@
+package assets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VendorDir is where the vendor-assets command saves the front-end libraries,
+// relative to the static directory
+const VendorDir = "vendor"
+
+// Vendored is a pinned copy of a front-end library. Templates ask for it by
+// Name and get the local copy when it has been downloaded, the CDN otherwise.
+type Vendored struct {
+	Name string
+	// Path is relative to VendorDir, keep the layout of the package when a
+	// file refers to others relatively, eg. the icon font from its CSS
+	Path string
+	URL  string
+	// SHA256 is the hex digest the download must have. A library without one
+	// isn't saved, vendor-assets reports the digest it got so it can be
+	// checked against the release and pinned here.
+	SHA256 string
+}
+
+// The digests still to be pinned are empty, run vendor-assets to get them
+var Vendor = []Vendored{
+	{"htmx", "htmx/htmx.min.js", "https://unpkg.com/htmx.org@2.0.6/dist/htmx.min.js", ""},
+	{"htmx-json-enc", "htmx/ext/json-enc.js", "https://unpkg.com/htmx.org@2.0.6/dist/ext/json-enc.js", ""},
+	{"htmx-sse", "htmx/ext/sse.js", "https://unpkg.com/htmx-ext-sse@2.2.3/sse.js", ""},
+	{"bootstrap-css", "bootstrap/bootstrap.min.css", "https://cdn.jsdelivr.net/npm/bootstrap@5.3.7/dist/css/bootstrap.min.css", ""},
+	{"bootstrap-js", "bootstrap/bootstrap.bundle.min.js", "https://cdn.jsdelivr.net/npm/bootstrap@5.3.7/dist/js/bootstrap.bundle.min.js", ""},
+	{"bootstrap-icons", "bootstrap-icons/bootstrap-icons.css", "https://cdn.jsdelivr.net/npm/bootstrap-icons@1.10.5/font/bootstrap-icons.css", ""},
+	{"bootstrap-icons-woff2", "bootstrap-icons/fonts/bootstrap-icons.woff2", "https://cdn.jsdelivr.net/npm/bootstrap-icons@1.10.5/font/fonts/bootstrap-icons.woff2", ""},
+	{"bootstrap-icons-woff", "bootstrap-icons/fonts/bootstrap-icons.woff", "https://cdn.jsdelivr.net/npm/bootstrap-icons@1.10.5/font/fonts/bootstrap-icons.woff", ""},
+	{"plotly", "plotly/plotly.min.js", "https://cdn.plot.ly/plotly-3.1.0.min.js", ""},
+	{"jquery", "jquery/jquery.min.js", "https://ajax.googleapis.com/ajax/libs/jquery/3.7.1/jquery.min.js", ""},
+	{"datatables-css", "datatables/jquery.dataTables.min.css", "https://cdn.datatables.net/1.13.6/css/jquery.dataTables.min.css", ""},
+	{"datatables-js", "datatables/jquery.dataTables.min.js", "https://cdn.datatables.net/1.13.6/js/jquery.dataTables.min.js", ""},
+}
+
+func lookupVendored(name string) (Vendored, bool) {
+	for _, v := range Vendor {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Vendored{}, false
+}
+
+// VendorPath is the URL for a vendored library, for the vendor template func.
+// An unknown name is an error so a typo fails the template rather than
+// quietly loading nothing.
+func (s *Server) VendorPath(name string) (string, error) {
+	v, ok := lookupVendored(name)
+	if !ok {
+		return "", fmt.Errorf("no vendored asset named %q", name)
+	}
+	local := VendorDir + "/" + v.Path
+	if _, err := fs.Stat(s.fsys, local); err != nil {
+		return v.URL, nil
+	}
+	return s.Path(local), nil
+}
+
+// MissingVendored lists the libraries still loaded from a CDN
+func (s *Server) MissingVendored() []string {
+	var missing []string
+	for _, v := range Vendor {
+		if _, err := fs.Stat(s.fsys, VendorDir+"/"+v.Path); err != nil {
+			missing = append(missing, v.Name)
+		}
+	}
+	return missing
+}
+
+// DownloadVendored saves every library in Vendor under dir/VendorDir,
+// printing progress to out. A file whose SHA-256 doesn't match its pin isn't
+// saved. It carries on past failures so one run reports every digest.
+func DownloadVendored(ctx context.Context, client *http.Client, dir string, out io.Writer) error {
+	root := filepath.Join(dir, VendorDir)
+	var errs []error
+	for _, v := range Vendor {
+		if err := download(ctx, client, v, filepath.Join(root, filepath.FromSlash(v.Path))); err != nil {
+			errs = append(errs, fmt.Errorf("downloading %s: %w", v.Name, err))
+			continue
+		}
+		fmt.Fprintf(out, "%s  %s\n", v.Path, v.URL)
+	}
+	return errors.Join(errs...)
+}
+
+func download(ctx context.Context, client *http.Client, v Vendored, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", v.URL, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write to a temp file first so a failed or tampered download doesn't
+	// leave anything behind
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	switch sum := hex.EncodeToString(hash.Sum(nil)); {
+	case v.SHA256 == "":
+		os.Remove(tmp)
+		return fmt.Errorf("no SHA-256 pinned, got %s, check it against the release and add it to assets.Vendor", sum)
+	case sum != strings.ToLower(v.SHA256):
+		os.Remove(tmp)
+		return fmt.Errorf("SHA-256 is %s, want %s", sum, v.SHA256)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,83 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadVendored(t *testing.T) {
+	const body = "console.log('pinned')"
+	sum := sha256.Sum256([]byte(body))
+	digest := hex.EncodeToString(sum[:])
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr string
+	}{
+		{name: "matches its pin", pin: digest},
+		{name: "pinned in upper case", pin: strings.ToUpper(digest)},
+		{name: "doesn't match", pin: strings.Repeat("0", 64),
+			wantErr: "downloading lib: SHA-256 is " + digest + ", want " + strings.Repeat("0", 64)},
+		{name: "not pinned", wantErr: "downloading lib: no SHA-256 pinned, got " + digest + ", check it against the release and add it to assets.Vendor"},
+	}
+	saved := Vendor
+	defer func() { Vendor = saved }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Vendor = []Vendored{{"lib", "lib/lib.min.js", srv.URL + "/lib.min.js", tt.pin}}
+			dir := t.TempDir()
+			var out strings.Builder
+			err := DownloadVendored(t.Context(), srv.Client(), dir, &out)
+
+			path := filepath.Join(dir, VendorDir, "lib", "lib.min.js")
+			got, readErr := os.ReadFile(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != body || out.String() != "lib/lib.min.js  "+srv.URL+"/lib.min.js\n" {
+					t.Errorf("saved %q and printed %q", got, out.String())
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error %v, want %s", err, tt.wantErr)
+			}
+			// neither the file nor its temp copy is left behind
+			if _, tmpErr := os.Stat(path + ".tmp"); readErr == nil || tmpErr == nil {
+				t.Errorf("a rejected download was left in %s", filepath.Dir(path))
+			}
+		})
+	}
+}
+
+func TestDownloadVendoredReportsEveryFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+	saved := Vendor
+	defer func() { Vendor = saved }()
+	Vendor = []Vendored{
+		{"a", "a.js", srv.URL + "/a.js", ""},
+		{"b", "b.js", srv.URL + "/b.js", ""},
+	}
+
+	err := DownloadVendored(t.Context(), srv.Client(), t.TempDir(), io.Discard)
+	if err == nil || !strings.HasPrefix(err.Error(), "downloading a: ") || !strings.Contains(err.Error(), "\ndownloading b: ") {
+		t.Errorf("error %v, want both libraries reported", err)
+	}
+}
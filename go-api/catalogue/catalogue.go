@@ -0,0 +1,86 @@
+package catalogue
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+)
+
+// Stats is a point in time view of the cache for health checks
+type Stats struct {
+	Entries   int       `json:"entries"`
+	LoadedAt  time.Time `json:"loadedAt,omitzero"`
+	Hits      int64     `json:"hits"`
+	Misses    int64     `json:"misses"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Cache holds the dataflow ids from abs_static_dataflow in memory so
+// validating a dataflow doesn't cost a query per request. It reloads from
+// the database once the ttl has passed.
+type Cache struct {
+	db  *db.Database
+	ttl time.Duration
+
+	mu       sync.Mutex
+	ids      map[string]bool
+	loadedAt time.Time
+	stats    Stats
+}
+
+func New(database *db.Database, ttl time.Duration) *Cache {
+	return &Cache{db: database, ttl: ttl}
+}
+
+// Contains reports whether id is a known dataflow. A hit is a lookup served
+// from memory, a miss is one that had to reload the catalogue first.
+func (c *Cache) Contains(id string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ids == nil || time.Since(c.loadedAt) > c.ttl {
+		c.stats.Misses++
+		if err := c.load(); err != nil {
+			return false, err
+		}
+	} else {
+		c.stats.Hits++
+	}
+	return c.ids[strings.ToUpper(id)], nil
+}
+
+// Invalidate drops the cached ids, the next lookup reloads them
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids = nil
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.ids)
+	stats.LoadedAt = c.loadedAt
+	return stats
+}
+
+func (c *Cache) load() error {
+	dataflows, err := c.db.ListDataflows()
+	if err != nil {
+		c.stats.LastError = err.Error()
+		return fmt.Errorf("loading dataflow catalogue: %w", err)
+	}
+
+	ids := make(map[string]bool, len(dataflows))
+	for _, d := range dataflows {
+		ids[strings.ToUpper(d.ID)] = true
+	}
+	c.ids = ids
+	c.loadedAt = time.Now()
+	c.stats.LastError = ""
+	return nil
+}
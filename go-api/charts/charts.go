@@ -0,0 +1,170 @@
+package charts
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+// Shape is the kind of data a chart makes sense for
+type Shape string
+
+const (
+	// values over time, one trace per series
+	TimeSeries Shape = "timeseries"
+	// one value per series, eg. the latest
+	Snapshot Shape = "snapshot"
+	// how the values are spread
+	Distribution Shape = "distribution"
+)
+
+// Renderer is what draws a chart, Go builds Plotly traces for /chart and the
+// Python plot service draws /plot
+type Renderer string
+
+const (
+	RendererGo     Renderer = "go"
+	RendererPython Renderer = "python"
+)
+
+// Param is a query parameter a chart type reads on top of the data query
+type Param struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// TraceFunc draws series, grouped and named by the caller, as Plotly traces
+type TraceFunc func(series [][]fetch.Observation, names []string, params url.Values) []Trace
+
+type Chart struct {
+	Name   string  `json:"name"`
+	Label  string  `json:"label"`
+	Shapes []Shape `json:"shapes"`
+	Params []Param `json:"params,omitempty"`
+	// Traces draws the chart in Go, nil when only the plot service can
+	Traces    TraceFunc  `json:"-"`
+	Renderers []Renderer `json:"renderers"`
+}
+
+func (c Chart) Supports(renderer Renderer) bool {
+	return slices.Contains(c.Renderers, renderer)
+}
+
+// CheckParams reports the first required parameter missing from q
+func (c Chart) CheckParams(q url.Values) error {
+	for _, p := range c.Params {
+		if p.Required && q.Get(p.Name) == "" {
+			return fmt.Errorf("chart type %q needs the %s parameter, %s", c.Name, p.Name, p.Description)
+		}
+	}
+	return nil
+}
+
+// Registry is the chart types the server knows about, in registration order.
+// It's safe to use while MergePython updates it.
+type Registry struct {
+	mu     sync.RWMutex
+	charts []Chart
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a chart type, a chart with Traces is drawn by Go
+func (r *Registry) Register(c Chart) error {
+	if c.Name == "" {
+		return fmt.Errorf("chart type needs a name")
+	}
+	if c.Traces != nil && !c.Supports(RendererGo) {
+		c.Renderers = append([]Renderer{RendererGo}, c.Renderers...)
+	}
+	if c.Supports(RendererGo) && c.Traces == nil {
+		return fmt.Errorf("chart type %q is drawn by Go but has no Traces", c.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index(c.Name) >= 0 {
+		return fmt.Errorf("chart type %q is already registered", c.Name)
+	}
+	r.charts = append(r.charts, c)
+	return nil
+}
+
+func (r *Registry) index(name string) int {
+	return slices.IndexFunc(r.charts, func(c Chart) bool { return c.Name == name })
+}
+
+func (r *Registry) List() []Chart {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.charts)
+}
+
+// Names lists the chart types renderer can draw
+func (r *Registry) Names(renderer Renderer) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for _, c := range r.charts {
+		if c.Supports(renderer) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// Lookup finds a chart type renderer can draw, the error says what it could
+// have been instead
+func (r *Registry) Lookup(name string, renderer Renderer) (Chart, error) {
+	r.mu.RLock()
+	i := r.index(name)
+	var c Chart
+	if i >= 0 {
+		c = r.charts[i]
+	}
+	r.mu.RUnlock()
+
+	expected := strings.Join(r.Names(renderer), ", ")
+	switch {
+	case i < 0:
+		return Chart{}, fmt.Errorf("unknown chart type %q, expected one of %s", name, expected)
+	case !c.Supports(renderer):
+		return Chart{}, fmt.Errorf("chart type %q can't be drawn by the %s renderer, expected one of %s", name, renderer, expected)
+	}
+	return c, nil
+}
+
+// MergePython makes the python renderer match the graphs the plot service
+// advertises. Graphs Go doesn't know are added as python only, Go charts the
+// service dropped lose the python renderer. It returns both lists so the
+// drift can be logged.
+func (r *Registry) MergePython(graphs []string) (added, dropped []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.charts {
+		c := &r.charts[i]
+		advertised := slices.Contains(graphs, c.Name)
+		switch {
+		case advertised && !c.Supports(RendererPython):
+			c.Renderers = append(slices.Clone(c.Renderers), RendererPython)
+		case !advertised && c.Supports(RendererPython):
+			c.Renderers = slices.DeleteFunc(slices.Clone(c.Renderers), func(rd Renderer) bool { return rd == RendererPython })
+			dropped = append(dropped, c.Name)
+		}
+	}
+	for _, g := range graphs {
+		if r.index(g) < 0 {
+			r.charts = append(r.charts, Chart{Name: g, Label: g, Shapes: []Shape{}, Renderers: []Renderer{RendererPython}})
+			added = append(added, g)
+		}
+	}
+	return added, dropped
+}
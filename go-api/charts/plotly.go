@@ -0,0 +1,112 @@
+package charts
+
+import (
+	"net/url"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+type Line struct {
+	Dash string `json:"dash,omitempty"`
+}
+
+// Trace is the subset of a Plotly trace the Go charts use
+type Trace struct {
+	Type   string    `json:"type"`
+	X      []string  `json:"x,omitempty"`
+	Y      []float64 `json:"y,omitempty"`
+	Labels []string  `json:"labels,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+	Name   string    `json:"name,omitempty"`
+	Mode   string    `json:"mode,omitempty"`
+	Line   *Line     `json:"line,omitempty"`
+}
+
+// NewTrace is a line through series, dash is a Plotly dash style or "" for solid
+func NewTrace(name string, series []fetch.Observation, dash string) Trace {
+	t := Trace{Type: "scatter", Name: name, Mode: "lines"}
+	if dash != "" {
+		t.Line = &Line{Dash: dash}
+	}
+	for _, obs := range series {
+		t.X = append(t.X, obs.Period)
+		t.Y = append(t.Y, obs.Value)
+	}
+	return t
+}
+
+// Default is the chart types both sides have drawn so far, MergePython
+// corrects the python renderers once the plot service answers
+func Default() *Registry {
+	r := NewRegistry()
+	for _, c := range []Chart{
+		{
+			Name: "line", Label: "Line", Shapes: []Shape{TimeSeries},
+			Traces:    perSeries(func(t *Trace) {}),
+			Renderers: []Renderer{RendererPython},
+		},
+		{
+			Name: "bar", Label: "Bar", Shapes: []Shape{TimeSeries},
+			Traces:    perSeries(func(t *Trace) { t.Type, t.Mode = "bar", "" }),
+			Renderers: []Renderer{RendererPython},
+		},
+		{
+			Name: "scatter", Label: "Scatter", Shapes: []Shape{TimeSeries},
+			Traces:    perSeries(func(t *Trace) { t.Mode = "markers" }),
+			Renderers: []Renderer{RendererPython},
+		},
+		{
+			Name: "pie", Label: "Pie", Shapes: []Shape{Snapshot},
+			Params:    []Param{{Name: "at", Description: "period to take each series' value at, default the latest"}},
+			Traces:    pie,
+			Renderers: []Renderer{RendererPython},
+		},
+		{
+			Name: "histogram", Label: "Histogram", Shapes: []Shape{Distribution},
+			Renderers: []Renderer{RendererPython},
+		},
+	} {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// perSeries draws a trace per series, style adjusts each from a line
+func perSeries(style func(t *Trace)) TraceFunc {
+	return func(series [][]fetch.Observation, names []string, params url.Values) []Trace {
+		traces := make([]Trace, 0, len(series))
+		for i, s := range series {
+			t := NewTrace(names[i], s, "")
+			style(&t)
+			traces = append(traces, t)
+		}
+		return traces
+	}
+}
+
+// pie is one slice per series, its value at the at parameter or its latest.
+// Series without a value at that period are left out.
+func pie(series [][]fetch.Observation, names []string, params url.Values) []Trace {
+	at := params.Get("at")
+	t := Trace{Type: "pie"}
+	for i, s := range series {
+		obs := s[len(s)-1]
+		if at != "" {
+			found := false
+			for _, o := range s {
+				if o.Period == at {
+					obs, found = o, true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		t.Labels = append(t.Labels, names[i])
+		t.Values = append(t.Values, obs.Value)
+	}
+	return []Trace{t}
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/logging"
+)
+
+// env is what every command gets: the shared config and loggers, and where
+// to write output
+type env struct {
+	cfg     *config.Config
+	loggers *logging.Loggers
+	stdout  io.Writer
+}
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// flags adds the command's own flags, nil when it has none
+	flags func(fset *flag.FlagSet)
+	run   func(ctx context.Context, e *env, fset *flag.FlagSet) error
+	// serve logs to stdout, the others keep stdout for their output
+	logToStdout bool
+}
+
+var commands = []command{
+	serveCommand,
+	syncCatalogueCommand,
+	fetchCommand,
+	refreshCommand,
+	exportCommand,
+	migrateCommand,
+	vendorAssetsCommand,
+}
+
+// Run picks the subcommand from args, defaulting to serve so running the
+// binary with no arguments still starts the web server
+func Run(ctx context.Context, stdout, stderr io.Writer, args []string) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	name := filepath.Base(args[0])
+	args = args[1:]
+	cmd := serveCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] == "help" {
+			usage(stderr, name)
+			return nil
+		}
+		found := false
+		for _, c := range commands {
+			if c.name == args[0] {
+				cmd, found = c, true
+				break
+			}
+		}
+		if !found {
+			usage(stderr, name)
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		args = args[1:]
+	}
+
+	fset := flag.NewFlagSet(name+" "+cmd.name, flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	fset.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s\n\n%s\n\nFlags:\n", name, cmd.usage, cmd.summary)
+		fset.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(fset)
+	}
+	cfg, err := config.Load(fset, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	logOut := stderr
+	if cmd.logToStdout {
+		logOut = stdout
+	}
+	loggers, err := logging.New(cfg.LoggingConfig, logOut)
+	if err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	// anything still using the log package or slog's default goes through here
+	slog.SetDefault(loggers.Logger(logging.Server))
+	for _, key := range cfg.IgnoredKeys {
+		slog.Warn("Ignoring config file setting that is no longer used", "file", cfg.ConfigFile, "key", key, "reason", config.LegacyKeyReason(key))
+	}
+
+	return cmd.run(ctx, &env{cfg: cfg, loggers: loggers, stdout: stdout}, fset)
+}
+
+func usage(w io.Writer, name string) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", name)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-15s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> -h for the flags of a command. With no command, serve.\n", name)
+}
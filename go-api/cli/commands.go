@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/assets"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/export"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/logging"
+	"github.com/VooDooM1234/abs-visualiser/go-api/server"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+)
+
+var serveCommand = command{
+	name:        "serve",
+	usage:       "serve [flags]",
+	summary:     "Run the web server and the plot service.",
+	logToStdout: true,
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		return server.Run(ctx, e.cfg, e.loggers)
+	},
+}
+
+var migrateCommand = command{
+	name:    "migrate",
+	usage:   "migrate [flags]",
+	summary: "Apply database migrations and print the schema version.",
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		database, err := openDatabase(ctx, e)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		version, err := database.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Database schema at version %d\n", version)
+		return nil
+	},
+}
+
+var syncCatalogueCommand = command{
+	name:    "sync-catalogue",
+	usage:   "sync-catalogue [flags]",
+	summary: "Import the ABS dataflow catalogue into the database. Slow, the ABS takes minutes to answer.",
+	flags: func(fset *flag.FlagSet) {
+		fset.String("snapshot", "static/data/ABSDataflowAll.json", "also save the raw ABS response here, \"\" to skip")
+	},
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		database, err := openDatabase(ctx, e)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		abs := fetch.NewABS(e.loggers.Logger(logging.Fetch))
+		if err := abs.ABSRestDataflowAll(ctx, database, flagString(fset, "snapshot")); err != nil {
+			return fmt.Errorf("syncing catalogue: %w", err)
+		}
+		count, _, err := database.CatalogueStatus(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Catalogue has %d dataflows\n", count)
+		return nil
+	},
+}
+
+var fetchCommand = command{
+	name:    "fetch",
+	usage:   "fetch [flags] <dataflow> [key]",
+	summary: "Print or save observations from the ABS API as CSV or JSON. key defaults to all.",
+	flags: func(fset *flag.FlagSet) {
+		queryFlags(fset)
+		fset.String("format", "csv", "csv or json")
+		fset.String("o", "", "write to this file instead of stdout")
+	},
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		query, err := queryArgs(fset)
+		if err != nil {
+			return err
+		}
+		format := strings.ToLower(flagString(fset, "format"))
+		if format != "csv" && format != "json" {
+			return fmt.Errorf("invalid format %q, use csv or json", format)
+		}
+
+		abs := fetch.NewABS(e.loggers.Logger(logging.Fetch))
+		ds, err := abs.ABSRestDataset(ctx, query)
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", query.DataflowID, err)
+		}
+
+		return writeOutput(e.stdout, flagString(fset, "o"), func(w io.Writer) error {
+			if format == "json" {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(ds.Observations)
+			}
+			return export.Write(w, export.CSV, ds)
+		})
+	},
+}
+
+var refreshCommand = command{
+	name:    "refresh",
+	usage:   "refresh [flags] [dataflow]",
+	summary: "Fetch what the ABS has added or revised since the stored data was downloaded, for one dataflow or all of them.",
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		args := fset.Args()
+		if len(args) > 1 {
+			fset.Usage()
+			return errors.New("expected [dataflow]")
+		}
+		var dataflowID string
+		if len(args) == 1 {
+			dataflowID = strings.ToUpper(args[0])
+		}
+
+		database, err := openDatabase(ctx, e)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		abs := fetch.NewABS(e.loggers.Logger(logging.Fetch))
+		// refreshing doesn't depend on the data source policy
+		data := source.New(abs, database, source.Policy{}, e.loggers.Logger(logging.Fetch))
+		all, err := data.RefreshAll(ctx, dataflowID)
+		for _, r := range all {
+			key := r.Key
+			if r.StartPeriod != "" || r.EndPeriod != "" {
+				key += fmt.Sprintf(" (%s to %s)", r.StartPeriod, r.EndPeriod)
+			}
+			fmt.Fprintf(e.stdout, "%s %s: %d new or changed observations, %d revised\n", r.DataflowID, key, r.Changed, len(r.Revised))
+			for _, rev := range r.Revised {
+				fmt.Fprintf(e.stdout, "  %s %s: %g -> %g\n", rev.SeriesKey, rev.Period, rev.Old, rev.New)
+			}
+		}
+		if len(all) == 0 && err == nil {
+			fmt.Fprintln(e.stdout, "Nothing stored to refresh, download data first")
+		}
+		return err
+	},
+}
+
+var exportCommand = command{
+	name:    "export",
+	usage:   "export [flags] <dataflow> [key]",
+	summary: "Save observations from the ABS API as CSV, Excel or Parquet, named like the web export.",
+	flags: func(fset *flag.FlagSet) {
+		queryFlags(fset)
+		fset.String("format", "csv", "csv, xlsx or parquet")
+		fset.String("o", "", "file to write, defaults to the export file name in -dir")
+		fset.String("dir", ".", "directory for the default file name")
+	},
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		query, err := queryArgs(fset)
+		if err != nil {
+			return err
+		}
+		format, err := export.ParseFormat(flagString(fset, "format"))
+		if err != nil {
+			return err
+		}
+
+		abs := fetch.NewABS(e.loggers.Logger(logging.Fetch))
+		ds, err := abs.ABSRestDataset(ctx, query)
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", query.DataflowID, err)
+		}
+
+		path := flagString(fset, "o")
+		if path == "" {
+			path = filepath.Join(flagString(fset, "dir"), export.Filename(ds, format))
+		}
+		if err := writeOutput(e.stdout, path, func(w io.Writer) error {
+			return export.Write(w, format, ds)
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Wrote %d observations to %s\n", len(ds.Observations), path)
+		return nil
+	},
+}
+
+var vendorAssetsCommand = command{
+	name:    "vendor-assets",
+	usage:   "vendor-assets [flags]",
+	summary: "Download the pinned htmx, Bootstrap, Plotly and DataTables files so the UI works offline. Rebuild afterwards to embed them.",
+	flags: func(fset *flag.FlagSet) {
+		fset.String("dir", "static", "static directory to save them under")
+	},
+	run: func(ctx context.Context, e *env, fset *flag.FlagSet) error {
+		dir := flagString(fset, "dir")
+		if err := assets.DownloadVendored(ctx, http.DefaultClient, dir, e.stdout); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Saved %d files under %s\n", len(assets.Vendor), filepath.Join(dir, assets.VendorDir))
+		return nil
+	},
+}
+
+func openDatabase(ctx context.Context, e *env) (*db.Database, error) {
+	if err := e.cfg.RequireDatabase(); err != nil {
+		return nil, err
+	}
+	database, err := db.NewDatabase(ctx, e.cfg.PostgresURL, e.loggers.Logger(logging.DB))
+	if err != nil {
+		return nil, err
+	}
+	if err := database.Migrate(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+	return database, nil
+}
+
+func queryFlags(fset *flag.FlagSet) {
+	fset.String("start", "", "first period, eg. 2015 or 2015-Q1")
+	fset.String("end", "", "last period")
+}
+
+// queryArgs reads <dataflow> [key] and the period flags
+func queryArgs(fset *flag.FlagSet) (fetch.DataQuery, error) {
+	args := fset.Args()
+	if len(args) < 1 || len(args) > 2 {
+		fset.Usage()
+		return fetch.DataQuery{}, errors.New("expected <dataflow> [key]")
+	}
+	query := fetch.DataQuery{
+		DataflowID:  strings.ToUpper(args[0]),
+		Key:         "all",
+		StartPeriod: flagString(fset, "start"),
+		EndPeriod:   flagString(fset, "end"),
+	}
+	if len(args) == 2 {
+		query.Key = args[1]
+	}
+	for _, period := range []string{query.StartPeriod, query.EndPeriod} {
+		if period != "" && !fetch.PeriodPattern.MatchString(period) {
+			return query, fmt.Errorf("invalid period: %s", period)
+		}
+	}
+	return query, nil
+}
+
+func flagString(fset *flag.FlagSet, name string) string {
+	return fset.Lookup(name).Value.String()
+}
+
+// writeOutput runs write against stdout when path is "", otherwise a new file
+// that is removed again if writing fails
+func writeOutput(stdout io.Writer, path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return write(stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
@@ -1,19 +1,31 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"os"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
-type apiKeys struct {
-	weather string
-	abs     string
-}
+// DefaultFile is read when neither -config nor ABSVIS_CONFIG names a file
+const DefaultFile = "config.json"
 
+// LoggingConfig is a Python logging.config.dictConfig, shared with the plot
+// service. The Go side reads Format, Level and the level of each entry in
+// Loggers, the rest is ignored.
 type LoggingConfig struct {
+	// text or json
+	Format string `json:"format"`
+	// default level for loggers not listed in Loggers
+	Level                  string                 `json:"level"`
 	Version                int                    `json:"version"`
 	DisableExistingLoggers bool                   `json:"disable_existing_loggers"`
 	Formatters             map[string]interface{} `json:"formatters"`
@@ -21,62 +33,274 @@ type LoggingConfig struct {
 	Loggers                map[string]interface{} `json:"loggers"`
 }
 
+// Duration is a time.Duration written as in time.ParseDuration, eg. "24h"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("not a duration: %q", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config is built once by Load and passed to everything that needs it. Treat
+// it as read only, nothing should change it after Load returns.
 type Config struct {
 	PostgresURL       string        `json:"postgres_url"`
 	PythonPath        string        `json:"python_path"`
 	DefaultChart      string        `json:"default_chart"`
 	DataSource        string        `json:"data_source"`
+	DataMaxAge        Duration      `json:"data_max_age"`
+	DataMaxStale      Duration      `json:"data_max_stale"`
 	PlotServiceHost   string        `json:"plot_service_host"`
 	PlotServicePort   int           `json:"plot_service_port"`
 	PlotServiceScript string        `json:"plot_service_script"`
 	Host              string        `json:"host"`
 	Port              int           `json:"port"`
-	HTMLTemplates     string        `json:"HTMLTemplates"`
 	LoggingConfig     LoggingConfig `json:"logging_config"`
+
+	// directories to read templates and static files from instead of the
+	// copies embedded in the binary, "" uses the embedded ones
+	HTMLTemplates string `json:"HTMLTemplates"`
+	StaticDir     string `json:"static_dir"`
+	// Dev serves templates and static files from disk, reloads templates when
+	// they change and shows template errors in the page
+	Dev bool `json:"dev"`
+
+	// secrets only come from the environment
+	ABSAPIKey     string `json:"-"`
+	WeatherAPIKey string `json:"-"`
+	// ConfigFile is the file that was loaded, "" when running on defaults
+	ConfigFile string `json:"-"`
+	// IgnoredKeys are keys of ConfigFile that older versions read, they are
+	// skipped so old files still load
+	IgnoredKeys []string `json:"-"`
 }
 
-var keys apiKeys
-var config Config
+// legacyKeys are config file keys no longer read, the value says why
+var legacyKeys = map[string]string{
+	"dash_port": "the Dash dashboards were replaced by Go templates",
+}
 
-func Init() (*Config, error) {
-	_ = godotenv.Load(".env")
+func Defaults() Config {
+	return Config{
+		DefaultChart:      "line",
+		DataSource:        "cache",
+		DataMaxAge:        Duration(24 * time.Hour),
+		DataMaxStale:      Duration(30 * 24 * time.Hour),
+		PlotServiceHost:   "127.0.0.1",
+		PlotServicePort:   8082,
+		PlotServiceScript: "plotapp.main:app",
+		Host:              "127.0.0.1",
+		Port:              8081,
+		LoggingConfig: LoggingConfig{
+			Format:  "text",
+			Level:   "INFO",
+			Version: 1,
+		},
+	}
+}
 
-	config.PostgresURL = os.Getenv("DATABASE_URL")
-	keys.weather = os.Getenv("WEATHER_API_KEY")
-	keys.abs = os.Getenv("ABS_API_KEY")
+// setting is one option that can be overridden by environment and flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+	// isBool lets the flag be given without a value, eg. -dev
+	isBool bool
+}
 
-	// if keys.weather == "" {
-	// 	log.Fatal("Missing WEATHER_API_KEY in environment!")
-	// }
-	// if keys.abs == "" {
-	// 	log.Fatal("Missing ABS_API_KEY in environment!")
-	// }
+// boolFlag is a string flag that can also be given on its own like a bool
+type boolFlag struct{ value *string }
 
-	if config.PostgresURL == "" {
-		log.Fatal("DATABASE_URL not set")
+func (b boolFlag) String() string {
+	if b.value == nil {
+		return ""
 	}
+	return *b.value
+}
+func (b boolFlag) Set(v string) error { *b.value = v; return nil }
+func (b boolFlag) IsBoolFlag() bool   { return true }
 
-	file, err := os.Open("config.json")
-	if err != nil {
-		return nil, err
+func stringSetting(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
 	}
-	defer file.Close()
+}
+
+func durationSetting(field func(c *Config) *Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		return field(c).UnmarshalText([]byte(v))
+	}
+}
 
-	var cfg Config
-	err = json.NewDecoder(file).Decode(&cfg)
-	return &cfg, err
+func intSetting(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("not a number: %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
 
+func boolSetting(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("not true or false: %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
 }
 
-// exported getter functions
-func GetABSKey() string {
-	return keys.abs
+var settings = []setting{
+	{"database-url", "DATABASE_URL", "Postgres connection URL",
+		stringSetting(func(c *Config) *string { return &c.PostgresURL }), false},
+	{"host", "ABSVIS_HOST", "address the web server listens on",
+		stringSetting(func(c *Config) *string { return &c.Host }), false},
+	{"port", "ABSVIS_PORT", "port the web server listens on",
+		intSetting(func(c *Config) *int { return &c.Port }), false},
+	{"plot-service-host", "ABSVIS_PLOT_SERVICE_HOST", "address of the Python plot service",
+		stringSetting(func(c *Config) *string { return &c.PlotServiceHost }), false},
+	{"plot-service-port", "ABSVIS_PLOT_SERVICE_PORT", "port of the Python plot service",
+		intSetting(func(c *Config) *int { return &c.PlotServicePort }), false},
+	{"plot-service-script", "ABSVIS_PLOT_SERVICE_SCRIPT", "uvicorn app for the plot service, module:app",
+		stringSetting(func(c *Config) *string { return &c.PlotServiceScript }), false},
+	{"python", "ABSVIS_PYTHON_PATH", "Python interpreter for the plot service",
+		stringSetting(func(c *Config) *string { return &c.PythonPath }), false},
+	{"templates", "ABSVIS_TEMPLATES", "directory of HTML templates, default the embedded copy",
+		stringSetting(func(c *Config) *string { return &c.HTMLTemplates }), false},
+	{"static", "ABSVIS_STATIC", "directory of static files, default the embedded copy",
+		stringSetting(func(c *Config) *string { return &c.StaticDir }), false},
+	{"default-chart", "ABSVIS_DEFAULT_CHART", "chart type used when none is given",
+		stringSetting(func(c *Config) *string { return &c.DefaultChart }), false},
+	{"data-source", "ABSVIS_DATA_SOURCE", "where observations are read from, database, live or cache",
+		stringSetting(func(c *Config) *string { return &c.DataSource }), false},
+	{"data-max-age", "ABSVIS_DATA_MAX_AGE", "how old stored observations can be before cache asks the ABS again, eg. 24h",
+		durationSetting(func(c *Config) *Duration { return &c.DataMaxAge }), false},
+	{"data-max-stale", "ABSVIS_DATA_MAX_STALE", "how old stored observations cache serves when the ABS is down, 0 never",
+		durationSetting(func(c *Config) *Duration { return &c.DataMaxStale }), false},
+	{"log-level", "ABSVIS_LOG_LEVEL", "default log level, DEBUG, INFO, WARNING or ERROR",
+		stringSetting(func(c *Config) *string { return &c.LoggingConfig.Level }), false},
+	{"log-format", "ABSVIS_LOG_FORMAT", "log output, text or json",
+		stringSetting(func(c *Config) *string { return &c.LoggingConfig.Format }), false},
+	{"dev", "ABSVIS_DEV", "development mode, serve templates and static files from disk and reload on change",
+		boolSetting(func(c *Config) *bool { return &c.Dev }), true},
 }
 
-func GetWeatherKey() string {
-	return keys.weather
+// Load builds the Config from, in increasing priority, the defaults, the
+// config file, environment variables (including a .env file) and flags in
+// args. The config flags are added to fset, which may already hold flags of
+// its own, and fset is parsed so the caller can read those and fset.Args().
+func Load(fset *flag.FlagSet, args []string) (*Config, error) {
+	_ = godotenv.Load(".env")
+
+	configFile := fset.String("config", "", "path to the JSON config file (env ABSVIS_CONFIG, default "+DefaultFile+")")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		if s.isBool {
+			values[s.flag] = new(string)
+			fset.Var(boolFlag{values[s.flag]}, s.flag, s.usage+" (env "+s.env+")")
+			continue
+		}
+		values[s.flag] = fset.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fset.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Defaults()
+
+	path, explicit := *configFile, true
+	if path == "" {
+		path = os.Getenv("ABSVIS_CONFIG")
+	}
+	if path == "" {
+		path, explicit = DefaultFile, false
+	}
+	if err := loadFile(&cfg, path); err != nil {
+		// running without config.json is fine, a missing file that was asked for isn't
+		if explicit || !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	} else {
+		cfg.ConfigFile = path
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+	cfg.ABSAPIKey = os.Getenv("ABS_API_KEY")
+	cfg.WeatherAPIKey = os.Getenv("WEATHER_API_KEY")
+
+	var flagErr error
+	fset.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(&cfg, *values[s.flag]); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer file.Close()
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(file).Decode(&raw); err != nil {
+		return fmt.Errorf("reading config file %s: %w", path, err)
+	}
+	for key := range legacyKeys {
+		if _, ok := raw[key]; ok {
+			delete(raw, key)
+			cfg.IgnoredKeys = append(cfg.IgnoredKeys, key)
+		}
+	}
+	slices.Sort(cfg.IgnoredKeys)
+	known, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("reading config file %s: %w", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(known))
+	// a misspelt key would otherwise be silently ignored
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("reading config file %s: %w", path, err)
+	}
+	return nil
 }
 
-func GetPostgresURL() string {
-	return config.PostgresURL
+// LegacyKeyReason says why an ignored key is no longer read
+func LegacyKeyReason(key string) string {
+	return legacyKeys[key]
 }
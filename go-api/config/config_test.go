@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		contents    string
+		wantPort    int
+		wantIgnored []string
+		// wantErr is a substring of the error, "" when the file should load
+		wantErr string
+	}{
+		{name: "current keys", contents: `{"port": 9000, "HTMLTemplates": "templates/html/"}`, wantPort: 9000},
+		{name: "legacy dash_port", contents: `{"port": 9000, "dash_port": 8083}`, wantPort: 9000, wantIgnored: []string{"dash_port"}},
+		{name: "misspelt key", contents: `{"prot": 9000}`, wantErr: `unknown field "prot"`},
+		{name: "wrong type", contents: `{"port": "9000"}`, wantErr: "reading config file"},
+		{name: "not JSON", contents: `port = 9000`, wantErr: "reading config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg := Defaults()
+			err := loadFile(&cfg, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Port != tt.wantPort {
+				t.Errorf("port %d, want %d", cfg.Port, tt.wantPort)
+			}
+			if !slices.Equal(cfg.IgnoredKeys, tt.wantIgnored) {
+				t.Errorf("ignored keys %v, want %v", cfg.IgnoredKeys, tt.wantIgnored)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+)
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+var (
+	logFormats = []string{"text", "json"}
+	logLevels  = []string{"DEBUG", "INFO", "WARN", "WARNING", "ERROR", "CRITICAL"}
+	// the modes of source.Policy
+	dataSources = []string{"database", "live", "cache"}
+)
+
+// validate checks every field and reports all the problems at once, it also
+// tidies values that have an obvious fix such as a missing trailing slash
+func (c *Config) validate() error {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	check(validateHost("host", c.Host))
+	check(validatePort("port", c.Port))
+	check(validateHost("plot_service_host", c.PlotServiceHost))
+	check(validatePort("plot_service_port", c.PlotServicePort))
+	if c.Port == c.PlotServicePort && c.Host == c.PlotServiceHost {
+		problems = append(problems, fmt.Sprintf("port and plot_service_port are both %d", c.Port))
+	}
+
+	if !strings.Contains(c.PlotServiceScript, ":") {
+		problems = append(problems, fmt.Sprintf("plot_service_script must be module:app, got %q", c.PlotServiceScript))
+	}
+
+	// dev mode edits the files in the repo, run from the repo root
+	if c.Dev && c.HTMLTemplates == "" {
+		c.HTMLTemplates = "templates/html"
+	}
+	if c.Dev && c.StaticDir == "" {
+		c.StaticDir = "static"
+	}
+	if c.HTMLTemplates != "" {
+		check(validateDir("HTMLTemplates", c.HTMLTemplates))
+	}
+	if c.StaticDir != "" {
+		check(validateDir("static_dir", c.StaticDir))
+	}
+
+	check(oneOf("default_chart", c.DefaultChart, charts.Default().Names(charts.RendererGo)))
+	check(oneOf("data_source", c.DataSource, dataSources))
+	if c.DataMaxAge < 0 {
+		problems = append(problems, fmt.Sprintf("data_max_age can't be negative, got %s", time.Duration(c.DataMaxAge)))
+	}
+	if c.DataMaxStale < 0 {
+		problems = append(problems, fmt.Sprintf("data_max_stale can't be negative, got %s", time.Duration(c.DataMaxStale)))
+	}
+	check(oneOf("logging_config.format", strings.ToLower(c.LoggingConfig.Format), logFormats))
+	check(oneOf("logging_config.level", strings.ToUpper(c.LoggingConfig.Level), logLevels))
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// RequireDatabase is checked by the commands that use Postgres, fetching
+// from the ABS works without it
+func (c *Config) RequireDatabase() error {
+	if c.PostgresURL == "" {
+		return errors.New("postgres_url is required, set DATABASE_URL or -database-url")
+	}
+	return nil
+}
+
+func validatePort(name string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s must be between 1 and 65535, got %d", name, port)
+	}
+	return nil
+}
+
+func validateHost(name, host string) error {
+	if host == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	if net.ParseIP(host) == nil && !hostnamePattern.MatchString(host) {
+		return fmt.Errorf("%s is not a valid IP address or hostname: %q", name, host)
+	}
+	return nil
+}
+
+func validateDir(name, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory: %s", name, path)
+	}
+	return nil
+}
+
+func oneOf(name, value string, allowed []string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Dashboard is a saved, shareable layout of chart panels
+type Dashboard struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Panels    []Panel   `json:"panels"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Panel is one chart on a dashboard grid. Row and Col are the top left cell,
+// Width and Height are in grid cells (12 columns wide).
+type Panel struct {
+	Title       string   `json:"title"`
+	DataflowID  string   `json:"dataflowid"`
+	Key         string   `json:"key"`
+	Chart       string   `json:"chart"`
+	Transforms  []string `json:"transforms"`
+	StartPeriod string   `json:"startPeriod"`
+	EndPeriod   string   `json:"endPeriod"`
+	Row         int      `json:"row"`
+	Col         int      `json:"col"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+}
+
+// new dashboard ids are short and URL safe so links can be shared
+func newDashboardID() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating id: %w", err)
+	}
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
+
+func scanDashboard(row pgx.Row) (*Dashboard, error) {
+	var dash Dashboard
+	var panels []byte
+	if err := row.Scan(&dash.ID, &dash.Name, &panels, &dash.CreatedAt, &dash.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	if err := json.Unmarshal(panels, &dash.Panels); err != nil {
+		return nil, fmt.Errorf("decoding panels: %w", err)
+	}
+	return &dash, nil
+}
+
+func (d *Database) ListDashboards() ([]Dashboard, error) {
+	rows, err := d.Pool.Query(d.Ctx,
+		`SELECT id, name, panels, created_at, updated_at FROM dashboards ORDER BY updated_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	dashboards := []Dashboard{}
+	for rows.Next() {
+		dash, err := scanDashboard(rows)
+		if err != nil {
+			return nil, err
+		}
+		dashboards = append(dashboards, *dash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return dashboards, nil
+}
+
+func (d *Database) GetDashboard(id string) (*Dashboard, error) {
+	row := d.Pool.QueryRow(d.Ctx,
+		`SELECT id, name, panels, created_at, updated_at FROM dashboards WHERE id = $1`, id)
+	return scanDashboard(row)
+}
+
+// CreateDashboard stores a new dashboard and fills in its id and timestamps
+func (d *Database) CreateDashboard(dash *Dashboard) error {
+	id, err := newDashboardID()
+	if err != nil {
+		return err
+	}
+	panels, err := json.Marshal(dash.Panels)
+	if err != nil {
+		return fmt.Errorf("encoding panels: %w", err)
+	}
+
+	err = d.Pool.QueryRow(d.Ctx,
+		`INSERT INTO dashboards (id, name, panels) VALUES ($1, $2, $3)
+		 RETURNING id, created_at, updated_at`,
+		id, dash.Name, panels,
+	).Scan(&dash.ID, &dash.CreatedAt, &dash.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert failed: %w", err)
+	}
+	return nil
+}
+
+// UpdateDashboard replaces the name and panels of an existing dashboard
+func (d *Database) UpdateDashboard(dash *Dashboard) error {
+	panels, err := json.Marshal(dash.Panels)
+	if err != nil {
+		return fmt.Errorf("encoding panels: %w", err)
+	}
+
+	err = d.Pool.QueryRow(d.Ctx,
+		`UPDATE dashboards SET name = $2, panels = $3, updated_at = now() WHERE id = $1
+		 RETURNING created_at, updated_at`,
+		dash.ID, dash.Name, panels,
+	).Scan(&dash.CreatedAt, &dash.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) DeleteDashboard(id string) error {
+	tag, err := d.Pool.Exec(d.Ctx, `DELETE FROM dashboards WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
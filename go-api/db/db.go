@@ -3,15 +3,16 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Database struct {
-	Pool *pgxpool.Pool
-	Ctx  context.Context
+	Pool   *pgxpool.Pool
+	Ctx    context.Context
+	Logger *slog.Logger
 }
 
 type ABS_CPI struct {
@@ -20,12 +21,12 @@ type ABS_CPI struct {
 }
 
 type ABSDataflow struct {
-	ID                  string
-	Version             string
-	AgencyID            string
-	IsExternalReference bool
-	IsFinal             bool
-	Name                string
+	ID                  string `json:"id"`
+	Version             string `json:"version"`
+	AgencyID            string `json:"agencyID"`
+	IsExternalReference bool   `json:"isExternalReference"`
+	IsFinal             bool   `json:"isFinal"`
+	Name                string `json:"name"`
 }
 
 type ABSDataflowList struct {
@@ -33,18 +34,24 @@ type ABSDataflowList struct {
 }
 
 // Create a new database pool
-func NewDatabase(ctx context.Context) (*Database, error) {
-	dbURL := os.Getenv("DATABASE_URL")
-	pool, err := pgxpool.New(ctx, dbURL)
+func NewDatabase(ctx context.Context, dbURL string, logger *slog.Logger) (*Database, error) {
+	poolConfig, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = queryTracer{}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
 
-	log.Print("Connected to database using pool")
+	logger.Info("Connected to database using pool")
 
 	return &Database{
-		Pool: pool,
-		Ctx:  ctx,
+		Pool:   pool,
+		Ctx:    ctx,
+		Logger: logger,
 	}, nil
 }
 
@@ -55,7 +62,7 @@ func (db *Database) Close() {
 
 // Upsert CPI data
 func (d *Database) UpsertDataABSCPI(data interface{}) error {
-	log.Print("INSERTING ABS CPI data")
+	d.Logger.Debug("Inserting ABS CPI data")
 
 	cpiData, ok := data.(ABS_CPI)
 	if !ok {
@@ -78,7 +85,7 @@ func (d *Database) UpsertDataABSCPI(data interface{}) error {
 
 // Get ABS dataflows
 func (d *Database) GetABSDataflow(query string) ([]ABSDataflow, error) {
-	log.Print("Fetching ABS dataflow list with query: ", query)
+	d.Logger.Debug("Fetching ABS dataflow list", "query", query)
 
 	var absDataflows []ABSDataflow
 	rows, err := d.Pool.Query(d.Ctx, query)
@@ -108,3 +115,23 @@ func (d *Database) GetABSDataflow(query string) ([]ABSDataflow, error) {
 
 	return absDataflows, nil
 }
+
+// ListDataflows returns the whole static dataflow catalogue
+func (d *Database) ListDataflows() ([]ABSDataflow, error) {
+	return d.GetABSDataflow(`SELECT id, version, agency_id, is_external_reference, is_final, name FROM abs_static_dataflow`)
+}
+
+// CatalogueStatus reports how many dataflows are stored and when the newest
+// was last synced from the ABS
+func (d *Database) CatalogueStatus(ctx context.Context) (int, time.Time, error) {
+	var count int
+	var updated *time.Time
+	err := d.Pool.QueryRow(ctx, `SELECT COUNT(*), MAX(updated_at) FROM abs_static_dataflow`).Scan(&count, &updated)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("reading catalogue status: %w", err)
+	}
+	if updated == nil {
+		return count, time.Time{}, nil
+	}
+	return count, *updated, nil
+}
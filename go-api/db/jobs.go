@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Job is a row of the jobs table, the jobs package keeps its own type
+type Job struct {
+	ID       string
+	Kind     string
+	Title    string
+	DedupKey string
+	Params   json.RawMessage
+	State    string
+	Stage    string
+	Message  string
+	Error    string
+	Result   json.RawMessage
+	Summary  string
+	Attempts int
+	// FinishedAt is nil while the job is queued or running
+	FinishedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// JobFilter narrows ListJobs, empty fields match everything
+type JobFilter struct {
+	Kind  string
+	State string
+	Limit int
+}
+
+const jobColumns = `id, kind, title, dedup_key, params, state, stage, message, error, result, summary, attempts, finished_at, created_at, updated_at`
+
+func scanJob(row pgx.Row) (Job, error) {
+	var job Job
+	err := row.Scan(&job.ID, &job.Kind, &job.Title, &job.DedupKey, &job.Params, &job.State, &job.Stage,
+		&job.Message, &job.Error, &job.Result, &job.Summary, &job.Attempts, &job.FinishedAt, &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("error scanning row: %w", err)
+	}
+	return job, nil
+}
+
+func (d *Database) InsertJob(ctx context.Context, job Job) error {
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO jobs (id, kind, title, dedup_key, params, state, stage, message, attempts, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		job.ID, job.Kind, job.Title, job.DedupKey, job.Params, job.State, job.Stage, job.Message, job.Attempts, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("inserting job: %w", err)
+	}
+	return nil
+}
+
+// UpdateJob saves the state, progress and outcome of a job
+func (d *Database) UpdateJob(ctx context.Context, job Job) error {
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE jobs SET state = $2, stage = $3, message = $4, error = $5, result = $6, summary = $7,
+	attempts = $8, finished_at = $9, updated_at = $10
+WHERE id = $1`,
+		job.ID, job.State, job.Stage, job.Message, job.Error, job.Result, job.Summary, job.Attempts, job.FinishedAt, job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("updating job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (d *Database) GetJob(ctx context.Context, id string) (Job, error) {
+	return scanJob(d.Pool.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+}
+
+// ListJobs returns jobs newest first
+func (d *Database) ListJobs(ctx context.Context, filter JobFilter) ([]Job, error) {
+	var where []string
+	var args []any
+	if filter.Kind != "" {
+		args = append(args, filter.Kind)
+		where = append(where, fmt.Sprintf("kind = $%d", len(args)))
+	}
+	if filter.State != "" {
+		args = append(args, filter.State)
+		where = append(where, fmt.Sprintf("state = $%d", len(args)))
+	}
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY created_at DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return d.queryJobs(ctx, query, args...)
+}
+
+// UnfinishedJobs are those queued or running when the server last stopped,
+// oldest first so they run again in the order they were asked for
+func (d *Database) UnfinishedJobs(ctx context.Context) ([]Job, error) {
+	return d.queryJobs(ctx, `SELECT `+jobColumns+` FROM jobs WHERE finished_at IS NULL ORDER BY created_at`)
+}
+
+// DeleteJobsBefore removes jobs that finished before t
+func (d *Database) DeleteJobsBefore(ctx context.Context, t time.Time) (int64, error) {
+	tag, err := d.Pool.Exec(ctx, `DELETE FROM jobs WHERE finished_at < $1`, t)
+	if err != nil {
+		return 0, fmt.Errorf("deleting jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (d *Database) queryJobs(ctx context.Context, query string, args ...any) ([]Job, error) {
+	rows, err := d.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"fmt"
+)
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations are applied in order and recorded in schema_migrations, never
+// edit one that has shipped - add a new one instead
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "abs static tables",
+		sql: `
+CREATE TABLE IF NOT EXISTS abs_static_dataflow (
+	id                    TEXT NOT NULL,
+	version               TEXT NOT NULL,
+	agency_id             TEXT NOT NULL,
+	is_external_reference BOOLEAN NOT NULL DEFAULT FALSE,
+	is_final              BOOLEAN NOT NULL DEFAULT FALSE,
+	name                  TEXT NOT NULL,
+	PRIMARY KEY (id, version)
+);
+CREATE TABLE IF NOT EXISTS "ABS_CPI" (
+	TIME_PERIOD TEXT PRIMARY KEY,
+	VALUE       DOUBLE PRECISION
+);`,
+	},
+	{
+		version: 2,
+		name:    "saved dashboards",
+		sql: `
+CREATE TABLE IF NOT EXISTS dashboards (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	panels     JSONB NOT NULL DEFAULT '[]',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);`,
+	},
+	{
+		version: 3,
+		name:    "dataflow catalogue freshness",
+		sql: `
+ALTER TABLE abs_static_dataflow ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();`,
+	},
+	{
+		version: 4,
+		name:    "stored observations",
+		sql: `
+CREATE TABLE IF NOT EXISTS abs_observations (
+	dataflow_id  TEXT NOT NULL,
+	series_key   TEXT NOT NULL,
+	period       TEXT NOT NULL,
+	value        DOUBLE PRECISION NOT NULL,
+	region       TEXT NOT NULL DEFAULT '',
+	measure      TEXT NOT NULL DEFAULT '',
+	unit         TEXT NOT NULL DEFAULT '',
+	dimensions   JSONB NOT NULL DEFAULT '{}',
+	attributes   JSONB NOT NULL DEFAULT '{}',
+	labels       JSONB NOT NULL DEFAULT '{}',
+	retrieved_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (dataflow_id, series_key, period)
+);`,
+	},
+	{
+		version: 5,
+		name:    "jobs",
+		sql: `
+CREATE TABLE IF NOT EXISTS jobs (
+	id          TEXT PRIMARY KEY,
+	kind        TEXT NOT NULL,
+	title       TEXT NOT NULL,
+	dedup_key   TEXT NOT NULL,
+	params      JSONB NOT NULL DEFAULT '{}',
+	state       TEXT NOT NULL,
+	stage       TEXT NOT NULL DEFAULT '',
+	message     TEXT NOT NULL DEFAULT '',
+	error       TEXT NOT NULL DEFAULT '',
+	result      JSONB,
+	summary     TEXT NOT NULL DEFAULT '',
+	attempts    INTEGER NOT NULL DEFAULT 0,
+	created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+	finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS jobs_created_at ON jobs (created_at DESC);
+-- one queued or running job per request, identical requests join it
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_active_dedup_key ON jobs (dedup_key) WHERE finished_at IS NULL;`,
+	},
+	{
+		version: 6,
+		name:    "abs_retrievals",
+		sql: `
+-- which queries abs_observations holds the answer to and when they were asked,
+-- observations stored before this table existed are fetched again when needed
+CREATE TABLE IF NOT EXISTS abs_retrievals (
+	dataflow_id  TEXT NOT NULL,
+	data_key     TEXT NOT NULL,
+	start_period TEXT NOT NULL DEFAULT '',
+	end_period   TEXT NOT NULL DEFAULT '',
+	dimensions   TEXT[] NOT NULL DEFAULT '{}',
+	attributes   TEXT[] NOT NULL DEFAULT '{}',
+	observations INTEGER NOT NULL DEFAULT 0,
+	retrieved_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (dataflow_id, data_key, start_period, end_period)
+);`,
+	},
+}
+
+// Migrate brings the schema up to date
+func (d *Database) Migrate() error {
+	_, err := d.Pool.Exec(d.Ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var current int
+	err = d.Pool.QueryRow(d.Ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := d.Pool.Begin(d.Ctx)
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+		if _, err := tx.Exec(d.Ctx, m.sql); err != nil {
+			tx.Rollback(d.Ctx)
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(d.Ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
+			tx.Rollback(d.Ctx)
+			return fmt.Errorf("recording migration %d: %w", m.version, err)
+		}
+		if err := tx.Commit(d.Ctx); err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+		d.Logger.Info("Applied migration", "version", m.version, "name", m.name)
+	}
+	return nil
+}
+
+// SchemaVersion is the newest migration applied
+func (d *Database) SchemaVersion() (int, error) {
+	var version int
+	err := d.Pool.QueryRow(d.Ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
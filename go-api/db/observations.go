@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Observation is a stored fetch.Observation, db can't import fetch
+type Observation struct {
+	DataflowID string
+	SeriesKey  string
+	Period     string
+	Value      float64
+	Region     string
+	Measure    string
+	Unit       string
+	Dimensions map[string]string
+	Attributes map[string]string
+	Labels     map[string]string
+}
+
+// StoreObservations upserts observations in one transaction, a period the
+// ABS has revised overwrites the stored value
+func (d *Database) StoreObservations(ctx context.Context, observations []Observation, retrieved time.Time) error {
+	batch := &pgx.Batch{}
+	if err := queueObservations(batch, observations, retrieved); err != nil {
+		return err
+	}
+	return d.sendBatch(ctx, batch, "storing observations")
+}
+
+func queueObservations(batch *pgx.Batch, observations []Observation, retrieved time.Time) error {
+	for _, o := range observations {
+		dims, err := json.Marshal(emptyIfNil(o.Dimensions))
+		if err != nil {
+			return fmt.Errorf("encoding dimensions: %w", err)
+		}
+		attrs, err := json.Marshal(emptyIfNil(o.Attributes))
+		if err != nil {
+			return fmt.Errorf("encoding attributes: %w", err)
+		}
+		labels, err := json.Marshal(emptyIfNil(o.Labels))
+		if err != nil {
+			return fmt.Errorf("encoding labels: %w", err)
+		}
+		batch.Queue(`
+INSERT INTO abs_observations
+	(dataflow_id, series_key, period, value, region, measure, unit, dimensions, attributes, labels, retrieved_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (dataflow_id, series_key, period) DO UPDATE SET
+	value = EXCLUDED.value, region = EXCLUDED.region, measure = EXCLUDED.measure, unit = EXCLUDED.unit,
+	dimensions = EXCLUDED.dimensions, attributes = EXCLUDED.attributes, labels = EXCLUDED.labels,
+	retrieved_at = EXCLUDED.retrieved_at`,
+			o.DataflowID, o.SeriesKey, o.Period, o.Value, o.Region, o.Measure, o.Unit, dims, attrs, labels, retrieved)
+	}
+	return nil
+}
+
+// sendBatch runs batch in one transaction, what names the work in errors
+func (d *Database) sendBatch(ctx context.Context, batch *pgx.Batch, what string) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	return nil
+}
+
+// ReadObservations returns the stored observations of a dataflow ordered by
+// series and period. seriesPattern is a regular expression the series key
+// must match, "" returns every series.
+func (d *Database) ReadObservations(ctx context.Context, dataflowID, seriesPattern string) ([]Observation, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT series_key, period, value, region, measure, unit, dimensions, attributes, labels
+FROM abs_observations
+WHERE dataflow_id = $1 AND ($2 = '' OR series_key ~ $2)
+ORDER BY series_key, period`, dataflowID, seriesPattern)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	observations := []Observation{}
+	for rows.Next() {
+		o := Observation{DataflowID: dataflowID}
+		if err := rows.Scan(&o.SeriesKey, &o.Period, &o.Value, &o.Region, &o.Measure, &o.Unit,
+			&o.Dimensions, &o.Attributes, &o.Labels); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		observations = append(observations, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return observations, nil
+}
+
+// ObservationKey identifies an observation within a dataflow
+type ObservationKey struct {
+	SeriesKey string
+	Period    string
+}
+
+// StoredValues returns the stored values of some series of a dataflow
+func (d *Database) StoredValues(ctx context.Context, dataflowID string, seriesKeys []string) (map[ObservationKey]float64, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT series_key, period, value FROM abs_observations
+WHERE dataflow_id = $1 AND series_key = ANY($2)`, dataflowID, seriesKeys)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[ObservationKey]float64)
+	for rows.Next() {
+		var key ObservationKey
+		var value float64
+		if err := rows.Scan(&key.SeriesKey, &key.Period, &value); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		values[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return values, nil
+}
+
+func emptyIfNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Retrieval records a data query whose answer is in abs_observations. Key,
+// StartPeriod and EndPeriod are as sent to the ABS, "" periods are unbounded.
+// Dimensions and Attributes keep the column order the ABS returned.
+type Retrieval struct {
+	DataflowID   string
+	Key          string
+	StartPeriod  string
+	EndPeriod    string
+	Dimensions   []string
+	Attributes   []string
+	Observations int
+	RetrievedAt  time.Time
+}
+
+// StoreRetrieval upserts the observations a query returned and records the
+// query in one transaction, so a recorded query always has its observations
+func (d *Database) StoreRetrieval(ctx context.Context, r Retrieval, observations []Observation) error {
+	batch := &pgx.Batch{}
+	if err := queueObservations(batch, observations, r.RetrievedAt); err != nil {
+		return err
+	}
+	batch.Queue(`
+INSERT INTO abs_retrievals
+	(dataflow_id, data_key, start_period, end_period, dimensions, attributes, observations, retrieved_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (dataflow_id, data_key, start_period, end_period) DO UPDATE SET
+	dimensions = EXCLUDED.dimensions, attributes = EXCLUDED.attributes,
+	observations = EXCLUDED.observations, retrieved_at = EXCLUDED.retrieved_at`,
+		r.DataflowID, r.Key, r.StartPeriod, r.EndPeriod, emptySliceIfNil(r.Dimensions), emptySliceIfNil(r.Attributes),
+		r.Observations, r.RetrievedAt)
+	return d.sendBatch(ctx, batch, "storing retrieval")
+}
+
+// Retrievals lists the recorded queries of a dataflow, or of every dataflow
+// when dataflowID is "", newest first
+func (d *Database) Retrievals(ctx context.Context, dataflowID string) ([]Retrieval, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT dataflow_id, data_key, start_period, end_period, dimensions, attributes, observations, retrieved_at
+FROM abs_retrievals
+WHERE $1 = '' OR dataflow_id = $1
+ORDER BY retrieved_at DESC`, dataflowID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	retrievals := []Retrieval{}
+	for rows.Next() {
+		var r Retrieval
+		if err := rows.Scan(&r.DataflowID, &r.Key, &r.StartPeriod, &r.EndPeriod, &r.Dimensions, &r.Attributes,
+			&r.Observations, &r.RetrievedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		retrievals = append(retrievals, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return retrievals, nil
+}
+
+func emptySliceIfNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+	"github.com/jackc/pgx/v5"
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	started   time.Time
+	operation string
+}
+
+// queryTracer records the latency and errors of every query as Postgres
+// upstream calls, labelled by statement type
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{started: time.Now(), operation: statementType(data.SQL)})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	metrics.ObserveUpstream("postgres", start.operation, start.started, data.Err)
+}
+
+// statementType is the leading keyword, SELECT, INSERT, CREATE...
+func statementType(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "OTHER"
+	}
+	op := strings.ToUpper(fields[0])
+	for _, r := range op {
+		if r < 'A' || r > 'Z' {
+			return "OTHER"
+		}
+	}
+	return op
+}
@@ -0,0 +1,21 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+func writeCSV(w io.Writer, t *table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.columns); err != nil {
+		return fmt.Errorf("writing csv header: %w", err)
+	}
+	for _, row := range t.rows {
+		if err := cw.Write(row.cells); err != nil {
+			return fmt.Errorf("writing csv row: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
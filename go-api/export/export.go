@@ -0,0 +1,118 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	XLSX    Format = "xlsx"
+	Parquet Format = "parquet"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case "", CSV:
+		return CSV, nil
+	case XLSX:
+		return XLSX, nil
+	case Parquet:
+		return Parquet, nil
+	}
+	return "", fmt.Errorf("invalid export format: %s", s)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Filename is the download name for a dataset, eg. CPI_20251019T130303Z.csv
+func Filename(ds *fetch.Dataset, f Format) string {
+	id := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, ds.DataflowID)
+	return fmt.Sprintf("%s_%s.%s", id, ds.RetrievedAt.UTC().Format("20060102T150405Z"), f)
+}
+
+// Write encodes the dataset in the given format
+func Write(w io.Writer, f Format, ds *fetch.Dataset) error {
+	t := newTable(ds)
+	switch f {
+	case XLSX:
+		return writeXLSX(w, t, metadata(ds))
+	case Parquet:
+		return writeParquet(w, t, metadata(ds))
+	}
+	return writeCSV(w, t)
+}
+
+// table is the flat export layout shared by every format: a code and label
+// column per dimension, the period and value, then a code and label column
+// per attribute (UNIT_MEASURE, UNIT_MULT, OBS_STATUS...).
+type table struct {
+	columns  []string
+	valueCol int
+	rows     []tableRow
+}
+
+type tableRow struct {
+	cells []string
+	value float64
+}
+
+func newTable(ds *fetch.Dataset) *table {
+	t := &table{}
+	for _, id := range ds.Dimensions {
+		t.columns = append(t.columns, id, id+"_LABEL")
+	}
+	t.columns = append(t.columns, "TIME_PERIOD", "OBS_VALUE")
+	t.valueCol = len(t.columns) - 1
+	for _, id := range ds.Attributes {
+		t.columns = append(t.columns, id, id+"_LABEL")
+	}
+
+	for _, obs := range ds.Observations {
+		cells := make([]string, 0, len(t.columns))
+		for _, id := range ds.Dimensions {
+			cells = append(cells, obs.Dimensions[id], obs.Labels[id])
+		}
+		cells = append(cells, obs.Period, formatValue(obs.Value))
+		for _, id := range ds.Attributes {
+			cells = append(cells, obs.Attributes[id], obs.Labels[id])
+		}
+		t.rows = append(t.rows, tableRow{cells: cells, value: obs.Value})
+	}
+	return t
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// metadata describes the query behind an export, written to the parquet
+// key-value metadata and the xlsx About sheet
+func metadata(ds *fetch.Dataset) [][2]string {
+	return [][2]string{
+		{"dataflowid", ds.DataflowID},
+		{"key", ds.Key},
+		{"retrieved_at", ds.RetrievedAt.UTC().Format(time.RFC3339)},
+		{"source", "https://" + fetch.ABSHost},
+	}
+}
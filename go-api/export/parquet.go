@@ -0,0 +1,287 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Uncompressed, PLAIN encoded parquet with a single row group. Every column is
+// REQUIRED so no definition or repetition levels are written: OBS_VALUE is a
+// DOUBLE and everything else a UTF8 BYTE_ARRAY. The file metadata is thrift
+// compact protocol, written by hand to keep the binary free of a parquet library.
+// https://github.com/apache/parquet-format
+
+const parquetMagic = "PAR1"
+
+// parquet.thrift enums
+const (
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	parquetRepetitionRequired = 0
+	parquetConvertedUTF8      = 0
+	parquetEncodingPlain      = 0
+	parquetEncodingRLE        = 3
+	parquetCodecUncompressed  = 0
+	parquetPageData           = 0
+)
+
+type parquetColumn struct {
+	name       string
+	typ        int32
+	numValues  int64
+	dataOffset int64
+	size       int64
+}
+
+func writeParquet(w io.Writer, t *table, meta [][2]string) error {
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	if _, err := io.WriteString(out, parquetMagic); err != nil {
+		return err
+	}
+
+	columns := make([]parquetColumn, len(t.columns))
+	var page bytes.Buffer
+	for i, name := range t.columns {
+		col := parquetColumn{name: name, typ: parquetTypeByteArray, numValues: int64(len(t.rows))}
+		if i == t.valueCol {
+			col.typ = parquetTypeDouble
+		}
+
+		page.Reset()
+		var scratch [8]byte
+		for _, row := range t.rows {
+			if i == t.valueCol {
+				binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(row.value))
+				page.Write(scratch[:8])
+				continue
+			}
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(row.cells[i])))
+			page.Write(scratch[:4])
+			page.WriteString(row.cells[i])
+		}
+
+		header := &thriftWriter{}
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(len(t.rows)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.stop()
+
+		col.dataOffset = out.n
+		if _, err := out.Write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := out.Write(page.Bytes()); err != nil {
+			return err
+		}
+		col.size = out.n - col.dataOffset
+		columns[i] = col
+	}
+
+	footer := parquetFooter(columns, int64(len(t.rows)), meta)
+	if _, err := out.Write(footer); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	if _, err := out.Write(length[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(out, parquetMagic); err != nil {
+		return err
+	}
+	return out.w.Flush()
+}
+
+func parquetFooter(columns []parquetColumn, numRows int64, meta [][2]string) []byte {
+	var totalSize int64
+	for _, c := range columns {
+		totalSize += c.size
+	}
+
+	t := &thriftWriter{}
+	t.i32(1, 1) // version
+
+	// schema is a flattened tree, root first
+	t.listHeader(2, thriftStruct, len(columns)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endElem()
+	for _, c := range columns {
+		t.beginElem()
+		t.i32(1, c.typ)
+		t.i32(3, parquetRepetitionRequired)
+		t.binary(4, c.name)
+		if c.typ == parquetTypeByteArray {
+			t.i32(6, parquetConvertedUTF8)
+		}
+		t.endElem()
+	}
+
+	t.i64(3, numRows)
+
+	rowGroups := 1
+	if numRows == 0 {
+		rowGroups = 0
+	}
+	t.listHeader(4, thriftStruct, rowGroups)
+	if rowGroups == 1 {
+		t.beginElem()
+		t.listHeader(1, thriftStruct, len(columns))
+		for _, c := range columns {
+			t.beginElem()
+			t.i64(2, c.dataOffset)
+			t.beginStruct(3)
+			t.i32(1, c.typ)
+			t.listHeader(2, thriftI32, 2)
+			t.rawI32(parquetEncodingPlain)
+			t.rawI32(parquetEncodingRLE)
+			t.listHeader(3, thriftBinary, 1)
+			t.rawBinary(c.name)
+			t.i32(4, parquetCodecUncompressed)
+			t.i64(5, c.numValues)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.dataOffset)
+			t.endStruct()
+			t.endElem()
+		}
+		t.i64(2, totalSize)
+		t.i64(3, numRows)
+		t.endElem()
+	}
+
+	if len(meta) > 0 {
+		t.listHeader(5, thriftStruct, len(meta))
+		for _, kv := range meta {
+			t.beginElem()
+			t.binary(1, kv[0])
+			t.binary(2, kv[1])
+			t.endElem()
+		}
+	}
+	t.binary(6, "abs-visualiser")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes just enough of the thrift compact protocol for parquet
+// metadata. Field ids are delta encoded against the previous field of the
+// enclosing struct, so nested structs push the last id onto a stack.
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastID  int16
+	idStack []int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	delta := id - t.lastID
+	if delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(uint64(zigzag(int64(id))))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.rawI32(v)
+}
+
+func (t *thriftWriter) rawI32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.rawBinary(v)
+}
+
+func (t *thriftWriter) rawBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+func (t *thriftWriter) listHeader(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.varint(uint64(size))
+}
+
+// beginStruct starts a struct valued field
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginElem()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.endElem()
+}
+
+// beginElem starts a struct inside a list, which has no field header
+func (t *thriftWriter) beginElem() {
+	t.idStack = append(t.idStack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) endElem() {
+	t.stop()
+	t.lastID = t.idStack[len(t.idStack)-1]
+	t.idStack = t.idStack[:len(t.idStack)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,257 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+// thriftStructValue is a decoded compact protocol struct by field id. i32 and
+// i64 fields are int64, binary fields string, lists []any and structs
+// thriftStructValue.
+type thriftStructValue map[int16]any
+
+// thriftReader decodes the subset of the compact protocol thriftWriter writes
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		panic(fmt.Sprintf("bad varint at %d", r.pos))
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		s := string(r.b[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		header := r.byte()
+		size, elem := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.structValue()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d at %d", typ, r.pos))
+}
+
+func (r *thriftReader) structValue() thriftStructValue {
+	s := thriftStructValue{}
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return s
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+func testDataset() *fetch.Dataset {
+	return &fetch.Dataset{
+		DataflowID:  "CPI",
+		Key:         "1.10001.10.50.Q",
+		Dimensions:  []string{"REGION"},
+		Attributes:  []string{"UNIT_MEASURE"},
+		RetrievedAt: time.Date(2025, 10, 19, 13, 3, 3, 0, time.UTC),
+		Observations: []fetch.Observation{
+			{Period: "2024-Q1", Value: 1.5, Dimensions: map[string]string{"REGION": "50"}, Labels: map[string]string{"REGION": "Australia", "UNIT_MEASURE": "Index"}, Attributes: map[string]string{"UNIT_MEASURE": "IN"}},
+			{Period: "2024-Q2", Value: -0.25, Dimensions: map[string]string{"REGION": "50"}, Labels: map[string]string{"REGION": "Australia & <NZ>"}, Attributes: map[string]string{}},
+		},
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Parquet, testDataset()); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatalf("file doesn't start and end with PAR1")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footerStart := len(file) - 8 - footerLen
+	if footerStart < 4 {
+		t.Fatalf("footer length %d doesn't fit a %d byte file", footerLen, len(file))
+	}
+	r := &thriftReader{b: file[footerStart : len(file)-8]}
+	meta := r.structValue()
+	if r.pos != footerLen {
+		t.Errorf("footer decoded to byte %d of %d", r.pos, footerLen)
+	}
+
+	if meta[1] != int64(1) {
+		t.Errorf("version %v, want 1", meta[1])
+	}
+	if meta[3] != int64(2) {
+		t.Errorf("num_rows %v, want 2", meta[3])
+	}
+
+	wantColumns := []struct {
+		name string
+		typ  int64
+	}{
+		{"REGION", parquetTypeByteArray},
+		{"REGION_LABEL", parquetTypeByteArray},
+		{"TIME_PERIOD", parquetTypeByteArray},
+		{"OBS_VALUE", parquetTypeDouble},
+		{"UNIT_MEASURE", parquetTypeByteArray},
+		{"UNIT_MEASURE_LABEL", parquetTypeByteArray},
+	}
+	schema := meta[2].([]any)
+	root := schema[0].(thriftStructValue)
+	if root[4] != "schema" || root[5] != int64(len(wantColumns)) {
+		t.Errorf("schema root %v, want schema with %d children", root, len(wantColumns))
+	}
+	for i, want := range wantColumns {
+		el := schema[i+1].(thriftStructValue)
+		if el[4] != want.name || el[1] != want.typ || el[3] != int64(parquetRepetitionRequired) {
+			t.Errorf("schema element %d %v, want required %s of type %d", i, el, want.name, want.typ)
+		}
+		if _, utf8 := el[6]; utf8 != (want.typ == parquetTypeByteArray) {
+			t.Errorf("column %s UTF8 annotation %v", want.name, utf8)
+		}
+	}
+
+	rowGroups := meta[4].([]any)
+	if len(rowGroups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(rowGroups))
+	}
+	group := rowGroups[0].(thriftStructValue)
+	chunks := group[1].([]any)
+	if len(chunks) != len(wantColumns) || group[3] != int64(2) {
+		t.Fatalf("row group has %d columns and %v rows", len(chunks), group[3])
+	}
+	var total int64
+	end := int64(4)
+	for i, c := range chunks {
+		chunk := c.(thriftStructValue)
+		cm := chunk[3].(thriftStructValue)
+		offset, size := chunk[2].(int64), cm[6].(int64)
+		if offset != end || cm[9] != offset {
+			t.Errorf("column %d at %d (data page %v), want %d", i, offset, cm[9], end)
+		}
+		if cm[3].([]any)[0] != wantColumns[i].name || cm[5] != int64(2) {
+			t.Errorf("column %d metadata %v", i, cm)
+		}
+		end += size
+		total += size
+
+		// the page header and PLAIN values the offset points at
+		page := &thriftReader{b: file[offset : offset+size]}
+		header := page.structValue()
+		values := page.b[page.pos:]
+		if header[1] != int64(parquetPageData) || header[2] != int64(len(values)) || header[5].(thriftStructValue)[1] != int64(2) {
+			t.Errorf("column %d page header %v for %d bytes of values", i, header, len(values))
+		}
+		if wantColumns[i].name == "OBS_VALUE" {
+			got := []float64{
+				math.Float64frombits(binary.LittleEndian.Uint64(values[:8])),
+				math.Float64frombits(binary.LittleEndian.Uint64(values[8:])),
+			}
+			if got[0] != 1.5 || got[1] != -0.25 {
+				t.Errorf("OBS_VALUE %v, want [1.5 -0.25]", got)
+			}
+		}
+		if wantColumns[i].name == "REGION_LABEL" {
+			n := binary.LittleEndian.Uint32(values)
+			if string(values[4:4+n]) != "Australia" {
+				t.Errorf("first REGION_LABEL %q", values[4:4+n])
+			}
+		}
+	}
+	if end != int64(footerStart) || group[2] != total {
+		t.Errorf("column chunks end at %d with total %v, want %d and %d", end, group[2], footerStart, total)
+	}
+
+	kv := map[string]string{}
+	for _, e := range meta[5].([]any) {
+		kv[e.(thriftStructValue)[1].(string)] = e.(thriftStructValue)[2].(string)
+	}
+	if kv["dataflowid"] != "CPI" || kv["key"] != "1.10001.10.50.Q" || kv["retrieved_at"] != "2025-10-19T13:03:03Z" {
+		t.Errorf("key value metadata %v", kv)
+	}
+	if meta[6] != "abs-visualiser" {
+		t.Errorf("created_by %v", meta[6])
+	}
+}
+
+func TestWriteParquetEmpty(t *testing.T) {
+	ds := testDataset()
+	ds.Observations = nil
+	var buf bytes.Buffer
+	if err := Write(&buf, Parquet, ds); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := (&thriftReader{b: file[len(file)-8-footerLen : len(file)-8]}).structValue()
+	if meta[3] != int64(0) || len(meta[4].([]any)) != 0 {
+		t.Errorf("empty export has %v rows in %d row groups, want none", meta[3], len(meta[4].([]any)))
+	}
+}
+
+func TestThriftFieldHeaders(t *testing.T) {
+	// a jump of more than 15 ids can't be delta encoded
+	w := &thriftWriter{}
+	w.i32(1, -3)
+	w.i64(20, 1<<40)
+	w.binary(21, "x")
+	w.stop()
+	got := (&thriftReader{b: w.buf.Bytes()}).structValue()
+	if got[1] != int64(-3) || got[20] != int64(1<<40) || got[21] != "x" {
+		t.Errorf("decoded %v", got)
+	}
+
+	// lists of 15 or more carry their size separately
+	w = &thriftWriter{}
+	w.listHeader(1, thriftI32, 20)
+	for i := range 20 {
+		w.rawI32(int32(i))
+	}
+	w.stop()
+	list := (&thriftReader{b: w.buf.Bytes()}).structValue()[1].([]any)
+	if len(list) != 20 || list[19] != int64(19) {
+		t.Errorf("decoded list %v", list)
+	}
+}
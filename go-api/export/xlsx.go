@@ -0,0 +1,176 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Minimal SpreadsheetML package: one data sheet and one sheet describing the
+// query. Strings are written inline so no shared string table is needed and
+// rows can be streamed straight into the zip.
+// https://learn.microsoft.com/en-us/office/open-xml/spreadsheet/structure-of-a-spreadsheetml-document
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>
+<sheet name="Data" sheetId="1" r:id="rId1"/>
+<sheet name="About" sheetId="2" r:id="rId2"/>
+</sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+</Relationships>`
+
+func writeXLSX(w io.Writer, t *table, about [][2]string) error {
+	zw := zip.NewWriter(w)
+
+	static := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range static {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", part.name, err)
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return fmt.Errorf("writing %s: %w", part.name, err)
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return fmt.Errorf("creating data sheet: %w", err)
+	}
+	sheet := newSheetWriter(f)
+	sheet.stringRow(t.columns)
+	for _, row := range t.rows {
+		sheet.startRow()
+		for i, cell := range row.cells {
+			if i == t.valueCol {
+				sheet.number(row.value)
+			} else {
+				sheet.string(cell)
+			}
+		}
+		sheet.endRow()
+	}
+	if err := sheet.close(); err != nil {
+		return fmt.Errorf("writing data sheet: %w", err)
+	}
+
+	f, err = zw.Create("xl/worksheets/sheet2.xml")
+	if err != nil {
+		return fmt.Errorf("creating about sheet: %w", err)
+	}
+	sheet = newSheetWriter(f)
+	for _, kv := range about {
+		sheet.stringRow(kv[:])
+	}
+	if err := sheet.close(); err != nil {
+		return fmt.Errorf("writing about sheet: %w", err)
+	}
+
+	return zw.Close()
+}
+
+type sheetWriter struct {
+	w   *bufio.Writer
+	row int
+	col int
+	err error
+}
+
+func newSheetWriter(w io.Writer) *sheetWriter {
+	s := &sheetWriter{w: bufio.NewWriter(w)}
+	s.write(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return s
+}
+
+func (s *sheetWriter) write(str string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(str)
+	}
+}
+
+func (s *sheetWriter) startRow() {
+	s.row++
+	s.col = 0
+	s.write(`<row r="` + strconv.Itoa(s.row) + `">`)
+}
+
+func (s *sheetWriter) endRow() {
+	s.write(`</row>`)
+}
+
+func (s *sheetWriter) ref() string {
+	s.col++
+	return columnName(s.col) + strconv.Itoa(s.row)
+}
+
+func (s *sheetWriter) string(v string) {
+	ref := s.ref()
+	if v == "" {
+		return
+	}
+	s.write(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+	if s.err == nil {
+		s.err = xml.EscapeText(s.w, []byte(v))
+	}
+	s.write(`</t></is></c>`)
+}
+
+func (s *sheetWriter) number(v float64) {
+	s.write(`<c r="` + s.ref() + `"><v>` + formatValue(v) + `</v></c>`)
+}
+
+func (s *sheetWriter) stringRow(cells []string) {
+	s.startRow()
+	for _, c := range cells {
+		s.string(c)
+	}
+	s.endRow()
+}
+
+func (s *sheetWriter) close() error {
+	s.write(`</sheetData></worksheet>`)
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
+
+// columnName converts a 1 based column number to A, B, ... Z, AA, AB...
+func columnName(n int) string {
+	var name []byte
+	for n > 0 {
+		n--
+		name = append([]byte{byte('A' + n%26)}, name...)
+		n /= 26
+	}
+	return string(name)
+}
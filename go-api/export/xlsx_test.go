@@ -0,0 +1,124 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testSheet struct {
+	Rows []struct {
+		R     string `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			T      string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// cells flattens a sheet to its cell references and text
+func (s testSheet) cells() map[string]string {
+	cells := map[string]string{}
+	for _, row := range s.Rows {
+		for _, c := range row.Cells {
+			if c.T == "inlineStr" {
+				cells[c.R] = c.Inline
+			} else {
+				cells[c.R] = "v:" + c.Value
+			}
+		}
+	}
+	return cells
+}
+
+func readPart(t *testing.T, zr *zip.Reader, name string) []byte {
+	t.Helper()
+	f, err := zr.Open(name)
+	if err != nil {
+		t.Fatalf("opening %s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return b
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, XLSX, testDataset()); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	wantNames := []string{
+		"[Content_Types].xml",
+		"_rels/.rels",
+		"xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels",
+		"xl/worksheets/sheet1.xml",
+		"xl/worksheets/sheet2.xml",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("parts %v, want %v", names, wantNames)
+	}
+	for _, part := range wantNames[:4] {
+		if err := xml.Unmarshal(readPart(t, zr, part), new(struct{})); err != nil {
+			t.Errorf("%s isn't well formed: %v", part, err)
+		}
+	}
+
+	raw := readPart(t, zr, "xl/worksheets/sheet1.xml")
+	if !bytes.Contains(raw, []byte(`<c r="D2"><v>1.5</v></c>`)) {
+		t.Errorf("data sheet has no numeric D2 cell: %s", raw)
+	}
+	if !bytes.Contains(raw, []byte(`Australia &amp; &lt;NZ&gt;`)) {
+		t.Errorf("data sheet doesn't escape labels: %s", raw)
+	}
+	var data testSheet
+	if err := xml.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Rows) != 3 || data.Rows[2].R != "3" {
+		t.Fatalf("data sheet has %d rows", len(data.Rows))
+	}
+	wantCells := map[string]string{
+		"A1": "REGION", "B1": "REGION_LABEL", "C1": "TIME_PERIOD", "D1": "OBS_VALUE", "E1": "UNIT_MEASURE", "F1": "UNIT_MEASURE_LABEL",
+		"A2": "50", "B2": "Australia", "C2": "2024-Q1", "D2": "v:1.5", "E2": "IN", "F2": "Index",
+		// empty cells are left out rather than written blank
+		"A3": "50", "B3": "Australia & <NZ>", "C3": "2024-Q2", "D3": "v:-0.25",
+	}
+	if got := data.cells(); !reflect.DeepEqual(got, wantCells) {
+		t.Errorf("data sheet cells\n got %v\nwant %v", got, wantCells)
+	}
+
+	var about testSheet
+	if err := xml.Unmarshal(readPart(t, zr, "xl/worksheets/sheet2.xml"), &about); err != nil {
+		t.Fatal(err)
+	}
+	cells := about.cells()
+	if cells["A1"] != "dataflowid" || cells["B1"] != "CPI" || cells["A2"] != "key" || cells["B2"] != "1.10001.10.50.Q" {
+		t.Errorf("about sheet cells %v", cells)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for n, want := range map[int]string{1: "A", 26: "Z", 27: "AA", 52: "AZ", 53: "BA", 702: "ZZ", 703: "AAA"} {
+		if got := columnName(n); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package fetch
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/flight"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
 )
 
 // FetchData is a generic API client for any service
@@ -12,15 +19,82 @@ type Fetch struct {
 	Scheme string
 	Host   string
 	Port   int
+	// Name labels metrics for this API, defaults to Host
+	Name string
+	// Client is swapped by the server for one that forwards request ids
+	Client *http.Client
+	Logger *slog.Logger
+
+	mu    sync.Mutex
+	stats Stats
+	// datasets coalesces identical data queries, progress is who is
+	// waiting on each
+	datasets   flight.Group[*Dataset]
+	progressMu sync.Mutex
+	progress   map[string]*progressWaiters
+}
+
+// Stats records the outcome of recent requests so health checks can report
+// whether the upstream API is reachable without calling it themselves
+type Stats struct {
+	LastSuccess time.Time     `json:"lastSuccess,omitzero"`
+	LastFailure time.Time     `json:"lastFailure,omitzero"`
+	LastError   string        `json:"lastError,omitempty"`
+	LastLatency time.Duration `json:"-"`
+	Requests    int64         `json:"requests"`
+	Failures    int64         `json:"failures"`
 }
 
 // NewFetchData is the constructor for a generic API client
 func NewFetch(scheme, host string, port int) *Fetch {
-	return &Fetch{
+	f := &Fetch{
 		Scheme: scheme,
 		Host:   host,
 		Port:   port,
+		Name:   host,
+		Client: &http.Client{},
+		Logger: slog.Default(),
 	}
+	f.datasets.Upstream = host
+	return f
+}
+
+// StatusError is returned when the API answers with a non 2xx status
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response failed with status %d: %s", e.Code, e.Body)
+}
+
+func (f *Fetch) Stats() Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+func (f *Fetch) record(path Path, started time.Time, err error) {
+	// a 4xx is a bad query, the API itself was reachable
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code < 500 {
+		err = nil
+	}
+	// /rest/data/ABS,CPI/all is reported as rest/data
+	metrics.ObserveUpstream(f.Name, metrics.PathOperation(path.Endpoint, 2), started, err)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.Requests++
+	f.stats.LastLatency = time.Since(started)
+	if err != nil {
+		f.stats.Failures++
+		f.stats.LastFailure = time.Now()
+		f.stats.LastError = err.Error()
+		return
+	}
+	f.stats.LastSuccess = time.Now()
 }
 
 type Path struct {
@@ -30,7 +104,9 @@ type Path struct {
 }
 
 // HTTP GET from endpoint returns byte array of data for unmarshaling
-func (f *Fetch) Get(path Path) ([]byte, error) {
+func (f *Fetch) Get(ctx context.Context, path Path) (body []byte, err error) {
+	defer func(started time.Time) { f.record(path, started, err) }(time.Now())
+
 	url := url.URL{
 		Scheme: f.Scheme,
 		Host:   f.Host,
@@ -43,27 +119,34 @@ func (f *Fetch) Get(path Path) ([]byte, error) {
 	}
 	url.RawQuery = q.Encode()
 
-	fmt.Println("Fetching:", url.String())
+	f.Logger.DebugContext(ctx, "Fetching", "url", url.String())
 
-	resp, err := http.Get(url.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+
+	resp, err := f.Client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request error: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = readBody(ctx, resp, StageDownloading)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	if resp.StatusCode > 299 {
-		return nil, fmt.Errorf("response failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nil
 }
 
-func (f *Fetch) GetJSONHeader(path Path) ([]byte, error) {
+func (f *Fetch) GetJSONHeader(ctx context.Context, path Path) (body []byte, err error) {
+	defer func(started time.Time) { f.record(path, started, err) }(time.Now())
+
 	url := url.URL{
 		Scheme: f.Scheme,
 		Host:   f.Host,
@@ -76,29 +159,28 @@ func (f *Fetch) GetJSONHeader(path Path) ([]byte, error) {
 	}
 	url.RawQuery = q.Encode()
 
-	fmt.Println("Fetching with JSON header:", url.String())
+	f.Logger.DebugContext(ctx, "Fetching with JSON header", "url", url.String())
 
-	req, err := http.NewRequest("GET", url.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating HTTP request: %w", err)
 	}
 
 	req.Header.Set("Accept", "application/vnd.sdmx.structure+json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := f.Client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request error: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = readBody(ctx, resp, StageStructure)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	if resp.StatusCode > 299 {
-		return nil, fmt.Errorf("response failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nil
@@ -1,82 +1,325 @@
 package fetch
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/VooDooM1234/abs-visualiser/go-api/db"
-	"github.com/gocarina/gocsv"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
 )
 
+// ABSHost is the public ABS SDMX REST API
+const ABSHost = "data.api.abs.gov.au"
+
+// PeriodPattern matches ABS periods: 2024, 2024-Q1, 2024-S1, 2024-01, 2024-W01
+var PeriodPattern = regexp.MustCompile(`^\d{4}(-(Q[1-4]|S[12]|W\d{2}|\d{2}))?$`)
+
+// NewABS is the client for the ABS API shared by the server and the CLI
+func NewABS(logger *slog.Logger) *Fetch {
+	f := NewFetch("https", ABSHost, 443)
+	f.Name = "abs"
+	f.datasets.Upstream = f.Name
+	f.Logger = logger
+	return f
+}
+
+var (
+	catalogueSyncs = metrics.NewCounterVec(
+		"catalogue_sync_total",
+		"Dataflow catalogue syncs from the ABS by result.",
+		"result",
+	)
+	catalogueSyncLastSuccess = metrics.NewGaugeVec(
+		"catalogue_sync_last_success_timestamp_seconds",
+		"Unix time of the last successful dataflow catalogue sync.",
+	)
+)
+
+// Observation is a single value of an ABS series. Dimensions holds the code of
+// every series dimension keyed by dimension ID (MEASURE, REGION, TSEST...),
+// Attributes the codes of attributes such as UNIT_MEASURE and OBS_STATUS, and
+// Labels the human readable names of both.
 type Observation struct {
-	Period  string
-	Value   float64
-	Region  string
-	Measure string
+	Period     string            `json:"period"`
+	Value      float64           `json:"value"`
+	Region     string            `json:"region,omitempty"`
+	Measure    string            `json:"measure,omitempty"`
+	Unit       string            `json:"unit,omitempty"`
+	SeriesKey  string            `json:"seriesKey"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// columns that describe the message rather than the series
+var csvStructureColumns = map[string]bool{
+	"STRUCTURE":      true,
+	"STRUCTURE_ID":   true,
+	"STRUCTURE_NAME": true,
+	"ACTION":         true,
+}
+
+// ABS ids are upper case, labels are not eg. "MEASURE" followed by "Measure"
+func isCSVIDColumn(name string) bool {
+	return name != "" && strings.ToUpper(name) == name && !strings.ContainsRune(name, ' ')
+}
+
+// Where a Dataset was read from
+const (
+	SourceABS      = "abs"
+	SourceDatabase = "database"
+	// SourceStale is the database standing in for an ABS that couldn't be
+	// reached, the observations may be older than the configured max age
+	SourceStale = "database-stale"
+)
+
+// Dataset is the result of one data query. Dimensions and Attributes keep the
+// column order the ABS returned them in, so exports match the source layout.
+// Source is SourceABS, SourceDatabase or SourceStale, RetrievedAt is when the
+// ABS sent the observations either way.
+type Dataset struct {
+	DataflowID   string        `json:"dataflowid"`
+	Key          string        `json:"key"`
+	Dimensions   []string      `json:"dimensions"`
+	Attributes   []string      `json:"attributes"`
+	Source       string        `json:"source"`
+	RetrievedAt  time.Time     `json:"retrievedAt"`
+	Observations []Observation `json:"observations"`
+}
+
+// Clone copies the dataset's slices so transforms on the copy don't change
+// the original, the observations' maps are shared and never changed in place
+func (ds *Dataset) Clone() *Dataset {
+	c := *ds
+	c.Dimensions = slices.Clone(ds.Dimensions)
+	c.Attributes = slices.Clone(ds.Attributes)
+	c.Observations = slices.Clone(ds.Observations)
+	return &c
 }
 
-func (f *Fetch) ABSRestDataCSV(dataflowIdentifier, dataKey string) ([]Observation, error) {
-
-	type csvRow struct {
-		Structure      string `csv:"STRUCTURE"`
-		StructureID    string `csv:"STRUCTURE_ID"`
-		StructureName  string `csv:"STRUCTURE_NAME"`
-		Action         string `csv:"ACTION"`
-		MeasureID      string `csv:"MEASURE"`
-		MeasureName    string `csv:"Measure"`
-		IndexID        string `csv:"INDEX"`
-		IndexName      string `csv:"Index"`
-		TSEST          string `csv:"TSEST"`
-		AdjustmentType string `csv:"Adjustment Type"`
-		RegionID       string `csv:"REGION"`
-		RegionName     string `csv:"Region"`
-		FreqID         string `csv:"FREQ"`
-		FreqName       string `csv:"Frequency"`
-		TimePeriodID   string `csv:"TIME_PERIOD"`
-		TimePeriodName string `csv:"Time Period"`
-		ObsValue       string `csv:"OBS_VALUE"`
-		Observation    string `csv:"Observation Value"`
-	}
-
-	endPoint := fmt.Sprintf("/rest/data/%s/%s", dataflowIdentifier, dataKey)
+// DataQuery selects observations from a dataflow. Key is an SDMX key such as
+// "1.10001.10.50.Q" or "all", StartPeriod and EndPeriod are optional ABS
+// periods (2020, 2020-Q1, 2020-01). UpdatedAfter only asks for observations
+// the ABS has added or revised since then.
+type DataQuery struct {
+	DataflowID   string    `json:"dataflowid"`
+	Key          string    `json:"key"`
+	StartPeriod  string    `json:"startPeriod,omitempty"`
+	EndPeriod    string    `json:"endPeriod,omitempty"`
+	UpdatedAfter time.Time `json:"updatedAfter,omitzero"`
+}
+
+// ABSRestDataCSV gets a dataflow from the ABS in csvfilewithlabels format.
+func (f *Fetch) ABSRestDataCSV(ctx context.Context, dataflowIdentifier, dataKey string) ([]Observation, error) {
+	ds, err := f.ABSRestDataset(ctx, DataQuery{DataflowID: dataflowIdentifier, Key: dataKey})
+	if err != nil {
+		return nil, err
+	}
+	return ds.Observations, nil
+}
+
+// ABSRestDataset is ABSRestDataCSV with the column layout, series attributes
+// (units, observation status...) and retrieval time kept.
+// Concurrent identical queries share one request and one parse, each caller
+// gets its own copy of the dataset. Progress is reported to every caller
+// still waiting.
+func (f *Fetch) ABSRestDataset(ctx context.Context, query DataQuery) (*Dataset, error) {
+	if query.Key == "" {
+		query.Key = "all"
+	}
+	key := strings.Join([]string{query.DataflowID, query.Key, query.StartPeriod, query.EndPeriod, updatedAfter(query)}, "/")
+	report, leave := f.waitProgress(ctx, key)
+	defer leave()
+	ds, shared, err := f.datasets.Do(WithProgress(ctx, report), key, func(ctx context.Context) (*Dataset, error) {
+		return f.absRestDataset(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		f.Logger.DebugContext(ctx, "Joined an identical ABS request", "dataflow", query.DataflowID, "key", query.Key)
+	}
+	return ds.Clone(), nil
+}
+
+func (f *Fetch) absRestDataset(ctx context.Context, query DataQuery) (*Dataset, error) {
+	endPoint := fmt.Sprintf("/rest/data/%s/%s", query.DataflowID, query.Key)
 	path := Path{
 		Endpoint: endPoint,
 		Params: map[string]string{
 			"format": "csvfilewithlabels",
-			"detail": "dataonly",
+			"detail": "full",
 		},
 	}
+	if query.StartPeriod != "" {
+		path.Params["startPeriod"] = query.StartPeriod
+	}
+	if query.EndPeriod != "" {
+		path.Params["endPeriod"] = query.EndPeriod
+	}
+	if !query.UpdatedAfter.IsZero() {
+		path.Params["updatedAfter"] = updatedAfter(query)
+	}
 
-	body, err := f.Get(path)
+	// before asking, so an updatedAfter query from here misses nothing the ABS
+	// published during a long download
+	retrieved := time.Now().UTC()
+	body, err := f.Get(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching ABS CSV: %w", err)
 	}
 
-	var rawRows []*csvRow
-	if err := gocsv.UnmarshalBytes(body, &rawRows); err != nil {
+	reportProgress(ctx, Progress{Stage: StageParsing, Bytes: int64(len(body)), Total: int64(len(body))})
+	ds, err := parseABSCSV(body)
+	if err != nil {
 		return nil, fmt.Errorf("parsing ABS CSV: %w", err)
 	}
+	ds.DataflowID = query.DataflowID
+	ds.Key = query.Key
+	ds.Source = SourceABS
+	ds.RetrievedAt = retrieved
+	return ds, nil
+}
+
+func updatedAfter(query DataQuery) string {
+	if query.UpdatedAfter.IsZero() {
+		return ""
+	}
+	return query.UpdatedAfter.UTC().Format(time.RFC3339)
+}
+
+// parseABSCSV maps the ABS csv layout onto Observation. Every dataflow has its
+// own dimensions so the columns are worked out from the header rather than a
+// fixed struct. SDMX-CSV puts dimensions before TIME_PERIOD and OBS_VALUE and
+// attributes after them.
+func parseABSCSV(body []byte) (*Dataset, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	type idColumn struct {
+		id    string
+		code  int
+		label int
+	}
+	var dims, attrs []idColumn
+	periodCol, valueCol := -1, -1
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		switch {
+		case name == "TIME_PERIOD":
+			periodCol = i
+		case name == "OBS_VALUE":
+			valueCol = i
+		case csvStructureColumns[name] || !isCSVIDColumn(name):
+			continue
+		default:
+			col := idColumn{id: name, code: i, label: -1}
+			if i+1 < len(header) && !isCSVIDColumn(header[i+1]) {
+				col.label = i + 1
+			}
+			if valueCol >= 0 {
+				attrs = append(attrs, col)
+			} else {
+				dims = append(dims, col)
+			}
+		}
+	}
+	if periodCol < 0 || valueCol < 0 {
+		return nil, fmt.Errorf("missing TIME_PERIOD or OBS_VALUE column")
+	}
+
+	ds := &Dataset{}
+	for _, d := range dims {
+		ds.Dimensions = append(ds.Dimensions, d.id)
+	}
+	for _, a := range attrs {
+		ds.Attributes = append(ds.Attributes, a.id)
+	}
 
-	// map to clean domain type
-	observations := make([]Observation, 0, len(rawRows))
-	for _, r := range rawRows {
-		val, _ := strconv.ParseFloat(r.ObsValue, 64)
-		observations = append(observations, Observation{
-			Period:  r.TimePeriodID,
-			Value:   val,
-			Region:  r.RegionName,
-			Measure: r.MeasureName,
-		})
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading row: %w", err)
+		}
+		if valueCol >= len(row) || periodCol >= len(row) {
+			continue
+		}
+		// missing observations are published as an empty OBS_VALUE
+		val, err := strconv.ParseFloat(strings.TrimSpace(row[valueCol]), 64)
+		if err != nil {
+			continue
+		}
+
+		obs := Observation{
+			Period:     row[periodCol],
+			Value:      val,
+			Dimensions: make(map[string]string, len(dims)),
+			Labels:     make(map[string]string, len(dims)+len(attrs)),
+		}
+		keyParts := make([]string, 0, len(dims))
+		for _, d := range dims {
+			if d.code >= len(row) {
+				continue
+			}
+			obs.Dimensions[d.id] = row[d.code]
+			keyParts = append(keyParts, row[d.code])
+			if d.label >= 0 && d.label < len(row) {
+				obs.Labels[d.id] = row[d.label]
+			}
+		}
+		for _, a := range attrs {
+			if a.code >= len(row) || row[a.code] == "" {
+				continue
+			}
+			if obs.Attributes == nil {
+				obs.Attributes = make(map[string]string, len(attrs))
+			}
+			obs.Attributes[a.id] = row[a.code]
+			if a.label >= 0 && a.label < len(row) {
+				obs.Labels[a.id] = row[a.label]
+			}
+		}
+		obs.SeriesKey = strings.Join(keyParts, ".")
+		obs.Region = obs.Labels["REGION"]
+		obs.Measure = obs.Labels["MEASURE"]
+		obs.Unit = obs.Labels["UNIT_MEASURE"]
+		ds.Observations = append(ds.Observations, obs)
 	}
 
-	return observations, nil
+	return ds, nil
 }
 
 // https://data.api.abs.gov.au/rest/dataflow/all?detail=allstubs
 // Help func to load into database, do not use in live server - takes ages to get response from ABS API
-func (f *Fetch) ABSRestDataflowAll(db *db.Database) error {
+// The raw response is also written to snapshot unless it is "".
+func (f *Fetch) ABSRestDataflowAll(ctx context.Context, db *db.Database, snapshot string) (err error) {
+	defer func() {
+		if err != nil {
+			catalogueSyncs.With("failure").Inc()
+			return
+		}
+		catalogueSyncs.With("success").Inc()
+		catalogueSyncLastSuccess.With().Set(float64(time.Now().Unix()))
+	}()
 
 	type ABSDataflow struct {
 		ID                  string `json:"id"`
@@ -101,7 +344,7 @@ func (f *Fetch) ABSRestDataflowAll(db *db.Database) error {
 		},
 	}
 
-	body, err := f.GetJSONHeader(path)
+	body, err := f.GetJSONHeader(ctx, path)
 	if err != nil {
 		return fmt.Errorf("fetching rest/dataflow/all: %w", err)
 	}
@@ -113,7 +356,7 @@ func (f *Fetch) ABSRestDataflowAll(db *db.Database) error {
 
 	//write to static file and database
 	for _, absDataflow := range wrapper.Data.Dataflows {
-		_, err = db.Pool.Exec(db.Ctx,
+		_, err = db.Pool.Exec(ctx,
 			`INSERT INTO "abs_static_dataflow" (
 			id,
 			version,
@@ -127,7 +370,8 @@ func (f *Fetch) ABSRestDataflowAll(db *db.Database) error {
          DO UPDATE SET agency_id = EXCLUDED.agency_id,
 		 			is_external_reference = EXCLUDED.is_external_reference,
 					is_final = EXCLUDED.is_final,
-					name = EXCLUDED.name`,
+					name = EXCLUDED.name,
+					updated_at = now()`,
 			absDataflow.ID, absDataflow.Version, absDataflow.AgencyID, absDataflow.IsExternalReference, absDataflow.IsFinal, absDataflow.Name,
 		)
 
@@ -135,12 +379,11 @@ func (f *Fetch) ABSRestDataflowAll(db *db.Database) error {
 			return fmt.Errorf("upsert failed: %w", err)
 		}
 	}
-	// log.print(res)
-
-	err = os.WriteFile("../static/data/ABSDataflowAll.json", body, 0777)
-	if err != nil {
-		return fmt.Errorf("Error writing to JSON %w", err)
+	if snapshot == "" {
+		return nil
 	}
-
-	return err
+	if err := os.WriteFile(snapshot, body, 0644); err != nil {
+		return fmt.Errorf("writing catalogue snapshot: %w", err)
+	}
+	return nil
 }
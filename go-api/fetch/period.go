@@ -0,0 +1,55 @@
+package fetch
+
+import (
+	"strconv"
+	"time"
+)
+
+// PeriodBounds is the time an ABS period covers, from start up to but not
+// including end. ok is false for anything PeriodPattern doesn't match.
+func PeriodBounds(p string) (start, end time.Time, ok bool) {
+	if !PeriodPattern.MatchString(p) {
+		return time.Time{}, time.Time{}, false
+	}
+	year, _ := strconv.Atoi(p[:4])
+	if len(p) == 4 {
+		start = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), true
+	}
+
+	n, _ := strconv.Atoi(p[6:])
+	switch p[5] {
+	case 'Q':
+		start = time.Date(year, time.Month(3*n-2), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0), true
+	case 'S':
+		start = time.Date(year, time.Month(6*n-5), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 6, 0), true
+	case 'W':
+		// ISO weeks, week 1 is the one with the year's first Thursday
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		monday := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7)
+		start = monday.AddDate(0, 0, 7*(n-1))
+		return start, start.AddDate(0, 0, 7), true
+	}
+	n, _ = strconv.Atoi(p[5:])
+	start = time.Date(year, time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0), true
+}
+
+// InPeriods reports whether period falls between the query's StartPeriod and
+// EndPeriod the way the ABS reads them, 2020-Q2 starts a monthly query at
+// 2020-04 and an end period of 2020 takes in the whole year
+func (q DataQuery) InPeriods(period string) bool {
+	start, _, ok := PeriodBounds(period)
+	if !ok {
+		return false
+	}
+	if from, _, ok := PeriodBounds(q.StartPeriod); ok && start.Before(from) {
+		return false
+	}
+	if _, to, ok := PeriodBounds(q.EndPeriod); ok && !start.Before(to) {
+		return false
+	}
+	return true
+}
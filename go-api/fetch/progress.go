@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// stages a fetch reports through WithProgress
+const (
+	StageStructure   = "structure"
+	StageDownloading = "downloading"
+	StageParsing     = "parsing"
+)
+
+// Progress is where a fetch has got to. Bytes is how much of the response
+// has arrived and Total its Content-Length, -1 when not sent.
+type Progress struct {
+	Stage string
+	Bytes int64
+	Total int64
+}
+
+type ProgressFunc func(Progress)
+
+type progressKey struct{}
+
+// WithProgress has fetches made with ctx report their stages to fn, so a job
+// can show what a long download is doing
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, p Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(p)
+	}
+}
+
+// progressWaiters passes the progress of a shared fetch on to the callers
+// waiting on it, one that has stopped waiting hears nothing more
+type progressWaiters struct {
+	mu      sync.Mutex
+	next    int
+	waiters map[int]ProgressFunc
+}
+
+func (w *progressWaiters) report(p Progress) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, fn := range w.waiters {
+		if fn != nil {
+			fn(p)
+		}
+	}
+}
+
+// waitProgress adds the caller's ProgressFunc to those hearing about the
+// fetch for key. It returns the ProgressFunc to make the fetch with and leave,
+// to call once the caller has stopped waiting.
+func (f *Fetch) waitProgress(ctx context.Context, key string) (report ProgressFunc, leave func()) {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+
+	f.progressMu.Lock()
+	if f.progress == nil {
+		f.progress = make(map[string]*progressWaiters)
+	}
+	w, ok := f.progress[key]
+	if !ok {
+		w = &progressWaiters{waiters: make(map[int]ProgressFunc)}
+		f.progress[key] = w
+	}
+	w.mu.Lock()
+	id := w.next
+	w.next++
+	w.waiters[id] = fn
+	w.mu.Unlock()
+	f.progressMu.Unlock()
+
+	return w.report, func() {
+		f.progressMu.Lock()
+		defer f.progressMu.Unlock()
+		// waits for a report in progress, so fn isn't called after leave
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.waiters, id)
+		if len(w.waiters) == 0 && f.progress[key] == w {
+			delete(f.progress, key)
+		}
+	}
+}
+
+// readBody reads the response, reporting the bytes under stage as they
+// arrive when ctx has a ProgressFunc
+func readBody(ctx context.Context, resp *http.Response, stage string) ([]byte, error) {
+	fn, ok := ctx.Value(progressKey{}).(ProgressFunc)
+	if !ok {
+		return io.ReadAll(resp.Body)
+	}
+	fn(Progress{Stage: stage, Total: resp.ContentLength})
+	return io.ReadAll(&progressReader{r: resp.Body, stage: stage, total: resp.ContentLength, fn: fn})
+}
+
+type progressReader struct {
+	r     io.Reader
+	stage string
+	n     int64
+	total int64
+	fn    ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.fn(Progress{Stage: p.stage, Bytes: p.n, Total: p.total})
+	}
+	return n, err
+}
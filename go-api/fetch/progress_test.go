@@ -0,0 +1,87 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSharedFetchProgress has two callers share one download, the first
+// gives up part way and must hear nothing after, the second hears it all
+func TestSharedFetchProgress(t *testing.T) {
+	firstChunk, finish := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "DATAFLOW,FREQ,TIME_PERIOD,OBS_VALUE\n")
+		w.(http.Flusher).Flush()
+		close(firstChunk)
+		<-finish
+		io.WriteString(w, "ABS:CPI,Q,2024-Q1,1.5\n")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	f := NewFetch("http", u.Host, 0)
+	f.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var firstLeft atomic.Bool
+	var lateReports, secondReports atomic.Int32
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstCtx = WithProgress(firstCtx, func(Progress) {
+		if firstLeft.Load() {
+			lateReports.Add(1)
+		}
+	})
+	secondCtx := WithProgress(context.Background(), func(Progress) { secondReports.Add(1) })
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		f.ABSRestDataset(firstCtx, DataQuery{DataflowID: "CPI"})
+		firstLeft.Store(true)
+	}()
+	<-firstChunk
+	var second *Dataset
+	var secondErr error
+	go func() {
+		defer wg.Done()
+		second, secondErr = f.ABSRestDataset(secondCtx, DataQuery{DataflowID: "CPI"})
+	}()
+	// let the second caller join before the first leaves
+	for deadline := time.Now().Add(time.Second); ; {
+		f.progressMu.Lock()
+		waiting := len(f.progress["CPI/all///"].waiters)
+		f.progressMu.Unlock()
+		if waiting == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancelFirst()
+	for !firstLeft.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	close(finish)
+	wg.Wait()
+
+	if secondErr != nil || len(second.Observations) != 1 {
+		t.Fatalf("second caller got %v, %v", second, secondErr)
+	}
+	if n := lateReports.Load(); n != 0 {
+		t.Errorf("first caller heard %d reports after it stopped waiting", n)
+	}
+	if secondReports.Load() == 0 {
+		t.Errorf("second caller heard no progress")
+	}
+	f.progressMu.Lock()
+	defer f.progressMu.Unlock()
+	if len(f.progress) != 0 {
+		t.Errorf("%d fetches still have waiters", len(f.progress))
+	}
+}
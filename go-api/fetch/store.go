@@ -0,0 +1,70 @@
+package fetch
+
+import (
+	"context"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+)
+
+// StoredObservations converts observations of dataflowID for db.StoreObservations
+func StoredObservations(dataflowID string, observations []Observation) []db.Observation {
+	stored := make([]db.Observation, len(observations))
+	for i, o := range observations {
+		stored[i] = db.Observation{
+			DataflowID: dataflowID,
+			SeriesKey:  o.SeriesKey,
+			Period:     o.Period,
+			Value:      o.Value,
+			Region:     o.Region,
+			Measure:    o.Measure,
+			Unit:       o.Unit,
+			Dimensions: o.Dimensions,
+			Attributes: o.Attributes,
+			Labels:     o.Labels,
+		}
+	}
+	return stored
+}
+
+// StoreDataset saves what the ABS answered to query, observations and all,
+// so the query can later be answered from the database
+func StoreDataset(ctx context.Context, database *db.Database, query DataQuery, ds *Dataset) error {
+	return database.StoreRetrieval(ctx, db.Retrieval{
+		DataflowID:   query.DataflowID,
+		Key:          ds.Key,
+		StartPeriod:  query.StartPeriod,
+		EndPeriod:    query.EndPeriod,
+		Dimensions:   ds.Dimensions,
+		Attributes:   ds.Attributes,
+		Observations: len(ds.Observations),
+		RetrievedAt:  ds.RetrievedAt,
+	}, StoredObservations(query.DataflowID, ds.Observations))
+}
+
+// StoredDataset builds the Dataset a recorded retrieval stands for out of
+// observations read back from the database
+func StoredDataset(r db.Retrieval, stored []db.Observation) *Dataset {
+	ds := &Dataset{
+		DataflowID:   r.DataflowID,
+		Key:          r.Key,
+		Dimensions:   r.Dimensions,
+		Attributes:   r.Attributes,
+		Source:       SourceDatabase,
+		RetrievedAt:  r.RetrievedAt,
+		Observations: make([]Observation, len(stored)),
+	}
+	for i, o := range stored {
+		ds.Observations[i] = Observation{
+			Period:     o.Period,
+			Value:      o.Value,
+			Region:     o.Region,
+			Measure:    o.Measure,
+			Unit:       o.Unit,
+			SeriesKey:  o.SeriesKey,
+			Dimensions: o.Dimensions,
+			Attributes: o.Attributes,
+			Labels:     o.Labels,
+		}
+	}
+	return ds
+}
@@ -0,0 +1,107 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Structure is the shape of a dataflow, its dimensions in key order and the
+// codes each can take, what a client needs to build a key
+type Structure struct {
+	DataflowID string      `json:"dataflowid"`
+	Name       string      `json:"name"`
+	Dimensions []Dimension `json:"dimensions"`
+}
+
+type Dimension struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+	Codelist string `json:"codelist,omitempty"`
+	Codes    []Code `json:"codes,omitempty"`
+}
+
+type Code struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ABSRestDataStructure gets the data structure definition of a dataflow with
+// its codelists. ABS data structures share the id of their dataflow.
+func (f *Fetch) ABSRestDataStructure(ctx context.Context, dataflowID string) (*Structure, error) {
+	path := Path{
+		Endpoint: "/rest/datastructure/ABS/" + dataflowID,
+		Params:   map[string]string{"references": "codelist"},
+	}
+	body, err := f.GetJSONHeader(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching ABS data structure: %w", err)
+	}
+
+	var msg struct {
+		Data struct {
+			DataStructures []struct {
+				ID                      string `json:"id"`
+				Name                    string `json:"name"`
+				DataStructureComponents struct {
+					DimensionList struct {
+						Dimensions []struct {
+							ID                  string `json:"id"`
+							Position            int    `json:"position"`
+							LocalRepresentation struct {
+								Enumeration string `json:"enumeration"`
+							} `json:"localRepresentation"`
+						} `json:"dimensions"`
+					} `json:"dimensionList"`
+				} `json:"dataStructureComponents"`
+			} `json:"dataStructures"`
+			Codelists []struct {
+				ID    string `json:"id"`
+				Codes []Code `json:"codes"`
+			} `json:"codelists"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("parsing ABS data structure: %w", err)
+	}
+	if len(msg.Data.DataStructures) == 0 {
+		return nil, fmt.Errorf("no data structure returned for %s", dataflowID)
+	}
+
+	codelists := make(map[string][]Code, len(msg.Data.Codelists))
+	for _, cl := range msg.Data.Codelists {
+		codelists[cl.ID] = cl.Codes
+	}
+
+	dsd := msg.Data.DataStructures[0]
+	structure := &Structure{DataflowID: dataflowID, Name: dsd.Name}
+	for _, d := range dsd.DataStructureComponents.DimensionList.Dimensions {
+		codelist := codelistID(d.LocalRepresentation.Enumeration)
+		structure.Dimensions = append(structure.Dimensions, Dimension{
+			ID:       d.ID,
+			Position: d.Position,
+			Codelist: codelist,
+			Codes:    codelists[codelist],
+		})
+	}
+	sort.Slice(structure.Dimensions, func(i, j int) bool {
+		return structure.Dimensions[i].Position < structure.Dimensions[j].Position
+	})
+	return structure, nil
+}
+
+// codelistID takes the id out of a urn like
+// urn:sdmx:org.sdmx.infomodel.codelist.Codelist=ABS:CL_STATE(1.0.0)
+func codelistID(urn string) string {
+	_, ref, ok := strings.Cut(urn, "=")
+	if !ok {
+		return ""
+	}
+	if _, id, ok := strings.Cut(ref, ":"); ok {
+		ref = id
+	}
+	id, _, _ := strings.Cut(ref, "(")
+	return id
+}
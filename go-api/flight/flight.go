@@ -0,0 +1,93 @@
+package flight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+)
+
+var coalesced = metrics.NewCounterVec(
+	"upstream_coalesced_requests_total",
+	"Upstream requests answered by joining an identical request already in flight.",
+	"upstream",
+)
+
+type call[T any] struct {
+	done    chan struct{}
+	val     T
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Group makes concurrent calls with the same key share one call of fn. The
+// shared call isn't tied to the first caller's context, it runs until it
+// finishes or every caller waiting on it has given up, so one caller going
+// away doesn't fail the others. The zero Group is ready to use.
+type Group[T any] struct {
+	// Upstream labels the coalesced requests metric
+	Upstream string
+
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+// Do returns the result of fn for key, shared reports whether it came from a
+// call another caller started. The value is shared too, callers that change
+// it must copy it first.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func(context.Context) (T, error)) (val T, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	c, shared := g.calls[key]
+	if shared {
+		coalesced.With(g.Upstream).Inc()
+	} else {
+		// values such as the request id come from whoever asked first, so
+		// anything tied to one caller mustn't be passed this way
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, shared, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			// a caller arriving now starts afresh rather than joining a cancelled call
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		var zero T
+		return zero, shared, ctx.Err()
+	}
+}
+
+func (g *Group[T]) run(ctx context.Context, key string, c *call[T], fn func(context.Context) (T, error)) {
+	defer func() {
+		// no handler is there to recover, the callers get it as an error
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("panic in %s request: %v", g.Upstream, r)
+		}
+		c.cancel()
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn(ctx)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/openapi"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+)
+
+// CatalogueListHandler endpoint GET /api/v1/catalogue?q=price
+// Lists the dataflows synced from the ABS, q matches the id or name.
+func CatalogueListHandler(cfg *config.Config, logger *slog.Logger, database *db.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dataflows, err := database.ListDataflows()
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to list dataflows", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to list dataflows")
+			return
+		}
+
+		q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+		matched := make([]db.ABSDataflow, 0, len(dataflows))
+		for _, d := range dataflows {
+			if q == "" || strings.Contains(strings.ToLower(d.ID), q) || strings.Contains(strings.ToLower(d.Name), q) {
+				matched = append(matched, d)
+			}
+		}
+		sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+		if err := utils.Encode(w, http.StatusOK, matched); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// CatalogueReadHandler endpoint GET /api/v1/catalogue/{dataflow}
+func CatalogueReadHandler(cfg *config.Config, logger *slog.Logger, database *db.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.ToUpper(r.PathValue("dataflow"))
+		dataflows, err := database.ListDataflows()
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to list dataflows", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to list dataflows")
+			return
+		}
+		for _, d := range dataflows {
+			if strings.ToUpper(d.ID) == id {
+				if err := utils.Encode(w, http.StatusOK, d); err != nil {
+					logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+				}
+				return
+			}
+		}
+		apiError(w, r, http.StatusNotFound, "Unknown dataflow: "+id)
+	})
+}
+
+// StructureHandler endpoint GET /api/v1/structure/{dataflow}
+// The dimensions of a dataflow in key order with their codes.
+func StructureHandler(cfg *config.Config, logger *slog.Logger, abs *fetch.Fetch) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.ToUpper(r.PathValue("dataflow"))
+		structure, err := abs.ABSRestDataStructure(r.Context(), id)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch ABS data structure", "dataflow", id, "err", err)
+			upstreamAPIError(w, r, err)
+			return
+		}
+		if err := utils.Encode(w, http.StatusOK, structure); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// OpenAPIHandler endpoint GET /api/v1/openapi.json
+func OpenAPIHandler(cfg *config.Config, logger *slog.Logger, spec *openapi.Spec) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := utils.Encode(w, http.StatusOK, spec.Document()); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
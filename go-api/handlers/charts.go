@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/transform"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+// max number of series drawn on one chart, key=all can return hundreds
+const maxChartSeries = 10
+
+var chartCounter atomic.Int64
+
+// element ids for charts, unique across every fragment htmx swaps into a page
+func nextChartID() string {
+	return fmt.Sprintf("chart-%d", chartCounter.Add(1))
+}
+
+// ChartHandler endpoint GET /chart/{type}?dataflowid=CPI&key=all&transform=seasonal&startPeriod=2015
+// Renders a Plotly chart fragment from the configured data source, one trace per series.
+func ChartHandler(cfg *config.Config, logger *slog.Logger, data *source.Source, registry *charts.Registry, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chart, err := registry.Lookup(r.PathValue("type"), charts.RendererGo)
+		if err != nil {
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := chart.CheckParams(r.URL.Query()); err != nil {
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
+			return
+		}
+		query, err := dataQuery(r)
+		if err != nil {
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ds, err := readDataset(w, r, data, query)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch ABS data", "dataflow", query.DataflowID, "err", err)
+			upstreamFragmentError(w, r, pages, err)
+			return
+		}
+		if status, err := applyTransform(r, ds); err != nil {
+			fragmentError(w, r, pages, status, err.Error())
+			return
+		}
+
+		traces, shown, total := chartTraces(chart, ds.Observations, r.URL.Query())
+		raw, err := json.Marshal(traces)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to encode chart", "err", err)
+			fragmentError(w, r, pages, http.StatusInternalServerError, "Failed to encode chart")
+			return
+		}
+
+		data := map[string]any{
+			"ID":     nextChartID(),
+			"Traces": template.JS(raw),
+			"Empty":  total == 0,
+			"Source": sourceNote(ds),
+		}
+		if shown < total {
+			data["Note"] = fmt.Sprintf("Showing %d of %d series, narrow the key to see the rest.", shown, total)
+		}
+		pages.Render(w, r, "chart.html", data)
+	})
+}
+
+// ChartTypesHandler endpoint GET /api/v1/charts?renderer=go
+// Lists the chart types with their shapes, parameters and which side can draw them.
+func ChartTypesHandler(cfg *config.Config, logger *slog.Logger, registry *charts.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := registry.List()
+		if renderer := charts.Renderer(r.URL.Query().Get("renderer")); renderer != "" {
+			if renderer != charts.RendererGo && renderer != charts.RendererPython {
+				apiError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown renderer %q, expected go or python", renderer))
+				return
+			}
+			list = slices.DeleteFunc(list, func(c charts.Chart) bool { return !c.Supports(renderer) })
+		}
+		if err := utils.Encode(w, http.StatusOK, list); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// chartTraces draws the first maxChartSeries series with the chart's Go
+// renderer and returns how many series were drawn out of how many there are.
+func chartTraces(chart charts.Chart, observations []fetch.Observation, params url.Values) ([]charts.Trace, int, int) {
+	series := transform.GroupSeries(observations)
+	total := len(series)
+	if len(series) > maxChartSeries {
+		series = series[:maxChartSeries]
+	}
+	return chart.Traces(series, seriesNames(series), params), len(series), total
+}
+
+// seriesNames labels each series by the dimensions that differ between them,
+// so ten CPI series read "Food, Sydney" rather than repeating every label.
+func seriesNames(series [][]fetch.Observation) []string {
+	varying := make(map[string]bool)
+	for _, s := range series {
+		for id, code := range s[0].Dimensions {
+			if series[0][0].Dimensions[id] != code {
+				varying[id] = true
+			}
+		}
+	}
+
+	names := make([]string, len(series))
+	for i, s := range series {
+		var parts []string
+		for _, id := range seriesOrder(s[0]) {
+			if !varying[id] {
+				continue
+			}
+			if label := s[0].Labels[id]; label != "" {
+				parts = append(parts, label)
+			} else {
+				parts = append(parts, s[0].Dimensions[id])
+			}
+		}
+		if len(parts) == 0 {
+			names[i] = seriesTitle(s[0])
+			continue
+		}
+		names[i] = strings.Join(parts, ", ")
+	}
+	return names
+}
+
+// seriesOrder lists dimension ids in a fixed order so names are stable
+func seriesOrder(obs fetch.Observation) []string {
+	ids := make([]string, 0, len(obs.Dimensions))
+	for id := range obs.Dimensions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/jobs"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+const (
+	maxDashboardPanels = 24
+	maxDashboardRows   = 24
+	dashboardGridCols  = 12
+	// a panel is drawn 100px tall per row it covers
+	maxPanelHeight = 6
+)
+
+var allowedTransforms = map[string]bool{
+	"seasonal": true,
+}
+
+func validateDashboard(dash *db.Dashboard, registry *charts.Registry) error {
+	dash.Name = strings.TrimSpace(dash.Name)
+	if dash.Name == "" {
+		return fmt.Errorf("dashboard name is required")
+	}
+	if len(dash.Panels) > maxDashboardPanels {
+		return fmt.Errorf("a dashboard can have at most %d panels", maxDashboardPanels)
+	}
+
+	for i := range dash.Panels {
+		p := &dash.Panels[i]
+		p.DataflowID = strings.ToUpper(strings.TrimSpace(p.DataflowID))
+		if p.DataflowID == "" {
+			return fmt.Errorf("panel %d: dataflowid is required", i)
+		}
+		if p.Key == "" {
+			p.Key = "all"
+		}
+		if _, err := registry.Lookup(p.Chart, charts.RendererGo); err != nil {
+			return fmt.Errorf("panel %d: %w", i, err)
+		}
+		for _, t := range p.Transforms {
+			if !allowedTransforms[t] {
+				return fmt.Errorf("panel %d: invalid transform: %s", i, t)
+			}
+		}
+		for _, period := range []string{p.StartPeriod, p.EndPeriod} {
+			if period != "" && !fetch.PeriodPattern.MatchString(period) {
+				return fmt.Errorf("panel %d: invalid period: %s", i, period)
+			}
+		}
+		if p.StartPeriod != "" && p.EndPeriod != "" && p.StartPeriod > p.EndPeriod {
+			return fmt.Errorf("panel %d: startPeriod is after endPeriod", i)
+		}
+		if p.Width == 0 {
+			p.Width = dashboardGridCols / 2
+		}
+		if p.Height == 0 {
+			p.Height = 1
+		}
+		if p.Row < 0 || p.Col < 0 || p.Width < 1 || p.Height < 1 || p.Col+p.Width > dashboardGridCols {
+			return fmt.Errorf("panel %d: position does not fit a %d column grid", i, dashboardGridCols)
+		}
+		if p.Height > maxPanelHeight {
+			return fmt.Errorf("panel %d: height %d is over the limit of %d rows", i, p.Height, maxPanelHeight)
+		}
+		if p.Row >= maxDashboardRows {
+			return fmt.Errorf("panel %d: row %d is past the last row, %d", i, p.Row, maxDashboardRows-1)
+		}
+		if p.Row+p.Height > maxDashboardRows {
+			return fmt.Errorf("panel %d: height %d runs past the last row, %d", i, p.Height, maxDashboardRows-1)
+		}
+		// panels cover rows Row to Row+Height-1
+		for j, other := range dash.Panels[:i] {
+			if other.Row < p.Row+p.Height && p.Row < other.Row+other.Height &&
+				other.Col < p.Col+p.Width && p.Col < other.Col+other.Width {
+				return fmt.Errorf("panel %d: overlaps panel %d in row %d", i, j, max(p.Row, other.Row))
+			}
+		}
+	}
+	return nil
+}
+
+// DashboardListHandler endpoint GET /api/dashboards
+func DashboardListHandler(cfg *config.Config, logger *slog.Logger, database *db.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dashboards, err := database.ListDashboards()
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to list dashboards", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to list dashboards")
+			return
+		}
+		if err := utils.Encode(w, http.StatusOK, dashboards); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// DashboardCreateHandler endpoint POST /api/dashboards
+func DashboardCreateHandler(cfg *config.Config, logger *slog.Logger, database *db.Database, registry *charts.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dash, err := utils.Decode[db.Dashboard](r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := validateDashboard(&dash, registry); err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := database.CreateDashboard(&dash); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to create dashboard", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to create dashboard")
+			return
+		}
+
+		w.Header().Set("Location", "/dashboards/"+dash.ID)
+		if err := utils.Encode(w, http.StatusCreated, dash); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+		logger.InfoContext(r.Context(), "Created dashboard", "id", dash.ID, "name", dash.Name)
+	})
+}
+
+// DashboardReadHandler endpoint GET /api/dashboards/{id}
+func DashboardReadHandler(cfg *config.Config, logger *slog.Logger, database *db.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dash, err := database.GetDashboard(r.PathValue("id"))
+		if errors.Is(err, db.ErrNotFound) {
+			apiError(w, r, http.StatusNotFound, "Dashboard not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to get dashboard", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to get dashboard")
+			return
+		}
+		if err := utils.Encode(w, http.StatusOK, dash); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// refreshDashboard queues a fetch job for each distinct query on the
+// dashboard's panels, a query already being fetched joins that job
+func refreshDashboard(ctx context.Context, manager *jobs.Manager, dash *db.Dashboard) ([]jobs.Job, error) {
+	seen := make(map[fetch.DataQuery]bool)
+	queued := []jobs.Job{}
+	for _, p := range dash.Panels {
+		query := fetch.DataQuery{
+			DataflowID:  strings.ToUpper(p.DataflowID),
+			Key:         p.Key,
+			StartPeriod: p.StartPeriod,
+			EndPeriod:   p.EndPeriod,
+		}
+		if seen[query] {
+			continue
+		}
+		seen[query] = true
+		job, err := manager.Submit(ctx, jobs.KindFetch, jobs.FetchTitle(query), query)
+		if err != nil {
+			return queued, err
+		}
+		queued = append(queued, job)
+	}
+	return queued, nil
+}
+
+// DashboardRefreshHandler endpoint POST /dashboards/{id}/refresh
+// Queues downloading every panel's data into the database and returns a job
+// fragment for each download.
+func DashboardRefreshHandler(cfg *config.Config, logger *slog.Logger, database *db.Database, manager *jobs.Manager, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dash, err := database.GetDashboard(r.PathValue("id"))
+		if errors.Is(err, db.ErrNotFound) {
+			fragmentError(w, r, pages, http.StatusNotFound, "Dashboard not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to get dashboard", "err", err)
+			fragmentError(w, r, pages, http.StatusInternalServerError, "Failed to get dashboard")
+			return
+		}
+		queued, err := refreshDashboard(r.Context(), manager, dash)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to queue dashboard refresh", "dashboard", dash.ID, "err", err)
+			fragmentError(w, r, pages, http.StatusInternalServerError, "Failed to queue the refresh")
+			return
+		}
+		pages.RenderStatus(w, r, http.StatusAccepted, "jobs.html", queued)
+	})
+}
+
+// DashboardRefreshJobsHandler endpoint POST /api/v1/dashboards/{id}/refresh
+func DashboardRefreshJobsHandler(cfg *config.Config, logger *slog.Logger, database *db.Database, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dash, err := database.GetDashboard(r.PathValue("id"))
+		if errors.Is(err, db.ErrNotFound) {
+			apiError(w, r, http.StatusNotFound, "Dashboard not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to get dashboard", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to get dashboard")
+			return
+		}
+		queued, err := refreshDashboard(r.Context(), manager, dash)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to queue dashboard refresh", "dashboard", dash.ID, "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to queue the refresh")
+			return
+		}
+		if err := utils.Encode(w, http.StatusAccepted, queued); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// DashboardUpdateHandler endpoint PUT /api/dashboards/{id}
+func DashboardUpdateHandler(cfg *config.Config, logger *slog.Logger, database *db.Database, registry *charts.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dash, err := utils.Decode[db.Dashboard](r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := validateDashboard(&dash, registry); err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		dash.ID = r.PathValue("id")
+		err = database.UpdateDashboard(&dash)
+		if errors.Is(err, db.ErrNotFound) {
+			apiError(w, r, http.StatusNotFound, "Dashboard not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to update dashboard", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to update dashboard")
+			return
+		}
+		if err := utils.Encode(w, http.StatusOK, dash); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// DashboardDeleteHandler endpoint DELETE /api/dashboards/{id}
+func DashboardDeleteHandler(cfg *config.Config, logger *slog.Logger, database *db.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := database.DeleteDashboard(r.PathValue("id"))
+		if errors.Is(err, db.ErrNotFound) {
+			apiError(w, r, http.StatusNotFound, "Dashboard not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to delete dashboard", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to delete dashboard")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// SavedDashboardPageHandler endpoint GET /dashboards/{id}
+// The shareable link: the full page shell with the dashboard as its content.
+func SavedDashboardPageHandler(cfg *config.Config, logger *slog.Logger, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]string{"Content": "/dashboards/" + r.PathValue("id") + "/panels"}
+		pages.Render(w, r, "index.html", data)
+	})
+}
+
+// SavedDashboardPanelsHandler endpoint GET /dashboards/{id}/panels
+// Renders the panel grid fragment for a saved dashboard.
+func SavedDashboardPanelsHandler(cfg *config.Config, logger *slog.Logger, database *db.Database, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dash, err := database.GetDashboard(r.PathValue("id"))
+		if errors.Is(err, db.ErrNotFound) {
+			fragmentError(w, r, pages, http.StatusNotFound, "Dashboard not found")
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to get dashboard", "err", err)
+			fragmentError(w, r, pages, http.StatusInternalServerError, "Failed to get dashboard")
+			return
+		}
+
+		pages.Render(w, r, "saved_dashboard.html", dashboardGrid(dash))
+	})
+}
+
+type panelView struct {
+	db.Panel
+	ChartURL  string
+	ExportURL string
+	// bootstrap offsets are relative to the previous panel in the row
+	Offset int
+}
+
+type gridRow struct {
+	Panels []panelView
+}
+
+// panelQuery is the data API query string shared by a panel's chart and export links
+func panelQuery(p db.Panel) url.Values {
+	q := url.Values{}
+	q.Set("dataflowid", p.DataflowID)
+	q.Set("key", p.Key)
+	if p.StartPeriod != "" {
+		q.Set("startPeriod", p.StartPeriod)
+	}
+	if p.EndPeriod != "" {
+		q.Set("endPeriod", p.EndPeriod)
+	}
+	for _, t := range p.Transforms {
+		q.Add("transform", t)
+	}
+	return q
+}
+
+func newPanelView(p db.Panel) panelView {
+	q := panelQuery(p).Encode()
+	return panelView{
+		Panel:     p,
+		ChartURL:  "/chart/" + p.Chart + "?" + q,
+		ExportURL: "/api/export?" + q,
+	}
+}
+
+// dashboardRows groups panels into bootstrap rows ordered by row then column
+func dashboardRows(panels []db.Panel) []gridRow {
+	byRow := make(map[int][]panelView)
+	var rowNumbers []int
+	for _, p := range panels {
+		if _, ok := byRow[p.Row]; !ok {
+			rowNumbers = append(rowNumbers, p.Row)
+		}
+		byRow[p.Row] = append(byRow[p.Row], newPanelView(p))
+	}
+	sort.Ints(rowNumbers)
+
+	var rows []gridRow
+	for _, i := range rowNumbers {
+		row := byRow[i]
+		sort.Slice(row, func(a, b int) bool { return row[a].Col < row[b].Col })
+		end := 0
+		for j := range row {
+			row[j].Offset = max(row[j].Col-end, 0)
+			end = row[j].Col + row[j].Width
+		}
+		rows = append(rows, gridRow{Panels: row})
+	}
+	return rows
+}
+
+func dashboardGrid(dash *db.Dashboard) map[string]any {
+	return map[string]any{
+		"Dashboard": dash,
+		"Rows":      dashboardRows(dash.Panels),
+	}
+}
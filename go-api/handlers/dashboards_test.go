@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+)
+
+func TestValidateDashboardLayout(t *testing.T) {
+	type cell struct{ row, col, width, height int }
+	panels := func(cells ...cell) []db.Panel {
+		var panels []db.Panel
+		for _, c := range cells {
+			panels = append(panels, db.Panel{DataflowID: "cpi", Chart: "line", Row: c.row, Col: c.col, Width: c.width, Height: c.height})
+		}
+		return panels
+	}
+	tests := []struct {
+		name   string
+		panels []db.Panel
+		// want is the layout after defaults are filled in
+		want []cell
+	}{
+		{name: "side by side", panels: panels(cell{0, 0, 6, 1}, cell{0, 6, 6, 1}), want: []cell{{0, 0, 6, 1}, {0, 6, 6, 1}}},
+		{name: "stacked", panels: panels(cell{0, 0, 12, 1}, cell{1, 0, 12, 1}), want: []cell{{0, 0, 12, 1}, {1, 0, 12, 1}}},
+		{name: "gaps between rows", panels: panels(cell{0, 0, 6, 1}, cell{5, 3, 6, 1}), want: []cell{{0, 0, 6, 1}, {5, 3, 6, 1}}},
+		{name: "width and height default", panels: panels(cell{0, 0, 0, 0}, cell{0, 6, 0, 0}), want: []cell{{0, 0, 6, 1}, {0, 6, 6, 1}}},
+		{name: "below a tall panel", panels: panels(cell{0, 0, 6, 3}, cell{3, 0, 6, 1}), want: []cell{{0, 0, 6, 3}, {3, 0, 6, 1}}},
+		{name: "beside a tall panel", panels: panels(cell{0, 0, 6, 3}, cell{1, 6, 6, 2}), want: []cell{{0, 0, 6, 3}, {1, 6, 6, 2}}},
+		{name: "tallest in the last rows", panels: panels(cell{maxDashboardRows - maxPanelHeight, 0, 12, maxPanelHeight}),
+			want: []cell{{maxDashboardRows - maxPanelHeight, 0, 12, maxPanelHeight}}},
+	}
+	registry := charts.Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dash := db.Dashboard{Name: " test ", Panels: tt.panels}
+			if err := validateDashboard(&dash, registry); err != nil {
+				t.Fatal(err)
+			}
+			if dash.Name != "test" {
+				t.Errorf("name %q, want it trimmed", dash.Name)
+			}
+			for i, p := range dash.Panels {
+				if got := (cell{p.Row, p.Col, p.Width, p.Height}); got != tt.want[i] {
+					t.Errorf("panel %d at %+v, want %+v", i, got, tt.want[i])
+				}
+				if p.DataflowID != "CPI" || p.Key != "all" {
+					t.Errorf("panel %d dataflow %q key %q, want CPI and all", i, p.DataflowID, p.Key)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDashboardLayoutErrors(t *testing.T) {
+	panel := func(row, col, width, height int) db.Panel {
+		return db.Panel{DataflowID: "CPI", Chart: "line", Row: row, Col: col, Width: width, Height: height}
+	}
+	tests := []struct {
+		name   string
+		panels []db.Panel
+		want   string
+	}{
+		{name: "past the last row", panels: []db.Panel{panel(maxDashboardRows, 0, 6, 1)}, want: "panel 0: row 24 is past the last row, 23"},
+		{name: "huge row", panels: []db.Panel{panel(1<<30, 0, 6, 1)}, want: "panel 0: row 1073741824 is past the last row, 23"},
+		{name: "negative row", panels: []db.Panel{panel(-1, 0, 6, 1)}, want: "panel 0: position does not fit a 12 column grid"},
+		{name: "past the last column", panels: []db.Panel{panel(0, 8, 6, 1)}, want: "panel 0: position does not fit a 12 column grid"},
+		{name: "negative height", panels: []db.Panel{panel(0, 0, 6, -2)}, want: "panel 0: position does not fit a 12 column grid"},
+		{name: "too tall", panels: []db.Panel{panel(0, 0, 6, maxPanelHeight+1)}, want: "panel 0: height 7 is over the limit of 6 rows"},
+		{name: "huge height", panels: []db.Panel{panel(0, 0, 6, 1<<30)}, want: "panel 0: height 1073741824 is over the limit of 6 rows"},
+		{name: "tall in the last row", panels: []db.Panel{panel(maxDashboardRows-1, 0, 6, 2)}, want: "panel 0: height 2 runs past the last row, 23"},
+		{name: "overlapping", panels: []db.Panel{panel(0, 0, 6, 1), panel(0, 4, 6, 1)}, want: "panel 1: overlaps panel 0 in row 0"},
+		{name: "same position", panels: []db.Panel{panel(2, 3, 3, 1), panel(2, 3, 3, 1)}, want: "panel 1: overlaps panel 0 in row 2"},
+		{name: "inside another", panels: []db.Panel{panel(0, 0, 12, 1), panel(1, 0, 6, 1), panel(0, 4, 2, 1)}, want: "panel 2: overlaps panel 0 in row 0"},
+		{name: "under a tall panel", panels: []db.Panel{panel(0, 0, 6, 3), panel(2, 3, 6, 1)}, want: "panel 1: overlaps panel 0 in row 2"},
+		{name: "reaching down into another", panels: []db.Panel{panel(4, 6, 6, 1), panel(1, 0, 12, 4)}, want: "panel 1: overlaps panel 0 in row 4"},
+		// width defaults to half the grid
+		{name: "overlapping default width", panels: []db.Panel{panel(0, 0, 0, 0), panel(0, 5, 0, 0)}, want: "panel 1: overlaps panel 0 in row 0"},
+	}
+	registry := charts.Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dash := db.Dashboard{Name: "test", Panels: tt.panels}
+			err := validateDashboard(&dash, registry)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboardRows(t *testing.T) {
+	rows := dashboardRows([]db.Panel{
+		{Chart: "line", Row: 7, Col: 6, Width: 6},
+		{Chart: "line", Row: 2, Col: 3, Width: 3},
+		{Chart: "line", Row: 7, Col: 0, Width: 4},
+	})
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, want 2", len(rows))
+	}
+	if got := rows[0].Panels; len(got) != 1 || got[0].Col != 3 || got[0].Offset != 3 {
+		t.Errorf("first row %+v, want the row 2 panel offset by 3", got)
+	}
+	got := rows[1].Panels
+	if len(got) != 2 || got[0].Col != 0 || got[1].Col != 6 || got[1].Offset != 2 {
+		t.Errorf("second row %+v, want the row 7 panels by column with the second offset by 2", got)
+	}
+}
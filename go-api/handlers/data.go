@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/transform"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+// max number of series drawn by the decomposition overlay, key=all can return hundreds
+const maxOverlaySeries = 6
+
+// ABSDataHandler endpoint /data/ABS/?dataflowid=CPI&key=all&transform=seasonal&source=live
+// Gets observations from the database or the ABS API and optionally applies a transform.
+func ABSDataHandler(cfg *config.Config, logger *slog.Logger, data *source.Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := dataQuery(r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ds, err := readDataset(w, r, data, query)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch ABS data", "dataflow", query.DataflowID, "err", err)
+			upstreamAPIError(w, r, err)
+			return
+		}
+
+		if status, err := applyTransform(r, ds); err != nil {
+			apiError(w, r, status, err.Error())
+			logger.WarnContext(r.Context(), "Transform failed", "dataflow", query.DataflowID, "err", err)
+			return
+		}
+
+		if err := utils.Encode(w, http.StatusOK, ds.Observations); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// dataQuery reads the dataflowid, key, startPeriod and endPeriod query or form
+// parameters, the /api/v1 routes have the dataflow and key in the path instead.
+// The source parameter is checked here and read by readDataset.
+func dataQuery(r *http.Request) (fetch.DataQuery, error) {
+	query := fetch.DataQuery{
+		DataflowID:  strings.ToUpper(cmp.Or(r.PathValue("dataflow"), r.FormValue("dataflowid"))),
+		Key:         cmp.Or(r.PathValue("key"), r.FormValue("key")),
+		StartPeriod: r.FormValue("startPeriod"),
+		EndPeriod:   r.FormValue("endPeriod"),
+	}
+	if _, err := dataMode(r); err != nil {
+		return query, err
+	}
+	return query, validateDataQuery(query)
+}
+
+// dataMode is the source parameter, database, live or cache, "" leaves it to
+// the configured data source
+func dataMode(r *http.Request) (source.Mode, error) {
+	mode := r.FormValue("source")
+	if mode == "" {
+		return "", nil
+	}
+	return source.ParseMode(mode)
+}
+
+// readDataset answers query the way the source parameter asks and reports
+// where the observations came from in the response headers
+func readDataset(w http.ResponseWriter, r *http.Request, data *source.Source, query fetch.DataQuery) (*fetch.Dataset, error) {
+	mode, _ := dataMode(r)
+	ds, err := data.Dataset(r.Context(), query, mode)
+	if err != nil {
+		return nil, err
+	}
+	setDataSource(w, ds)
+	return ds, nil
+}
+
+// setDataSource sets X-Data-Source to where the observations were read from,
+// abs, database or database-stale, and X-Data-Retrieved-At to when the ABS sent them. For
+// several datasets the sources are listed and the oldest time is given.
+func setDataSource(w http.ResponseWriter, datasets ...*fetch.Dataset) {
+	var sources []string
+	var oldest time.Time
+	for _, ds := range datasets {
+		if !slices.Contains(sources, ds.Source) {
+			sources = append(sources, ds.Source)
+		}
+		if oldest.IsZero() || ds.RetrievedAt.Before(oldest) {
+			oldest = ds.RetrievedAt
+		}
+	}
+	w.Header().Set("X-Data-Source", strings.Join(sources, ", "))
+	w.Header().Set("X-Data-Retrieved-At", oldest.UTC().Format(time.RFC3339))
+}
+
+// sourceNote says where a fragment's data came from, under the chart
+func sourceNote(ds *fetch.Dataset) string {
+	from := "the ABS"
+	switch ds.Source {
+	case fetch.SourceDatabase:
+		from = "the database"
+	case fetch.SourceStale:
+		from = "the database as the ABS couldn't be reached"
+	}
+	return fmt.Sprintf("From %s, retrieved %s.", from, ds.RetrievedAt.UTC().Format("2 Jan 2006 15:04 MST"))
+}
+
+func validateDataQuery(query fetch.DataQuery) error {
+	if query.DataflowID == "" {
+		return fmt.Errorf("missing dataflowid parameter")
+	}
+	for _, period := range []string{query.StartPeriod, query.EndPeriod} {
+		if period != "" && !fetch.PeriodPattern.MatchString(period) {
+			return fmt.Errorf("invalid period: %s", period)
+		}
+	}
+	return nil
+}
+
+// applyTransform runs the transforms named by the transform query parameters,
+// in order, over the dataset. On error it also returns the HTTP status to reply with.
+func applyTransform(r *http.Request, ds *fetch.Dataset) (int, error) {
+	names := r.URL.Query()["transform"]
+	if err := transform.Check(names); err != nil {
+		return http.StatusBadRequest, err
+	}
+	var opts transform.SeasonalOptions
+	if slices.Contains(names, "seasonal") {
+		var err error
+		if opts, err = seasonalOptions(r); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	if err := transform.Apply(ds, names, opts); err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	return http.StatusOK, nil
+}
+
+func seasonalOptions(r *http.Request) (transform.SeasonalOptions, error) {
+	q := r.URL.Query()
+	model, err := transform.ParseModel(q.Get("model"))
+	if err != nil {
+		return transform.SeasonalOptions{}, err
+	}
+	period, err := transform.ParsePeriod(q.Get("period"))
+	if err != nil {
+		return transform.SeasonalOptions{}, err
+	}
+	return transform.SeasonalOptions{Model: model, Period: period}, nil
+}
+
+type overlayChart struct {
+	ID     string
+	Title  string
+	Traces template.JS
+}
+
+// PlotDecomposeHandler endpoint /plot/decompose/?dataflowid=CPI&key=...
+// Draws the original series with the Go trend and seasonally adjusted estimates
+// over the top, plus the ABS seasonally adjusted series where it was returned.
+func PlotDecomposeHandler(cfg *config.Config, logger *slog.Logger, data *source.Source, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := dataQuery(r)
+		if err != nil {
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
+			return
+		}
+		dataflowid := query.DataflowID
+		opts, err := seasonalOptions(r)
+		if err != nil {
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ds, err := readDataset(w, r, data, query)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch ABS data", "dataflow", dataflowid, "err", err)
+			upstreamFragmentError(w, r, pages, err)
+			return
+		}
+
+		overlays, err := decompositionCharts(ds.Observations, opts)
+		if err != nil {
+			fragmentError(w, r, pages, http.StatusUnprocessableEntity, err.Error())
+			logger.WarnContext(r.Context(), "Seasonal decomposition failed", "dataflow", dataflowid, "err", err)
+			return
+		}
+
+		data := map[string]any{
+			"DataflowID": dataflowid,
+			"Charts":     overlays,
+			"Source":     sourceNote(ds),
+			"ExportURL":  "/api/export?" + decomposeExportQuery(r, query, opts).Encode(),
+		}
+		pages.Render(w, r, "decompose.html", data)
+	})
+}
+
+// decomposeExportQuery asks the export for the decomposition on screen, the
+// format is added by the template
+func decomposeExportQuery(r *http.Request, query fetch.DataQuery, opts transform.SeasonalOptions) url.Values {
+	q := url.Values{}
+	q.Set("dataflowid", query.DataflowID)
+	q.Set("key", cmp.Or(query.Key, "all"))
+	if query.StartPeriod != "" {
+		q.Set("startPeriod", query.StartPeriod)
+	}
+	if query.EndPeriod != "" {
+		q.Set("endPeriod", query.EndPeriod)
+	}
+	q.Set("transform", "seasonal")
+	q.Set("model", string(opts.Model))
+	if opts.Period > 0 {
+		q.Set("period", strconv.Itoa(opts.Period))
+	}
+	if mode := r.FormValue("source"); mode != "" {
+		q.Set("source", mode)
+	}
+	return q
+}
+
+func decompositionCharts(observations []fetch.Observation, opts transform.SeasonalOptions) ([]overlayChart, error) {
+	series := transform.GroupSeries(observations)
+
+	// ABS seasonally adjusted series keyed by their dimensions minus TSEST
+	absAdjusted := make(map[string][]fetch.Observation)
+	for _, s := range series {
+		if s[0].Dimensions["TSEST"] == transform.TSESTSeasonallyAdjusted {
+			absAdjusted[keyWithout(s[0], "TSEST")] = s
+		}
+	}
+
+	var overlays []overlayChart
+	for _, s := range series {
+		if len(overlays) == maxOverlaySeries {
+			break
+		}
+		first := s[0]
+		if tsest, ok := first.Dimensions["TSEST"]; ok && tsest != transform.TSESTOriginal {
+			continue
+		}
+
+		decomposed, err := transform.DecomposeObservations(s, opts)
+		if err != nil {
+			return nil, err
+		}
+		traces := []charts.Trace{
+			charts.NewTrace("Original", s, ""),
+			charts.NewTrace("Trend", componentSeries(decomposed, transform.ComponentTrend), ""),
+			charts.NewTrace("Seasonally adjusted", componentSeries(decomposed, transform.ComponentAdjusted), "dot"),
+		}
+		if sa, ok := absAdjusted[keyWithout(first, "TSEST")]; ok {
+			traces = append(traces, charts.NewTrace("Seasonally adjusted (ABS)", sa, "dash"))
+		}
+
+		raw, err := json.Marshal(traces)
+		if err != nil {
+			return nil, fmt.Errorf("encoding traces: %w", err)
+		}
+		overlays = append(overlays, overlayChart{
+			ID:     nextChartID(),
+			Title:  seriesTitle(first),
+			Traces: template.JS(raw),
+		})
+	}
+	return overlays, nil
+}
+
+func componentSeries(observations []fetch.Observation, component string) []fetch.Observation {
+	var out []fetch.Observation
+	for _, obs := range observations {
+		if obs.Dimensions[transform.ComponentDimension] == component {
+			out = append(out, obs)
+		}
+	}
+	return out
+}
+
+func keyWithout(obs fetch.Observation, dimension string) string {
+	ids := make([]string, 0, len(obs.Dimensions))
+	for id := range obs.Dimensions {
+		if id != dimension {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%s=%s;", id, obs.Dimensions[id])
+	}
+	return b.String()
+}
+
+func seriesTitle(obs fetch.Observation) string {
+	parts := make([]string, 0, 3)
+	for _, id := range []string{"MEASURE", "INDEX", "REGION"} {
+		if label := obs.Labels[id]; label != "" {
+			parts = append(parts, label)
+		}
+	}
+	if len(parts) == 0 {
+		return obs.SeriesKey
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SeriesRef points at one series (or a set of series) in an ABS dataflow
+type SeriesRef struct {
+	DataflowID string `json:"dataflowid"`
+	Key        string `json:"key"`
+}
+
+type DeriveRequest struct {
+	Series     map[string]SeriesRef `json:"series"`
+	Expression string               `json:"expression"`
+	Join       string               `json:"join"`
+	On         []string             `json:"on"`
+	Fill       *float64             `json:"fill"`
+	Name       string               `json:"name"`
+}
+
+// max number of series a single derive request can pull from the ABS
+const maxDeriveSeries = 8
+
+// maxDeriveBody is the largest derive request body read, eight series and the
+// longest expression fit in a fraction of it
+const maxDeriveBody = 64 << 10
+
+// DeriveHandler endpoint POST /data/derive/?source=live
+// Combines series from one or more dataflows into a derived series, eg. wages
+// deflated by CPI with {"series": {"a": {...}, "b": {...}}, "expression": "a / b * 100"}
+func DeriveHandler(cfg *config.Config, logger *slog.Logger, data *source.Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apiError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		mode, err := dataMode(r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxDeriveBody)
+		req, err := utils.Decode[DeriveRequest](r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apiError(w, r, http.StatusBadRequest, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			apiError(w, r, http.StatusBadRequest, "Invalid request body")
+			logger.WarnContext(r.Context(), "Failed to decode derive request", "err", err)
+			return
+		}
+		if len(req.Series) == 0 || len(req.Series) > maxDeriveSeries {
+			apiError(w, r, http.StatusBadRequest, fmt.Sprintf("Between 1 and %d series are required", maxDeriveSeries))
+			return
+		}
+		if req.Expression == "" {
+			apiError(w, r, http.StatusBadRequest, "Missing expression")
+			return
+		}
+		// before asking the ABS for anything
+		if _, err := transform.ParseExpr(req.Expression); err != nil {
+			apiError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid expression: %v", err))
+			return
+		}
+		join, err := transform.ParseJoinType(req.Join)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if join == transform.OuterJoin && req.Fill == nil {
+			apiError(w, r, http.StatusBadRequest, transform.ErrOuterJoinFill.Error())
+			return
+		}
+		for name, ref := range req.Series {
+			if ref.DataflowID == "" {
+				apiError(w, r, http.StatusBadRequest, fmt.Sprintf("Missing dataflowid for series %s", name))
+				return
+			}
+		}
+
+		datasets, err := fetchSeriesRefs(r.Context(), data, mode, req.Series)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch series for derive", "err", err)
+			upstreamAPIError(w, r, err)
+			return
+		}
+		series := make(map[string][]fetch.Observation, len(datasets))
+		for name, ds := range datasets {
+			series[name] = ds.Observations
+		}
+		setDataSource(w, slices.Collect(maps.Values(datasets))...)
+
+		derived, err := transform.Derive(series, transform.DeriveOptions{
+			Expression: req.Expression,
+			Join:       join,
+			On:         req.On,
+			Fill:       req.Fill,
+			Name:       req.Name,
+		})
+		if err != nil {
+			apiError(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if err := utils.Encode(w, http.StatusOK, derived); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// fetchSeriesRefs gets every referenced series at the same time
+func fetchSeriesRefs(ctx context.Context, data *source.Source, mode source.Mode, refs map[string]SeriesRef) (map[string]*fetch.Dataset, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	series := make(map[string]*fetch.Dataset, len(refs))
+	for name, ref := range refs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ds, err := data.Dataset(ctx, fetch.DataQuery{DataflowID: strings.ToUpper(ref.DataflowID), Key: ref.Key}, mode)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("series %s (%s/%s): %w", name, ref.DataflowID, ref.Key, err)
+				}
+				return
+			}
+			series[name] = ds
+		}()
+	}
+	wg.Wait()
+	return series, firstErr
+}
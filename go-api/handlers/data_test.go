@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+)
+
+func TestDecomposeExportQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "defaults",
+			query: "dataflowid=cpi",
+			want:  "dataflowid=CPI&key=all&model=additive&transform=seasonal",
+		},
+		{
+			name:  "model and period",
+			query: "dataflowid=CPI&key=1.10001.10.50.Q&model=multiplicative&period=4",
+			want:  "dataflowid=CPI&key=1.10001.10.50.Q&model=multiplicative&period=4&transform=seasonal",
+		},
+		{
+			name:  "periods and source",
+			query: "dataflowid=CPI&startPeriod=2015-Q1&endPeriod=2020-Q4&source=live",
+			want:  "dataflowid=CPI&endPeriod=2020-Q4&key=all&model=additive&source=live&startPeriod=2015-Q1&transform=seasonal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/plot/decompose/?"+tt.query, nil)
+			query, err := dataQuery(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts, err := seasonalOptions(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decomposeExportQuery(r, query, opts).Encode(); got != tt.want {
+				t.Errorf("export query %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDeriveHandlerRejects covers requests turned away before any series is
+// fetched, the handler has no data source to fetch with
+func TestDeriveHandlerRejects(t *testing.T) {
+	series := `"series": {"a": {"dataflowid": "CPI"}, "b": {"dataflowid": "WPI"}}`
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "not json", body: "a / b", want: "Invalid request body"},
+		{name: "too large", body: `{"expression": "` + strings.Repeat(" ", maxDeriveBody) + `"}`, want: "Request body is larger than 65536 bytes"},
+		{name: "no expression", body: `{` + series + `}`, want: "Missing expression"},
+		{name: "bad expression", body: `{` + series + `, "expression": "a / (b"}`, want: "Invalid expression: missing ) at position 6"},
+		{
+			name: "expression too long", body: `{` + series + `, "expression": "` + strings.Repeat("a+", 600) + `b"}`,
+			want: "Invalid expression: expression is longer than 1024 bytes",
+		},
+	}
+	h := DeriveHandler(&config.Config{}, slog.New(slog.DiscardHandler), nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/data/derive/", strings.NewReader(tt.body)))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", w.Code)
+			}
+			var got utils.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body %q isn't an error envelope: %v", w.Body, err)
+			}
+			if got.Error.Message != tt.want {
+				t.Errorf("message %q, want %q", got.Error.Message, tt.want)
+			}
+		})
+	}
+}
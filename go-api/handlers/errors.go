@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+// JSON routes answer errors with utils.ErrorResponse, fragment routes with
+// error.html so htmx can swap the message into the page
+
+func apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	utils.EncodeError(w, r, status, utils.CodeForStatus(status), message, nil)
+}
+
+func fragmentError(w http.ResponseWriter, r *http.Request, pages *views.Registry, status int, message string) {
+	pages.RenderStatus(w, r, status, "error.html", map[string]any{
+		"Status":    status,
+		"Code":      utils.CodeForStatus(status),
+		"Message":   message,
+		"RequestID": middleware.RequestIDFromContext(r.Context()),
+	})
+}
+
+// TimeoutError answers requests that ran past their middleware.Timeout with
+// a 504, error.html for htmx and the browser, the JSON envelope for the rest
+func TimeoutError(pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const message = "The request took too long, try again or ask for less data"
+		if wantsFragment(r) {
+			fragmentError(w, r, pages, http.StatusGatewayTimeout, message)
+			return
+		}
+		apiError(w, r, http.StatusGatewayTimeout, message)
+	})
+}
+
+// wantsFragment is whether an error should be rendered as HTML, the plot
+// route answers with JSON when asked for format=json
+func wantsFragment(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return false
+	}
+	return r.Header.Get("HX-Request") == "true" || strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// upstreamFailure decides what the client sees when the ABS API, the plot
+// service or the database fails. 4xx errors from the first two are about what
+// we asked for so the client gets the same, anything else is our upstream's
+// problem, a 502, 503 or 504.
+type upstreamFailure struct {
+	Status   int    `json:"-"`
+	Message  string `json:"-"`
+	Upstream string `json:"upstream"`
+	// the status the upstream answered with, 0 when it didn't answer
+	UpstreamStatus int `json:"upstreamStatus,omitempty"`
+}
+
+func classifyUpstream(err error) upstreamFailure {
+	var absErr *fetch.StatusError
+	var plotErr *plotservice.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &absErr):
+		f := upstreamFailure{Upstream: "abs", UpstreamStatus: absErr.Code, Status: http.StatusBadGateway, Message: "ABS API error"}
+		switch {
+		case absErr.Code == http.StatusNotFound:
+			// the ABS answers 404 when a query matches no observations
+			f.Status, f.Message = http.StatusNotFound, "No ABS data matches the query"
+		case absErr.Code == http.StatusBadRequest:
+			f.Status, f.Message = http.StatusBadRequest, "The ABS API rejected the query, check the dataflow, key and periods"
+		}
+		return f
+	case errors.Is(err, source.ErrNotStored):
+		return upstreamFailure{Upstream: "database", Status: http.StatusNotFound,
+			Message: "No stored observations cover the query, download them or ask with source=live"}
+	case errors.As(err, &plotErr):
+		f := upstreamFailure{Upstream: "plotservice", UpstreamStatus: plotErr.Status, Status: http.StatusBadGateway, Message: "Python service error"}
+		if plotErr.Status >= 400 && plotErr.Status < 500 {
+			f.Status, f.Message = plotErr.Status, plotErr.Message
+		}
+		return f
+	}
+
+	upstream, name := "abs", "ABS API"
+	switch {
+	case errors.Is(err, plotservice.ErrUnavailable):
+		upstream, name = "plotservice", "Python service"
+	case errors.Is(err, source.ErrDatabase):
+		upstream, name = "database", "Database"
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return upstreamFailure{Status: http.StatusGatewayTimeout, Message: name + " timed out", Upstream: upstream}
+	}
+	return upstreamFailure{Status: http.StatusServiceUnavailable, Message: name + " unavailable", Upstream: upstream}
+}
+
+func upstreamAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	f := classifyUpstream(err)
+	utils.EncodeError(w, r, f.Status, utils.CodeForStatus(f.Status), f.Message, f)
+}
+
+func upstreamFragmentError(w http.ResponseWriter, r *http.Request, pages *views.Registry, err error) {
+	f := classifyUpstream(err)
+	fragmentError(w, r, pages, f.Status, f.Message)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+)
+
+func TestTimeoutError(t *testing.T) {
+	const message = "The request took too long, try again or ask for less data"
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "htmx", path: "/plot/line/CPI", headers: map[string]string{"HX-Request": "true"},
+			want: errorFragment(http.StatusGatewayTimeout, message)},
+		{name: "browser", path: "/dashboard", headers: map[string]string{"Accept": "text/html,application/xhtml+xml"},
+			want: errorFragment(http.StatusGatewayTimeout, message)},
+		{name: "api", path: "/api/v1/catalogue",
+			want: `{"error":{"code":"upstream_timeout","message":"` + message + `"}}`},
+		{name: "plot as json", path: "/plot/line/CPI?format=json", headers: map[string]string{"HX-Request": "true"},
+			want: `{"error":{"code":"upstream_timeout","message":"` + message + `"}}`},
+	}
+	h := TimeoutError(testFragments(t))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusGatewayTimeout {
+				t.Errorf("status %d, want 504", w.Code)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("body %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyUpstream(t *testing.T) {
+	database := func(err error) error { return fmt.Errorf("%w: %w", source.ErrDatabase, err) }
+	tests := []struct {
+		name string
+		err  error
+		want upstreamFailure
+	}{
+		{name: "ABS unreachable", err: errors.New("dial tcp: connection refused"),
+			want: upstreamFailure{Upstream: "abs", Status: http.StatusServiceUnavailable, Message: "ABS API unavailable"}},
+		{name: "ABS rejected the query", err: &fetch.StatusError{Code: http.StatusBadRequest},
+			want: upstreamFailure{Upstream: "abs", UpstreamStatus: http.StatusBadRequest, Status: http.StatusBadRequest,
+				Message: "The ABS API rejected the query, check the dataflow, key and periods"}},
+		{name: "plot service unreachable", err: fmt.Errorf("%w: connection refused", plotservice.ErrUnavailable),
+			want: upstreamFailure{Upstream: "plotservice", Status: http.StatusServiceUnavailable, Message: "Python service unavailable"}},
+		{name: "database down", err: database(errors.New("failed to connect to host=localhost")),
+			want: upstreamFailure{Upstream: "database", Status: http.StatusServiceUnavailable, Message: "Database unavailable"}},
+		{name: "database query timed out", err: database(context.DeadlineExceeded),
+			want: upstreamFailure{Upstream: "database", Status: http.StatusGatewayTimeout, Message: "Database timed out"}},
+		{name: "nothing stored", err: fmt.Errorf("%w: CPI/all", source.ErrNotStored),
+			want: upstreamFailure{Upstream: "database", Status: http.StatusNotFound,
+				Message: "No stored observations cover the query, download them or ask with source=live"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyUpstream(tt.err); got != tt.want {
+				t.Errorf("classifyUpstream(%v) = %+v, want %+v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
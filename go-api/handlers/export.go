@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/export"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/jobs"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/transform"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+)
+
+// exports can be whole dataflows, past this the job carries on without the request
+const exportWait = 5 * time.Minute
+
+func exportTitle(params jobs.ExportParams) string {
+	title := "Export " + params.Query.DataflowID
+	if params.Query.Key != "" && params.Query.Key != "all" {
+		title += " " + params.Query.Key
+	}
+	return title + " as " + string(params.Format)
+}
+
+func validateExportParams(params *jobs.ExportParams) error {
+	if err := validateDataQuery(params.Query); err != nil {
+		return err
+	}
+	format, err := export.ParseFormat(string(params.Format))
+	if err != nil {
+		return err
+	}
+	params.Format = format
+	if err := transform.Check(params.Transforms); err != nil {
+		return err
+	}
+	model, err := transform.ParseModel(string(params.Model))
+	if err != nil {
+		return err
+	}
+	params.Model = model
+	if params.Period < 0 {
+		return fmt.Errorf("invalid period: %d", params.Period)
+	}
+	if params.Source != "" {
+		if _, err := source.ParseMode(string(params.Source)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportHandler endpoint /api/export?dataflowid=CPI&key=all&format=csv|xlsx|parquet&source=database
+// Runs an export job and waits for the file, so identical exports share one
+// download. Transforms are applied so the file matches what the dashboard shows.
+func ExportHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager, exportDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := dataQuery(r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		q := r.URL.Query()
+		mode, _ := dataMode(r)
+		params := jobs.ExportParams{
+			Query:      query,
+			Source:     mode,
+			Format:     export.Format(q.Get("format")),
+			Transforms: q["transform"],
+			Model:      transform.Model(q.Get("model")),
+		}
+		if params.Period, err = transform.ParsePeriod(q.Get("period")); err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateExportParams(&params); err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		job, err := manager.Submit(r.Context(), jobs.KindExport, exportTitle(params), params)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to queue job", "kind", jobs.KindExport, "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to queue the export")
+			return
+		}
+		waitCtx, cancel := context.WithTimeout(r.Context(), exportWait)
+		defer cancel()
+		id := job.ID
+		job, err = manager.Wait(waitCtx, id)
+		var transformErr *transform.Error
+		switch {
+		case r.Context().Err() != nil:
+			// the client left, the job carries on for anyone else waiting on it
+			return
+		case waitCtx.Err() != nil:
+			apiError(w, r, http.StatusGatewayTimeout, "Timed out waiting for the export, it carries on as job "+id)
+			return
+		case errors.As(err, &transformErr):
+			apiError(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		case errors.Is(err, jobs.ErrCancelled):
+			apiError(w, r, http.StatusConflict, "The export was cancelled")
+			return
+		case err != nil:
+			logger.ErrorContext(r.Context(), "Failed to export", "job", job.ID, "dataflow", query.DataflowID, "err", err)
+			upstreamAPIError(w, r, err)
+			return
+		}
+		serveExport(w, r, logger, exportDir, job)
+	})
+}
+
+// ExportJobHandler endpoint POST /api/v1/jobs/export, body a jobs.ExportParams
+// The file is downloaded from /api/v1/jobs/{id}/file once the job succeeds.
+func ExportJobHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, err := utils.Decode[jobs.ExportParams](r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Query.DataflowID = strings.ToUpper(params.Query.DataflowID)
+		if err := validateExportParams(&params); err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		submitJob(w, r, logger, manager, jobs.KindExport, exportTitle(params), params)
+	})
+}
+
+// JobFileHandler endpoint GET /api/v1/jobs/{id}/file
+func JobFileHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager, exportDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job, err := manager.Get(r.Context(), r.PathValue("id"))
+		if err != nil {
+			status, message := jobError(r.Context(), logger, err)
+			apiError(w, r, status, message)
+			return
+		}
+		if job.Kind != jobs.KindExport {
+			apiError(w, r, http.StatusNotFound, "job has no file, it isn't an export")
+			return
+		}
+		if job.State != jobs.StateSucceeded {
+			apiError(w, r, http.StatusConflict, "export is "+string(job.State))
+			return
+		}
+		serveExport(w, r, logger, exportDir, job)
+	})
+}
+
+func serveExport(w http.ResponseWriter, r *http.Request, logger *slog.Logger, exportDir string, job jobs.Job) {
+	f, result, err := jobs.OpenExport(exportDir, job)
+	if errors.Is(err, fs.ErrNotExist) {
+		apiError(w, r, http.StatusNotFound, "export file has expired, export again")
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to open export", "job", job.ID, "err", err)
+		apiError(w, r, http.StatusInternalServerError, "Failed to open export")
+		return
+	}
+	defer f.Close()
+
+	setDataSource(w, &fetch.Dataset{Source: result.Source, RetrievedAt: result.RetrievedAt})
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, result.Filename))
+	http.ServeContent(w, r, result.Filename, job.UpdatedAt, f)
+	logger.InfoContext(r.Context(), "Exported observations", "job", job.ID, "count", result.Observations, "file", result.Filename)
+}
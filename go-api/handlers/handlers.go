@@ -1,450 +1,225 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"html/template"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"strings"
-	"time"
 
 	"github.com/VooDooM1234/abs-visualiser/go-api/config"
 	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/health"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
 )
 
 // https://grafana.com/blog/2024/02/09/how-i-write-http-services-in-go-after-13-years/#maker-funcs-return-the-handler
-// func handleSomething(config *config.Config, logger *log.Logger) http.Handler {
+// func handleSomething(config *config.Config, logger *slog.Logger) http.Handler {
 //     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 //         // handler logic
 //     })
 // }
 
-type DataflowABS struct {
-	// Status       string `json:"status"`
-	DataflowID   string `json:"dataflowid"`
-	DataflowName string `json:"dataflowname"`
-}
-
 // seperate the fetching and handling
-func RequestDataflowABS(config *config.Config, logger *log.Logger) http.Handler {
-	path := config.HTMLTemplates + "dataflow_contents.html"
-	tmpl := template.Must(template.ParseFiles(path))
+func RequestDataflowABS(config *config.Config, logger *slog.Logger, plot *plotservice.Client, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		url := fmt.Sprintf("http://%s:%d/request-dataflow/ABS/", config.Host, config.PlotServicePort)
-		logger.Printf("GET request to: %s", url)
-		resp, err := http.Get(url)
-		if err != nil {
-			http.Error(w, "Python service unavailable", http.StatusBadGateway)
-			logger.Printf("Failed to GET ABS dataflows: %v", err)
-			return
-		}
-
-		defer resp.Body.Close()
-
-		body, err := io.ReadAll(resp.Body)
+		dataflows, err := plot.Dataflows(r.Context())
 		if err != nil {
-			logger.Printf("Error reading response body: %v", err)
-			http.Error(w, "Failed to read response", http.StatusInternalServerError)
-			return
-		}
-		// fix genric error reponse handler
-		// if err := utils.CheckFailureResponse(body, logger); err != nil {
-		// 	http.Error(w, err.Error(), http.StatusInternalServerError)
-		// 	log.Printf("Failed to check generic response: %v", err)
-		// 	return
-		// }
-
-		var result []DataflowABS
-		if err := json.Unmarshal(body, &result); err != nil {
-			log.Printf("Failed to parse JSON: %v", err)
-			http.Error(w, "Invalid response format", http.StatusInternalServerError)
-			return
-		}
-
-		// move the serviering to a handler func
-		if err := tmpl.Execute(w, result); err != nil {
-			http.Error(w, "Failed to render template", http.StatusInternalServerError)
-			logger.Printf("Template execution error: %v", err)
+			logger.ErrorContext(r.Context(), "Plot service request failed", "err", err)
+			upstreamFragmentError(w, r, pages, err)
 			return
 		}
-
+		pages.Render(w, r, "dataflow_contents.html", dataflows)
 	})
 }
 
-func validateGraphName(name string) error {
-	var allowedGraphs = map[string]bool{
-		"line":    true,
-		"bar":     true,
-		"pie":     true,
-		"scatter": true,
-	}
-	if _, ok := allowedGraphs[name]; !ok {
-		return fmt.Errorf("invalid graph name: %s", name)
-	}
-	return nil
-}
-
-// Fix this to not run query every time and finish caching function
-func validateDataflowName(id string, db db.Database) error {
-	query := fmt.Sprintf("SELECT * FROM abs_static_dataflow WHERE id = '%s'", id)
-
-	allowedDataflows, err := db.GetABSDataflow(query)
-	if err != nil {
-		return fmt.Errorf("error fetching dataflow names: %w", err)
-	}
-
-	for _, dataflow := range allowedDataflows {
-		if dataflow.ID == strings.ToUpper(id) {
-			return nil
-		}
-	}
-	return fmt.Errorf("invalid dataflow name: %s", id)
-}
-
-func HealthHandler(config *config.Config, logger *log.Logger) http.Handler {
+// HealthLiveHandler endpoint /health/live
+// Liveness only, answers as long as the process is serving requests.
+func HealthLiveHandler(config *config.Config, logger *slog.Logger, checker *health.Checker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
-}
-
-func SidebarHandler(config *config.Config, logger *log.Logger) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		path := config.HTMLTemplates + "sidebar.html"
-		tmpl := template.Must(template.ParseFiles(path))
-
-		if err := tmpl.Execute(w, nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := utils.Encode(w, http.StatusOK, checker.Live()); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
 		}
-		log.Print("Sidebar loaded")
 	})
 }
 
-func IndexHandler(config *config.Config, logger *log.Logger) http.Handler {
+// HealthReadyHandler endpoint /health/ready
+// Checks every dependency, 503 when a critical one is down.
+func HealthReadyHandler(config *config.Config, logger *slog.Logger, checker *health.Checker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		path := config.HTMLTemplates + "index.html"
-		tmpl := template.Must(template.ParseFiles(path))
-
-		if err := tmpl.Execute(w, nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		report := checker.Ready(r.Context())
+		status := http.StatusOK
+		if report.Status == health.StatusDown {
+			status = http.StatusServiceUnavailable
+		}
+		for name, result := range report.Checks {
+			if result.Status == health.StatusDown {
+				logger.WarnContext(r.Context(), "Health check failed", "check", name, "err", result.Error)
+			}
+		}
+		if err := utils.Encode(w, status, report); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
 		}
-		log.Print("Home Page")
 	})
 }
 
-func HomeHandler(config *config.Config, logger *log.Logger) http.Handler {
+func SidebarHandler(config *config.Config, logger *slog.Logger, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		path := config.HTMLTemplates + "home.html"
-		tmpl := template.Must(template.ParseFiles(path))
-
-		if err := tmpl.Execute(w, nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		log.Print("Home Page")
+		pages.Render(w, r, "sidebar.html", nil)
+		logger.DebugContext(r.Context(), "Sidebar loaded")
 	})
 }
 
-// Dashboard Page Handler
-func DashboardHandler(cfg *config.Config, logger *log.Logger) http.Handler {
-	path := cfg.HTMLTemplates + "dashboard.html"
-	tmpl := template.Must(template.ParseFiles(path))
-
+func IndexHandler(config *config.Config, logger *slog.Logger, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-
-		dataflowid := r.URL.Query().Get("dataflowid")
-		if dataflowid == "" {
-			dataflowid = "CPI"
-		}
-
-		if err := tmpl.Execute(w, dataflowid); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logger.Printf("Error executing dashboard template: %v", err)
-		}
-		log.Println("Dashboard Page")
+		data := map[string]string{"Content": "/home"}
+		pages.Render(w, r, "index.html", data)
+		logger.DebugContext(r.Context(), "Home page")
 	})
 }
 
-// Reverse proxy for Ploty Dash (Python mservice)
-func ReverseProxyDashHandler(cfg *config.Config, logger *log.Logger) http.Handler {
+func HomeHandler(config *config.Config, logger *slog.Logger, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		target, err := url.Parse(fmt.Sprintf("http://%s:%d", cfg.PlotServiceHost, cfg.PlotServicePort))
-		if err != nil {
-			logger.Printf("Dash Reverse Proxy parse error: %v", err)
-			http.Error(w, "bad upstream", http.StatusInternalServerError)
-			return
-		}
-
-		proxy := httputil.NewSingleHostReverseProxy(target)
-		// allow iframe serving for dashboard
-		proxy.ModifyResponse = func(res *http.Response) error {
-			res.Header.Del("X-Frame-Options")
-			res.Header.Del("Content-Security-Policy")
-			return nil
-		}
-
-		proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-			logger.Printf("Proxy error: %v", err)
-			http.Error(rw, "Upstream unavailable", http.StatusBadGateway)
-		}
-
-		proxy.ServeHTTP(w, r)
+		pages.Render(w, r, "home.html", nil)
+		logger.DebugContext(r.Context(), "Home page")
 	})
 }
 
-// Get request to python data science to request ABS data
-// for raw data expertimentation - will be made redundent by a direct call for a dashboard request.
-func RequestABSData(config *config.Config, logger *log.Logger) http.Handler {
-	type ABSresponse struct {
-		MEASURE     string  `json:"MEASURE"`
-		INDEX       string  `json:"INDEX"`
-		TSEST       string  `json:"TSEST"`
-		REGION      string  `json:"REGION"`
-		FREQ        string  `json:"FREQ"`
-		TIME_PERIOD string  `json:"TIME_PERIOD"`
-		Value       float64 `json:"VALUE"`
+// defaultPanels is the dashboard shown for a dataflow picked from the catalogue
+func defaultPanels(query fetch.DataQuery) []db.Panel {
+	base := db.Panel{
+		DataflowID:  query.DataflowID,
+		Key:         query.Key,
+		StartPeriod: query.StartPeriod,
+		EndPeriod:   query.EndPeriod,
+	}
+	if base.Key == "" {
+		base.Key = "all"
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		dataflowid := r.URL.Query().Get("dataflowid")
-		if dataflowid == "" {
-			http.Error(w, "Missing dataflowid parameter", http.StatusBadRequest)
-			return
-		}
-
-		var payload = map[string]string{
-			"dataflowid": dataflowid,
-		}
-
-		jsonPayload, err := json.Marshal(payload)
-		if err != nil {
-			logger.Printf("Failed to marshal payload: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		logger.Printf("Retrieving data for dataflow: %s...", dataflowid)
-		microserviceurl := fmt.Sprintf("http://%s:%d/request-data/ABS/", config.PlotServiceHost, config.PlotServicePort)
-		logger.Printf("POST request to: %s", microserviceurl)
-		req, err := http.NewRequest("POST", microserviceurl, bytes.NewBuffer(jsonPayload))
-		if err != nil {
-			logger.Printf("Failed to create POST request: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{Timeout: 120 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			logger.Printf("Python service unavailable: %v", err)
-			http.Error(w, "Python service unavailable", http.StatusBadGateway)
-			return
-		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logger.Printf("Error reading response body: %v", err)
-			http.Error(w, "Failed to read response", http.StatusInternalServerError)
-			return
-		}
-
-		var result []ABSresponse
-		if err := json.Unmarshal(body, &result); err != nil {
-			log.Printf("Failed to parse JSON: %v", err)
-			http.Error(w, "Invalid response format", http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(result); err != nil {
-			logger.Printf("Failed to write response: %v", err)
-			http.Error(w, "Failed to write response", http.StatusInternalServerError)
-			return
-		}
-		logger.Printf("Successfully retrieved data for dataflow: %s", dataflowid)
-	})
+	line, bar, seasonal := base, base, base
+	line.Title, line.Chart, line.Width, line.Height = "Series", "line", 12, 4
+	bar.Title, bar.Chart, bar.Row, bar.Width, bar.Height = "Observations", "bar", 1, 6, 4
+	seasonal.Title, seasonal.Chart, seasonal.Row, seasonal.Col, seasonal.Width, seasonal.Height = "Seasonally adjusted (trend, seasonal, residual)", "line", 1, 6, 6, 4
+	seasonal.Transforms = []string{"seasonal"}
+	return []db.Panel{line, bar, seasonal}
+}
+
+func renderDataflowDashboard(w http.ResponseWriter, r *http.Request, pages *views.Registry, query fetch.DataQuery) {
+	data := map[string]any{
+		"DataflowID":  query.DataflowID,
+		"Key":         query.Key,
+		"StartPeriod": query.StartPeriod,
+		"EndPeriod":   query.EndPeriod,
+		"Rows":        dashboardRows(defaultPanels(query)),
+	}
+	pages.Render(w, r, "dashboard.html", data)
 }
 
-// Plothandler endpoint /plot/{graphName}/{dataflow}
-// change to use querty param nor endpoint
-func PlotHandler(config *config.Config, logger *log.Logger, db *db.Database) http.Handler {
+// Dashboard Page Handler
+func DashboardHandler(cfg *config.Config, logger *slog.Logger, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-
-		urlParts := strings.Split(r.URL.Path, "/")
-		pathMap := make(map[string]string)
-		if len(urlParts) > 1 {
-			pathMap["base"] = urlParts[1]
+		q := r.URL.Query()
+		if q.Get("dataflowid") == "" {
+			q.Set("dataflowid", "CPI")
+			r.URL.RawQuery = q.Encode()
 		}
-		if len(urlParts) > 2 {
-			pathMap["graphName"] = urlParts[2]
-		}
-		if len(urlParts) > 3 {
-			pathMap["dataflow"] = urlParts[3]
-		}
-
-		if err := validateGraphName(pathMap["graphName"]); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid graph name: %s", pathMap["graphName"]), http.StatusBadRequest)
-			logger.Printf("Invalid graph name: %s", pathMap["graphName"])
-			return
-		}
-		dataflow := strings.ToUpper(pathMap["dataflow"])
-		if err := validateDataflowName(dataflow, *db); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid dataflow name: %s", dataflow), http.StatusBadRequest)
-			logger.Printf("Invalid dataflow name: %s", dataflow)
-			logger.Printf("Dataflow validation failed: %v", err)
-			return
-		}
-
-		url := fmt.Sprintf("http://%s:%s/plot/%s/%s", config.Host, config.PlotServicePort, pathMap["graphName"], pathMap["dataflow"])
-		resp, err := http.Get(url)
+		query, err := dataQuery(r)
 		if err != nil {
-			http.Error(w, "Python service unavailable", http.StatusBadGateway)
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
 			return
 		}
-		defer resp.Body.Close()
 
-		htmlBytes, _ := io.ReadAll(resp.Body)
-		htmlString := string(htmlBytes)
-		fmt.Fprint(w, htmlString)
+		renderDataflowDashboard(w, r, pages, query)
+		logger.DebugContext(r.Context(), "Dashboard page")
 	})
 }
 
-type RefreshDashboardResponse struct {
-	Status string `json:"status"`
-	// DataflowID string `json:"dataflowid"`
-	// Timestamp  string `json:"timestamp"`
-}
-
-func RefreshDashboardhandler(config *config.Config, logger *log.Logger, db *db.Database) http.Handler {
+// Get request to python data science to request ABS data
+// for raw data expertimentation - will be made redundent by a direct call for a dashboard request.
+func RequestABSData(config *config.Config, logger *slog.Logger, plot *plotservice.Client) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		dataflowid := "CPI"
-		var payload = map[string]string{
-			"dataflowid": dataflowid,
-		}
 
-		body, err := json.Marshal(payload)
-		if err != nil {
-			logger.Printf("Failed to marshal payload: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		dataflowid := r.URL.Query().Get("dataflowid")
+		if dataflowid == "" {
+			apiError(w, r, http.StatusBadRequest, "Missing dataflowid parameter")
 			return
 		}
 
-		logger.Printf("Retrieving data for dataflow: %s...", dataflowid)
-
-		url := fmt.Sprintf("http://%s:%s/refresh-dashboard/", config.Host, "54850")
-		logger.Printf("POST request to: %s", url)
-		// trying different method of posting rather then http.NewRequest and a DO method like most articles seem to say idk why they dont use this when is seems easier?
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+		logger.InfoContext(r.Context(), "Retrieving data for dataflow", "dataflow", dataflowid)
+		records, err := plot.Data(r.Context(), dataflowid)
 		if err != nil {
-			http.Error(w, "Python service unavailable", http.StatusBadGateway)
-			return
-		}
-		defer resp.Body.Close()
-
-		var result RefreshDashboardResponse
-		if err := json.Unmarshal(body, &result); err != nil {
-			log.Printf("Failed to parse JSON: %v", err)
-			http.Error(w, "Invalid response format", http.StatusInternalServerError)
+			logger.ErrorContext(r.Context(), "Plot service request failed", "err", err)
+			upstreamAPIError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(result); err != nil {
-			logger.Printf("Failed to write response: %v", err)
-			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		if err := utils.Encode(w, http.StatusOK, records); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
 			return
 		}
-
+		logger.InfoContext(r.Context(), "Retrieved data for dataflow", "dataflow", dataflowid)
 	})
 }
 
-func GetDashboardHandler(cfg *config.Config, logger *log.Logger) http.Handler {
-	path := cfg.HTMLTemplates + "dashboard.html"
-	tmpl := template.Must(template.ParseFiles(path))
-
+// GetDashboardHandler endpoint /get-dashboard/
+// Renders the dashboard fragment for the dataflowid, key, startPeriod and endPeriod form values.
+func GetDashboardHandler(cfg *config.Config, logger *slog.Logger, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := r.ParseForm()
 		if err != nil {
-			logger.Printf("Invalid request: %v", err)
-			http.Error(w, "Invalid form", http.StatusBadRequest)
+			logger.WarnContext(r.Context(), "Invalid request", "err", err)
+			fragmentError(w, r, pages, http.StatusBadRequest, "Invalid form")
 			return
 		}
 
-		dataflowID := r.FormValue("dataflowid")
-		if dataflowID == "" {
-			logger.Printf("Missing dataflowid in request")
-			http.Error(w, "Missing dataflowid", http.StatusBadRequest)
-			return
+		query := fetch.DataQuery{
+			DataflowID:  strings.ToUpper(r.FormValue("dataflowid")),
+			Key:         r.FormValue("key"),
+			StartPeriod: r.FormValue("startPeriod"),
+			EndPeriod:   r.FormValue("endPeriod"),
 		}
-
-		// Step 1: Notify Dash microservice
-		// url := fmt.Sprintf("http://%s:%s/refresh-dashboard/", cfg.PlotServiceHost, cfg.PlotServicePort)
-		body, _ := json.Marshal(map[string]string{"dataflowid": dataflowID})
-		url := "http://localhost:8082/dashboard/refresh-dashboard/"
-		logger.Printf("Sending Payload: %s", body)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
-		if err != nil || resp.StatusCode != http.StatusOK {
-			logger.Printf("Failed to refresh dashboard data: %v", err)
-			http.Error(w, "Failed to refresh dashboard data", http.StatusBadGateway)
+		if query.DataflowID == "" {
+			logger.WarnContext(r.Context(), "Missing dataflowid in request")
+			fragmentError(w, r, pages, http.StatusBadRequest, "Missing dataflowid")
 			return
 		}
-		defer resp.Body.Close()
-
-		// Step 2: Render dashboard fragment
-		data := map[string]string{"DataflowID": dataflowID}
-		if err := tmpl.Execute(w, data); err != nil {
-			http.Error(w, "Failed to render dashboard", http.StatusInternalServerError)
-			logger.Printf("Dashboard template error: %v", err)
-			return
+		for _, period := range []string{query.StartPeriod, query.EndPeriod} {
+			if period != "" && !fetch.PeriodPattern.MatchString(period) {
+				fragmentError(w, r, pages, http.StatusBadRequest, fmt.Sprintf("Invalid period: %s", period))
+				return
+			}
 		}
+
+		renderDataflowDashboard(w, r, pages, query)
 	})
 }
 
-func PlotTestHandler(config *config.Config, logger *log.Logger) http.Handler {
+func PlotTestHandler(config *config.Config, logger *slog.Logger, plot *plotservice.Client, pages *views.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		url := fmt.Sprintf("http://%s:%d/plot/test", config.Host, config.PlotServicePort)
-		resp, err := http.Get(url)
-
+		html, err := plot.TestPlot(r.Context())
 		if err != nil {
-			http.Error(w, "Python service unavailable", http.StatusBadGateway)
+			logger.ErrorContext(r.Context(), "Plot service request failed", "err", err)
+			upstreamFragmentError(w, r, pages, err)
 			return
 		}
-
-		defer resp.Body.Close()
-		htmlBytes, _ := io.ReadAll(resp.Body)
-		htmlString := string(htmlBytes)
-		fmt.Fprint(w, htmlString)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(html)
 	})
 }
 
-func PlotTestJSONHandler(config *config.Config, logger *log.Logger) http.Handler {
+func PlotTestJSONHandler(config *config.Config, logger *slog.Logger, plot *plotservice.Client) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-
-		url := fmt.Sprintf("http://%s:%d/plot/test/json", config.Host, config.PlotServicePort)
-		resp, err := http.Get(url)
+		fig, err := plot.TestPlotJSON(r.Context())
 		if err != nil {
-			http.Error(w, "Python service unavailable", http.StatusBadGateway)
-			logger.Printf("Error fetching JSON from Python service: %v", err)
+			logger.ErrorContext(r.Context(), "Plot service request failed", "err", err)
+			upstreamAPIError(w, r, err)
 			return
 		}
-		defer resp.Body.Close()
-
-		if _, err := io.Copy(w, resp.Body); err != nil {
-			http.Error(w, "Failed to stream response", http.StatusInternalServerError)
-			logger.Printf("Error copying response body: %v", err)
+		if err := utils.Encode(w, http.StatusOK, fig); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
 		}
 	})
 }
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/jobs"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+// a comment every so often stops proxies closing an idle event stream
+const sseHeartbeat = 15 * time.Second
+
+// jobListLimit is how many jobs GET /api/v1/jobs returns unless asked for fewer
+const jobListLimit = 100
+
+// FetchJobHandler endpoint POST /jobs/fetch, form dataflowid, key, startPeriod, endPeriod
+// Queues downloading the data into the database and returns a fragment that
+// follows the job over /jobs/{id}/events.
+func FetchJobHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := dataQuery(r)
+		if err != nil {
+			fragmentError(w, r, pages, http.StatusBadRequest, err.Error())
+			return
+		}
+		job, err := manager.Submit(r.Context(), jobs.KindFetch, jobs.FetchTitle(query), query)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to queue job", "kind", jobs.KindFetch, "err", err)
+			fragmentError(w, r, pages, http.StatusInternalServerError, "Failed to queue the download")
+			return
+		}
+		pages.RenderStatus(w, r, http.StatusAccepted, "job.html", job)
+	})
+}
+
+// JobCancelHandler endpoint POST /jobs/{id}/cancel
+// The event stream shows the job as cancelled once it has stopped.
+func JobCancelHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, err := cancelJob(r.Context(), manager, r.PathValue("id")); err != nil {
+			fragmentError(w, r, pages, status, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// JobEventsHandler endpoint GET /jobs/{id}/events
+// Server-sent events carrying the job-status fragment, for the htmx sse extension.
+func JobEventsHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, updates, stop, err := manager.Subscribe(r.Context(), r.PathValue("id"))
+		if err != nil {
+			status, message := jobError(r.Context(), logger, err)
+			fragmentError(w, r, pages, status, message)
+			return
+		}
+		defer stop()
+		streamJob(w, r, logger, current, updates, func(job jobs.Job) ([]byte, error) {
+			return pages.Execute("job_status.html", job)
+		})
+	})
+}
+
+// JobCreateFetchHandler endpoint POST /api/v1/jobs/fetch, body a fetch.DataQuery
+func JobCreateFetchHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := utils.Decode[fetch.DataQuery](r)
+		if err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		query.DataflowID = strings.ToUpper(query.DataflowID)
+		if err := validateDataQuery(query); err != nil {
+			apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		submitJob(w, r, logger, manager, jobs.KindFetch, jobs.FetchTitle(query), query)
+	})
+}
+
+// CatalogueSyncJobHandler endpoint POST /api/v1/catalogue/sync
+// Queues importing the ABS dataflow catalogue, there is only ever one sync.
+func CatalogueSyncJobHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		submitJob(w, r, logger, manager, jobs.KindSyncCatalogue, "Sync the dataflow catalogue", struct{}{})
+	})
+}
+
+// JobListHandler endpoint GET /api/v1/jobs?kind=fetch&state=running&limit=20
+func JobListHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := jobs.Filter{Kind: q.Get("kind"), State: jobs.State(q.Get("state")), Limit: jobListLimit}
+		switch filter.State {
+		case "", jobs.StateQueued, jobs.StateRunning, jobs.StateSucceeded, jobs.StateFailed, jobs.StateCancelled:
+		default:
+			apiError(w, r, http.StatusBadRequest, "invalid state: "+q.Get("state"))
+			return
+		}
+		if limit := q.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 || n > jobListLimit {
+				apiError(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", jobListLimit))
+				return
+			}
+			filter.Limit = n
+		}
+
+		list, err := manager.List(r.Context(), filter)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to list jobs", "err", err)
+			apiError(w, r, http.StatusInternalServerError, "Failed to list jobs")
+			return
+		}
+		if err := utils.Encode(w, http.StatusOK, list); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// JobReadHandler endpoint GET /api/v1/jobs/{id}
+func JobReadHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job, err := manager.Get(r.Context(), r.PathValue("id"))
+		if err != nil {
+			status, message := jobError(r.Context(), logger, err)
+			apiError(w, r, status, message)
+			return
+		}
+		if err := utils.Encode(w, http.StatusOK, job); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+		}
+	})
+}
+
+// JobDeleteHandler endpoint DELETE /api/v1/jobs/{id}
+// Cancels the job, it's cancelled once the work has stopped.
+func JobDeleteHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, err := cancelJob(r.Context(), manager, r.PathValue("id")); err != nil {
+			apiError(w, r, status, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// JobStreamHandler endpoint GET /api/v1/jobs/{id}/events
+// Server-sent events carrying the job as JSON.
+func JobStreamHandler(cfg *config.Config, logger *slog.Logger, manager *jobs.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, updates, stop, err := manager.Subscribe(r.Context(), r.PathValue("id"))
+		if err != nil {
+			status, message := jobError(r.Context(), logger, err)
+			apiError(w, r, status, message)
+			return
+		}
+		defer stop()
+		streamJob(w, r, logger, current, updates, func(job jobs.Job) ([]byte, error) {
+			return json.Marshal(job)
+		})
+	})
+}
+
+// submitJob queues a job and answers 202 with it, an identical job already
+// queued or running is answered instead
+func submitJob(w http.ResponseWriter, r *http.Request, logger *slog.Logger, manager *jobs.Manager, kind, title string, params any) {
+	job, err := manager.Submit(r.Context(), kind, title, params)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to queue job", "kind", kind, "err", err)
+		apiError(w, r, http.StatusInternalServerError, "Failed to queue the job")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	if err := utils.Encode(w, http.StatusAccepted, job); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to write response", "err", err)
+	}
+}
+
+// jobError is the status and message for an error looking a job up
+func jobError(ctx context.Context, logger *slog.Logger, err error) (int, string) {
+	if errors.Is(err, jobs.ErrNotFound) {
+		return http.StatusNotFound, err.Error()
+	}
+	logger.ErrorContext(ctx, "Failed to get job", "err", err)
+	return http.StatusInternalServerError, "Failed to get job"
+}
+
+func cancelJob(ctx context.Context, manager *jobs.Manager, id string) (int, error) {
+	err := manager.Cancel(ctx, id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		return http.StatusNotFound, err
+	case errors.Is(err, jobs.ErrFinished):
+		return http.StatusConflict, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// streamJob sends a progress event for each snapshot of the job and a done
+// event once it has finished, with data from encode
+func streamJob(w http.ResponseWriter, r *http.Request, logger *slog.Logger, current jobs.Job, updates <-chan jobs.Job, encode func(jobs.Job) ([]byte, error)) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses unless told not to
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(job jobs.Job) error {
+		data, err := encode(job)
+		if err != nil {
+			return err
+		}
+		event := "progress"
+		if job.State.Done() {
+			event = "done"
+		}
+		fmt.Fprintf(w, "event: %s\n", event)
+		for _, line := range strings.Split(string(data), "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+		return rc.Flush()
+	}
+
+	if err := send(current); err != nil {
+		logger.WarnContext(r.Context(), "Failed to send job event", "job", current.ID, "err", err)
+		return
+	}
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for !current.State.Done() {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case job, ok := <-updates:
+			if !ok {
+				return
+			}
+			current = job
+			if err := send(current); err != nil {
+				logger.WarnContext(r.Context(), "Failed to send job event", "job", current.ID, "err", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+)
+
+// MetricsHandler endpoint /metrics
+// Prometheus text format.
+func MetricsHandler(cfg *config.Config, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := metrics.Default.WriteTo(w); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write metrics", "err", err)
+		}
+	})
+}
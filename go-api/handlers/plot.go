@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+var (
+	plotFormats    = []string{"html", "json"}
+	plotTransforms = []string{"seasonal"}
+	// dimension codes separated by dots, a dimension can be empty for all
+	// codes or list several joined with +
+	keyPattern = regexp.MustCompile(`^[A-Za-z0-9_@$-]*(\+[A-Za-z0-9_@$-]+)*(\.[A-Za-z0-9_@$-]*(\+[A-Za-z0-9_@$-]+)*)*$`)
+)
+
+// plotQuery is the parsed path and query parameters of GET /plot/{graph}/{dataflow}
+type plotQuery struct {
+	Graph  string
+	Format string
+	Data   fetch.DataQuery
+}
+
+// dataflowCatalogue is the part of *catalogue.Cache the plot handler uses
+type dataflowCatalogue interface {
+	Contains(id string) (bool, error)
+}
+
+// parsePlotQuery checks each parameter in turn and says which one is wrong
+// and what it expected. Format is read first so the caller knows how to
+// report the error. On error it also returns the HTTP status to reply with.
+func parsePlotQuery(r *http.Request, registry *charts.Registry, dataflows dataflowCatalogue) (plotQuery, int, error) {
+	q := r.URL.Query()
+	query := plotQuery{
+		Graph:  r.PathValue("graph"),
+		Format: q.Get("format"),
+		Data: fetch.DataQuery{
+			DataflowID:  strings.ToUpper(r.PathValue("dataflow")),
+			Key:         q.Get("key"),
+			StartPeriod: q.Get("startPeriod"),
+			EndPeriod:   q.Get("endPeriod"),
+		},
+	}
+
+	if query.Format == "" {
+		query.Format = "html"
+	}
+	if !slices.Contains(plotFormats, query.Format) {
+		bad := query.Format
+		query.Format = "html"
+		return query, http.StatusBadRequest, fmt.Errorf("unknown format %q, expected one of %s", bad, strings.Join(plotFormats, ", "))
+	}
+
+	if _, err := registry.Lookup(query.Graph, charts.RendererPython); err != nil {
+		return query, http.StatusBadRequest, err
+	}
+	if _, err := dataMode(r); err != nil {
+		return query, http.StatusBadRequest, err
+	}
+
+	ok, err := dataflows.Contains(query.Data.DataflowID)
+	if err != nil {
+		return query, http.StatusServiceUnavailable, fmt.Errorf("dataflow catalogue unavailable: %w", err)
+	}
+	if !ok {
+		return query, http.StatusNotFound, fmt.Errorf("unknown dataflow %q, see /api/v1/catalogue for the list", query.Data.DataflowID)
+	}
+
+	if query.Data.Key == "" {
+		query.Data.Key = "all"
+	}
+	if query.Data.Key != "all" && !keyPattern.MatchString(query.Data.Key) {
+		return query, http.StatusBadRequest, fmt.Errorf("invalid key %q, expected dimension codes separated by dots, eg. 1.10001.10.50.Q", query.Data.Key)
+	}
+
+	for _, p := range [][2]string{{"startPeriod", query.Data.StartPeriod}, {"endPeriod", query.Data.EndPeriod}} {
+		if p[1] != "" && !fetch.PeriodPattern.MatchString(p[1]) {
+			return query, http.StatusBadRequest, fmt.Errorf("invalid %s %q, expected a year with an optional period, eg. 2015, 2015-Q1 or 2015-01", p[0], p[1])
+		}
+	}
+	if start, end := query.Data.StartPeriod, query.Data.EndPeriod; start != "" && end != "" && periodAfter(start, end) {
+		return query, http.StatusBadRequest, fmt.Errorf("startPeriod %s is after endPeriod %s", start, end)
+	}
+
+	for _, name := range q["transform"] {
+		if !slices.Contains(plotTransforms, name) {
+			return query, http.StatusBadRequest, fmt.Errorf("unknown transform %q, expected one of %s", name, strings.Join(plotTransforms, ", "))
+		}
+		if name == "seasonal" {
+			if _, err := seasonalOptions(r); err != nil {
+				return query, http.StatusBadRequest, err
+			}
+		}
+	}
+	return query, http.StatusOK, nil
+}
+
+// periodAfter compares two periods that passed fetch.PeriodPattern. Periods
+// of different frequencies only compare by year, 2015-Q1 isn't after 2015-01.
+func periodAfter(a, b string) bool {
+	if periodFrequency(a) != periodFrequency(b) {
+		return a[:4] > b[:4]
+	}
+	return a > b
+}
+
+// periodFrequency is "" for a year, the letter for Q, S and W periods and M
+// for months
+func periodFrequency(p string) string {
+	switch {
+	case len(p) == 4:
+		return ""
+	case p[5] >= '0' && p[5] <= '9':
+		return "M"
+	}
+	return p[5:6]
+}
+
+// PlotHandler endpoint GET /plot/{graph}/{dataflow}?key=...&startPeriod=2015&transform=seasonal&format=html
+// Fetches and transforms the data here and has the plot service draw it, as an
+// HTML fragment or with format=json the Plotly figure.
+func PlotHandler(cfg *config.Config, logger *slog.Logger, data *source.Source, plot *plotservice.Client, registry *charts.Registry, dataflows dataflowCatalogue, pages *views.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, status, err := parsePlotQuery(r, registry, dataflows)
+		fail := func(status int, message string) {
+			if query.Format == "json" {
+				apiError(w, r, status, message)
+				return
+			}
+			fragmentError(w, r, pages, status, message)
+		}
+		failUpstream := func(err error) {
+			if query.Format == "json" {
+				upstreamAPIError(w, r, err)
+				return
+			}
+			upstreamFragmentError(w, r, pages, err)
+		}
+		if err != nil {
+			logger.WarnContext(r.Context(), "Invalid plot request", "url", r.URL.String(), "err", err)
+			fail(status, err.Error())
+			return
+		}
+
+		ds, err := readDataset(w, r, data, query.Data)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch ABS data", "dataflow", query.Data.DataflowID, "err", err)
+			failUpstream(err)
+			return
+		}
+		if status, err := applyTransform(r, ds); err != nil {
+			fail(status, err.Error())
+			return
+		}
+		if len(ds.Observations) == 0 {
+			fail(http.StatusNotFound, fmt.Sprintf("no observations for %s with key %s", query.Data.DataflowID, query.Data.Key))
+			return
+		}
+
+		title := query.Data.DataflowID
+		if query.Data.Key != "all" {
+			title += " " + query.Data.Key
+		}
+		body, err := plot.Plot(r.Context(), query.Graph, plotservice.PlotRequest{
+			Title:        title,
+			Format:       query.Format,
+			Observations: ds.Observations,
+		})
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Plot service request failed", "err", err)
+			failUpstream(err)
+			return
+		}
+
+		if query.Format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "text/html")
+		}
+		w.Write(body)
+	})
+}
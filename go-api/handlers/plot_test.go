@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+// fakeCatalogue knows the dataflows listed, err fails every lookup
+type fakeCatalogue struct {
+	ids []string
+	err error
+}
+
+func (c fakeCatalogue) Contains(id string) (bool, error) {
+	if c.err != nil {
+		return false, c.err
+	}
+	for _, known := range c.ids {
+		if strings.EqualFold(known, id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// plotRequest is GET /plot/{graph}/{dataflow}?query with the path values set
+func plotRequest(graph, dataflow, query string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/plot/"+graph+"/"+dataflow+"?"+query, nil)
+	r.SetPathValue("graph", graph)
+	r.SetPathValue("dataflow", dataflow)
+	return r
+}
+
+func TestParsePlotQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataflow string
+		query    string
+		want     plotQuery
+	}{
+		{
+			name: "everything given", dataflow: "CPI", query: "key=1.10001.10.50.Q&startPeriod=2015-Q1&endPeriod=2020-Q4&format=json",
+			want: plotQuery{Graph: "line", Format: "json", Data: fetch.DataQuery{DataflowID: "CPI", Key: "1.10001.10.50.Q", StartPeriod: "2015-Q1", EndPeriod: "2020-Q4"}},
+		},
+		{
+			name: "defaults", dataflow: "CPI",
+			want: plotQuery{Graph: "line", Format: "html", Data: fetch.DataQuery{DataflowID: "CPI", Key: "all"}},
+		},
+		{
+			name: "seasonal", dataflow: "CPI", query: "transform=seasonal&model=multiplicative&period=4",
+			want: plotQuery{Graph: "line", Format: "html", Data: fetch.DataQuery{DataflowID: "CPI", Key: "all"}},
+		},
+		{
+			name: "lower case dataflow", dataflow: "cpi",
+			want: plotQuery{Graph: "line", Format: "html", Data: fetch.DataQuery{DataflowID: "CPI", Key: "all"}},
+		},
+		{
+			name: "wildcard and alternative codes", dataflow: "CPI", query: "key=1..10%2B20.50.Q",
+			want: plotQuery{Graph: "line", Format: "html", Data: fetch.DataQuery{DataflowID: "CPI", Key: "1..10+20.50.Q"}},
+		},
+		{
+			name: "years compare across frequencies", dataflow: "CPI", query: "startPeriod=2015-Q1&endPeriod=2015-01",
+			want: plotQuery{Graph: "line", Format: "html", Data: fetch.DataQuery{DataflowID: "CPI", Key: "all", StartPeriod: "2015-Q1", EndPeriod: "2015-01"}},
+		},
+	}
+	registry := charts.Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, status, err := parsePlotQuery(plotRequest("line", tt.dataflow, tt.query), registry, fakeCatalogue{ids: []string{"CPI", "LF"}})
+			if err != nil || status != http.StatusOK {
+				t.Fatalf("status %d, error %v", status, err)
+			}
+			if !reflect.DeepEqual(query, tt.want) {
+				t.Errorf("parsed %+v, want %+v", query, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePlotQueryErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		graph      string
+		dataflow   string
+		query      string
+		catalogue  fakeCatalogue
+		wantStatus int
+		want       string
+	}{
+		{name: "unknown format", query: "format=png", wantStatus: http.StatusBadRequest, want: `unknown format "png", expected one of html, json`},
+		{name: "unknown graph", graph: "radar", wantStatus: http.StatusBadRequest, want: `unknown chart type "radar", expected one of line, bar, scatter, pie, histogram`},
+		{name: "bad source", query: "source=cache2", wantStatus: http.StatusBadRequest, want: `unknown data source "cache2", expected database, live or cache`},
+		{name: "unknown dataflow", dataflow: "NOPE", wantStatus: http.StatusNotFound, want: `unknown dataflow "NOPE", see /api/v1/catalogue for the list`},
+		{
+			name: "catalogue unavailable", catalogue: fakeCatalogue{err: errors.New("connection refused")},
+			wantStatus: http.StatusServiceUnavailable, want: "dataflow catalogue unavailable: connection refused",
+		},
+		{name: "bad key", query: "key=1%3BDROP", wantStatus: http.StatusBadRequest, want: `invalid key "1;DROP", expected dimension codes separated by dots, eg. 1.10001.10.50.Q`},
+		{name: "bad start period", query: "startPeriod=2015Q1", wantStatus: http.StatusBadRequest, want: `invalid startPeriod "2015Q1", expected a year with an optional period, eg. 2015, 2015-Q1 or 2015-01`},
+		{name: "bad end period", query: "endPeriod=last", wantStatus: http.StatusBadRequest, want: `invalid endPeriod "last", expected a year with an optional period, eg. 2015, 2015-Q1 or 2015-01`},
+		{name: "start after end", query: "startPeriod=2020-Q2&endPeriod=2020-Q1", wantStatus: http.StatusBadRequest, want: "startPeriod 2020-Q2 is after endPeriod 2020-Q1"},
+		{name: "start year after end", query: "startPeriod=2021&endPeriod=2020-Q1", wantStatus: http.StatusBadRequest, want: "startPeriod 2021 is after endPeriod 2020-Q1"},
+		{name: "unknown transform", query: "transform=smooth", wantStatus: http.StatusBadRequest, want: `unknown transform "smooth", expected one of seasonal`},
+		{name: "bad seasonal model", query: "transform=seasonal&model=log", wantStatus: http.StatusBadRequest, want: "invalid decomposition model: log"},
+		{name: "bad seasonal period", query: "transform=seasonal&period=1", wantStatus: http.StatusBadRequest, want: "invalid seasonal period: 1"},
+	}
+	registry := charts.Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, dataflow := cmp.Or(tt.graph, "line"), cmp.Or(tt.dataflow, "CPI")
+			catalogue := tt.catalogue
+			if catalogue.err == nil {
+				catalogue.ids = []string{"CPI", "LF"}
+			}
+
+			query, status, err := parsePlotQuery(plotRequest(graph, dataflow, tt.query), registry, catalogue)
+			if status != tt.wantStatus || err == nil || err.Error() != tt.want {
+				t.Errorf("status %d, error %v, want %d, %s", status, err, tt.wantStatus, tt.want)
+			}
+			// the error is reported in a format the client asked for, or html
+			if query.Format != "html" {
+				t.Errorf("format %q, want html", query.Format)
+			}
+		})
+	}
+}
+
+const testObservations = "DATAFLOW,FREQ,TIME_PERIOD,OBS_VALUE\nABS:CPI,Q,2024-Q1,1.5\nABS:CPI,Q,2024-Q2,1.75\n"
+
+// respond answers every request with status and body
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+// testFragments renders error.html as errorFragment does so tests can
+// compare whole bodies
+func testFragments(t *testing.T) *views.Registry {
+	t.Helper()
+	fsys := fstest.MapFS{"error.html": {Data: []byte("{{.Status}} {{.Code}}: {{.Message}}")}}
+	pages, err := views.New(fsys, nil, false, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pages
+}
+
+func errorFragment(status int, message string) string {
+	return fmt.Sprintf("%d %s: %s", status, utils.CodeForStatus(status), template.HTMLEscapeString(message))
+}
+
+func TestPlotHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		// the ABS and plot service answers, a nil plot service can't be reached
+		abs, plot  http.HandlerFunc
+		wantStatus int
+		// wantBody is the whole body, the plot or an errorFragment
+		wantBody string
+		// wantError is the JSON error envelope for format=json errors
+		wantError *utils.Error
+	}{
+		{
+			name: "html plot", path: "/plot/line/CPI?startPeriod=2024",
+			abs: respond(http.StatusOK, testObservations), plot: respond(http.StatusOK, "<div>plot</div>"),
+			wantStatus: http.StatusOK, wantBody: "<div>plot</div>",
+		},
+		{
+			name: "json plot", path: "/plot/line/cpi?format=json",
+			abs: respond(http.StatusOK, testObservations), plot: respond(http.StatusOK, `{"data":[]}`),
+			wantStatus: http.StatusOK, wantBody: `{"data":[]}`,
+		},
+		{
+			name: "unknown chart", path: "/plot/radar/CPI",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   errorFragment(http.StatusBadRequest, `unknown chart type "radar", expected one of line, bar, scatter, pie, histogram`),
+		},
+		{
+			name: "unknown dataflow", path: "/plot/line/NOPE",
+			wantStatus: http.StatusNotFound,
+			wantBody:   errorFragment(http.StatusNotFound, `unknown dataflow "NOPE", see /api/v1/catalogue for the list`),
+		},
+		{
+			name: "invalid key", path: "/plot/line/CPI?key=1%3BDROP",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   errorFragment(http.StatusBadRequest, `invalid key "1;DROP", expected dimension codes separated by dots, eg. 1.10001.10.50.Q`),
+		},
+		{
+			name: "invalid period as json", path: "/plot/line/CPI?format=json&startPeriod=2015Q1",
+			wantStatus: http.StatusBadRequest,
+			wantError: &utils.Error{Code: utils.CodeBadRequest,
+				Message: `invalid startPeriod "2015Q1", expected a year with an optional period, eg. 2015, 2015-Q1 or 2015-01`},
+		},
+		{
+			name: "no observations", path: "/plot/line/CPI",
+			abs:        respond(http.StatusOK, "DATAFLOW,FREQ,TIME_PERIOD,OBS_VALUE\n"),
+			wantStatus: http.StatusNotFound, wantBody: errorFragment(http.StatusNotFound, "no observations for CPI with key all"),
+		},
+		{
+			name: "ABS error", path: "/plot/line/CPI?format=json",
+			abs:        respond(http.StatusInternalServerError, "NoResultsFound"),
+			wantStatus: http.StatusBadGateway,
+			wantError: &utils.Error{Code: utils.CodeUpstreamError, Message: "ABS API error",
+				Details: map[string]any{"upstream": "abs", "upstreamStatus": float64(500)}},
+		},
+		{
+			name: "ABS rejects the query", path: "/plot/line/CPI",
+			abs:        respond(http.StatusBadRequest, "Semantic error"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   errorFragment(http.StatusBadRequest, "The ABS API rejected the query, check the dataflow, key and periods"),
+		},
+		{
+			name: "plot service error", path: "/plot/line/CPI",
+			abs: respond(http.StatusOK, testObservations), plot: respond(http.StatusInternalServerError, `{"detail":"boom"}`),
+			wantStatus: http.StatusBadGateway, wantBody: errorFragment(http.StatusBadGateway, "Python service error"),
+		},
+		{
+			name: "plot service rejects the figure", path: "/plot/line/CPI",
+			abs: respond(http.StatusOK, testObservations), plot: respond(http.StatusUnprocessableEntity, `{"detail":"no numeric column"}`),
+			wantStatus: http.StatusUnprocessableEntity, wantBody: errorFragment(http.StatusUnprocessableEntity, "no numeric column"),
+		},
+		{
+			name: "plot service down", path: "/plot/line/CPI?format=json",
+			abs:        respond(http.StatusOK, testObservations),
+			wantStatus: http.StatusServiceUnavailable,
+			wantError: &utils.Error{Code: utils.CodeUpstreamUnavailable, Message: "Python service unavailable",
+				Details: map[string]any{"upstream": "plotservice"}},
+		},
+	}
+	logger := slog.New(slog.DiscardHandler)
+	pages := testFragments(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			abs := httptest.NewServer(tt.abs)
+			defer abs.Close()
+			u, _ := url.Parse(abs.URL)
+			fetcher := fetch.NewFetch("http", u.Host, 0)
+			fetcher.Logger = logger
+			data := source.New(fetcher, nil, source.Policy{Mode: source.Live}, logger)
+
+			plotServer := httptest.NewServer(tt.plot)
+			if tt.plot == nil {
+				plotServer.Close()
+			} else {
+				defer plotServer.Close()
+			}
+			plot := plotservice.New("localhost", 0, logger)
+			plot.BaseURL = plotServer.URL
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /plot/{graph}/{dataflow}", PlotHandler(&config.Config{}, logger, data, plot,
+				charts.Default(), fakeCatalogue{ids: []string{"CPI"}}, pages))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantError != nil {
+				var got utils.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatalf("body %q isn't an error envelope: %v", w.Body, err)
+				}
+				gotJSON, _ := json.Marshal(got.Error)
+				wantJSON, _ := json.Marshal(tt.wantError)
+				if string(gotJSON) != string(wantJSON) {
+					t.Errorf("error %s, want %s", gotJSON, wantJSON)
+				}
+				return
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body %q, want %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+	// the check has nothing to go on yet, eg. no ABS fetch since startup
+	StatusUnknown Status = "unknown"
+)
+
+// ErrUnknown is returned by a check that can't tell either way yet
+var ErrUnknown = errors.New("no data yet")
+
+// Check is one dependency. Run returns details to show in the report and an
+// error when the dependency is unhealthy.
+type Check struct {
+	Name string
+	// a failing critical check marks the service down and not ready,
+	// anything else only degrades it
+	Critical bool
+	Run      func(ctx context.Context) (any, error)
+}
+
+type Result struct {
+	Status    Status  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	Details   any     `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status    Status            `json:"status"`
+	CheckedAt time.Time         `json:"checkedAt"`
+	Uptime    string            `json:"uptime"`
+	Checks    map[string]Result `json:"checks,omitempty"`
+}
+
+// Checker runs the registered checks concurrently, each with its own timeout
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+	started time.Time
+}
+
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:  checks,
+		timeout: timeout,
+		started: time.Now(),
+	}
+}
+
+// Live only says the process is up and serving, it never touches dependencies
+func (c *Checker) Live() Report {
+	return Report{
+		Status:    StatusOK,
+		CheckedAt: time.Now(),
+		Uptime:    time.Since(c.started).Round(time.Second).String(),
+	}
+}
+
+// Ready runs every check and rolls them up into one status
+func (c *Checker) Ready(ctx context.Context) Report {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := c.Live()
+	report.Checks = make(map[string]Result, len(c.checks))
+	for i, check := range c.checks {
+		r := results[i]
+		report.Checks[check.Name] = r
+		switch {
+		case r.Status == StatusDown && check.Critical:
+			report.Status = StatusDown
+		case r.Status == StatusDown && report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	started := time.Now()
+	details, err := check.Run(ctx)
+	result := Result{
+		Status:    StatusOK,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+		Details:   details,
+	}
+	switch {
+	case errors.Is(err, ErrUnknown):
+		result.Status = StatusUnknown
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/catalogue"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+// KindSyncCatalogue imports the ABS dataflow catalogue into abs_static_dataflow
+const KindSyncCatalogue = "sync-catalogue"
+
+type CatalogueResult struct {
+	Dataflows int       `json:"dataflows"`
+	SyncedAt  time.Time `json:"syncedAt"`
+}
+
+func (r CatalogueResult) String() string {
+	return fmt.Sprintf("Catalogue has %d dataflows", r.Dataflows)
+}
+
+// SyncCatalogueKind takes no params, so only one sync is ever queued. The
+// ABS takes minutes to answer, the download is reported as it arrives.
+func SyncCatalogueKind(abs *fetch.Fetch, database *db.Database, dataflows *catalogue.Cache) Kind {
+	return Kind{
+		Name:     KindSyncCatalogue,
+		Attempts: 2,
+		Backoff:  time.Minute,
+		Run: Handle(func(ctx context.Context, _ struct{}, report func(Update)) (any, error) {
+			report(Update{Stage: fetch.StageDownloading, Message: "Downloading the dataflow catalogue"})
+			ctx = withProgress(ctx, func(u Update) {
+				// the catalogue comes from the structure API, but to people it's a download
+				u.Stage = fetch.StageDownloading
+				report(u)
+			})
+			if err := abs.ABSRestDataflowAll(ctx, database, ""); err != nil {
+				return nil, err
+			}
+			dataflows.Invalidate()
+
+			count, synced, err := database.CatalogueStatus(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return CatalogueResult{Dataflows: count, SyncedAt: synced}, nil
+		}),
+	}
+}
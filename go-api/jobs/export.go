@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/export"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/transform"
+)
+
+// KindExport writes observations to a file for download
+const KindExport = "export"
+
+// StageWriting follows downloading the data to export
+const StageWriting = "writing"
+
+type ExportParams struct {
+	Query      fetch.DataQuery `json:"query"`
+	Format     export.Format   `json:"format"`
+	Transforms []string        `json:"transforms,omitempty"`
+	Model      transform.Model `json:"model,omitempty"`
+	Period     int             `json:"period,omitempty"`
+	// Source overrides the configured data source, "" keeps it
+	Source source.Mode `json:"source,omitempty"`
+}
+
+type ExportResult struct {
+	// File is the name in the export directory, Filename the one to download as
+	File         string `json:"file"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"contentType"`
+	Size         int64  `json:"size"`
+	Observations int    `json:"observations"`
+	// where the observations were read from and when the ABS sent them
+	Source      string    `json:"source"`
+	RetrievedAt time.Time `json:"retrievedAt"`
+}
+
+func (r ExportResult) String() string {
+	return fmt.Sprintf("Exported %d observations to %s", r.Observations, r.Filename)
+}
+
+// ExportKind writes exports into dir, removing files older than maxAge as it
+// goes since their jobs have been pruned by then
+func ExportKind(data *source.Source, dir string, maxAge time.Duration) Kind {
+	return Kind{
+		Name:     KindExport,
+		Attempts: 3,
+		Backoff:  10 * time.Second,
+		Run: Handle(func(ctx context.Context, params ExportParams, report func(Update)) (any, error) {
+			ctx = withProgress(ctx, report)
+
+			report(Update{Stage: fetch.StageDownloading, Message: "Reading " + params.Query.DataflowID})
+			ds, err := data.Dataset(ctx, params.Query, params.Source)
+			if errors.Is(err, source.ErrNotStored) {
+				return nil, Permanent(err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			opts := transform.SeasonalOptions{Model: params.Model, Period: params.Period}
+			if err := transform.Apply(ds, params.Transforms, opts); err != nil {
+				return nil, Permanent(err)
+			}
+
+			report(Update{Stage: StageWriting, Message: fmt.Sprintf("Writing %d observations as %s", len(ds.Observations), params.Format)})
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, fmt.Errorf("creating export directory: %w", err)
+			}
+			removeExpired(dir, maxAge)
+			size, file, err := writeExport(dir, params.Format, ds)
+			if err != nil {
+				return nil, err
+			}
+			return ExportResult{
+				File:         file,
+				Filename:     export.Filename(ds, params.Format),
+				ContentType:  params.Format.ContentType(),
+				Size:         size,
+				Observations: len(ds.Observations),
+				Source:       ds.Source,
+				RetrievedAt:  ds.RetrievedAt,
+			}, nil
+		}),
+	}
+}
+
+func writeExport(dir string, format export.Format, ds *fetch.Dataset) (int64, string, error) {
+	f, err := os.CreateTemp(dir, "export-*."+string(format))
+	if err != nil {
+		return 0, "", fmt.Errorf("creating export file: %w", err)
+	}
+	err = export.Write(f, format, ds)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, "", fmt.Errorf("writing export: %w", err)
+	}
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return 0, "", err
+	}
+	return info.Size(), filepath.Base(f.Name()), nil
+}
+
+func removeExpired(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !strings.HasPrefix(entry.Name(), "export-") || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// OpenExport opens the file a finished export job wrote to dir
+func OpenExport(dir string, job Job) (*os.File, ExportResult, error) {
+	var result ExportResult
+	if err := json.Unmarshal(job.Result, &result); err != nil {
+		return nil, result, fmt.Errorf("decoding export result: %w", err)
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.Base(result.File)))
+	if err != nil {
+		return nil, result, err
+	}
+	return f, result, nil
+}
@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/transform"
+)
+
+// KindFetch downloads data from the ABS into abs_observations
+const KindFetch = "fetch"
+
+// StageStoring follows the fetch package's structure, downloading and parsing
+const StageStoring = "storing"
+
+// FetchResult counts what was stored. For an incremental fetch Observations
+// are those the ABS added or revised since the last one, Revised those of
+// them that replaced a stored value.
+type FetchResult struct {
+	DataflowID   string    `json:"dataflowid"`
+	Key          string    `json:"key"`
+	Incremental  bool      `json:"incremental,omitempty"`
+	Series       int       `json:"series"`
+	Observations int       `json:"observations"`
+	Revised      int       `json:"revised"`
+	RetrievedAt  time.Time `json:"retrievedAt"`
+}
+
+func (r FetchResult) String() string {
+	if r.Incremental {
+		return fmt.Sprintf("Stored %d new or changed observations in %d series, %d revised", r.Observations, r.Series, r.Revised)
+	}
+	return fmt.Sprintf("Stored %d observations in %d series", r.Observations, r.Series)
+}
+
+// FetchTitle names a fetch job for people
+func FetchTitle(query fetch.DataQuery) string {
+	title := "Download " + query.DataflowID
+	if query.Key != "" && query.Key != "all" {
+		title += " " + query.Key
+	}
+	return title
+}
+
+// FetchKind downloads a fetch.DataQuery from the ABS and stores the
+// observations. When a stored download already covers the query only what the
+// ABS has changed since is fetched. Otherwise the data structure is fetched
+// first to check the key before the long download.
+func FetchKind(abs *fetch.Fetch, data *source.Source) Kind {
+	return Kind{
+		Name:     KindFetch,
+		Attempts: 3,
+		Backoff:  30 * time.Second,
+		Run: Handle(func(ctx context.Context, query fetch.DataQuery, report func(Update)) (any, error) {
+			ctx = withProgress(ctx, report)
+
+			stored, err := data.Covering(ctx, query)
+			if err != nil {
+				return nil, err
+			}
+			if stored != nil {
+				report(Update{Stage: fetch.StageDownloading, Message: fmt.Sprintf("Downloading changes to %s since %s",
+					query.DataflowID, stored.RetrievedAt.UTC().Format("2 Jan 2006 15:04 MST"))})
+				refreshed, err := data.Refresh(ctx, *stored)
+				if err != nil {
+					return nil, err
+				}
+				return FetchResult{
+					DataflowID:   query.DataflowID,
+					Key:          refreshed.Key,
+					Incremental:  true,
+					Series:       refreshed.Series,
+					Observations: refreshed.Changed,
+					Revised:      len(refreshed.Revised),
+					RetrievedAt:  refreshed.RetrievedAt,
+				}, nil
+			}
+
+			report(Update{Stage: fetch.StageStructure, Message: "Fetching the data structure of " + query.DataflowID})
+			structure, err := abs.ABSRestDataStructure(ctx, query.DataflowID)
+			if err != nil {
+				return nil, err
+			}
+			if query.Key != "" && query.Key != "all" {
+				if parts := strings.Count(query.Key, ".") + 1; parts != len(structure.Dimensions) {
+					return nil, Permanent(fmt.Errorf("key %s has %d parts but %s has %d dimensions", query.Key, parts, query.DataflowID, len(structure.Dimensions)))
+				}
+			}
+
+			report(Update{Stage: fetch.StageDownloading, Message: "Downloading " + query.DataflowID})
+			ds, err := abs.ABSRestDataset(ctx, query)
+			if err != nil {
+				return nil, err
+			}
+
+			report(Update{Stage: StageStoring, Message: fmt.Sprintf("Storing %d observations", len(ds.Observations))})
+			if err := data.Store(ctx, query, ds); err != nil {
+				return nil, err
+			}
+
+			return FetchResult{
+				DataflowID:   query.DataflowID,
+				Key:          ds.Key,
+				Series:       len(transform.GroupSeries(ds.Observations)),
+				Observations: len(ds.Observations),
+				RetrievedAt:  ds.RetrievedAt,
+			}, nil
+		}),
+	}
+}
+
+// withProgress passes the fetch package's download progress on to the job
+func withProgress(ctx context.Context, report func(Update)) context.Context {
+	return fetch.WithProgress(ctx, func(p fetch.Progress) {
+		report(Update{Stage: p.Stage, Bytes: p.Bytes, Total: p.Total})
+	})
+}
@@ -0,0 +1,751 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Done reports whether the job has finished one way or another
+func (s State) Done() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+var (
+	ErrNotFound    = errors.New("job not found")
+	ErrFinished    = errors.New("job already finished")
+	ErrCancelled   = errors.New("job cancelled")
+	ErrUnknownKind = errors.New("unknown job kind")
+)
+
+// Job is a snapshot of a job, the Manager hands out copies
+type Job struct {
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind"`
+	Title  string          `json:"title"`
+	Params json.RawMessage `json:"params,omitempty"`
+	State  State           `json:"state"`
+	// Stage is the step the job is on, eg. downloading, and Message says
+	// more about it
+	Stage   string `json:"stage"`
+	Message string `json:"message,omitempty"`
+	// Bytes and Total are the download progress, Total is -1 when the size
+	// isn't known
+	Bytes    int64           `json:"bytes,omitempty"`
+	Total    int64           `json:"total,omitempty"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	// Summary is the result in a sentence, for results that are a fmt.Stringer
+	Summary   string    `json:"summary,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Percent is the download progress, -1 when it can't be told
+func (j Job) Percent() int {
+	if j.Total <= 0 {
+		return -1
+	}
+	return int(j.Bytes * 100 / j.Total)
+}
+
+// Downloaded is the progress for people, eg. "1.5 MB of 3.2 MB"
+func (j Job) Downloaded() string {
+	if j.Bytes == 0 {
+		return ""
+	}
+	if j.Total <= 0 {
+		return formatBytes(j.Bytes)
+	}
+	return formatBytes(j.Bytes) + " of " + formatBytes(j.Total)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.0f kB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Update is what a running job reports, empty fields keep their value
+type Update struct {
+	Stage   string
+	Message string
+	Bytes   int64
+	Total   int64
+}
+
+// RunFunc does the work of a job, calling report as it goes. It should stop
+// when ctx is cancelled.
+type RunFunc func(ctx context.Context, params json.RawMessage, report func(Update)) (result any, err error)
+
+// Kind is a type of job. Jobs are stored with their params as JSON rather
+// than as a closure so a queued job can run again after a restart.
+type Kind struct {
+	Name string
+	// Attempts is how many times a failing job is tried, waiting Backoff
+	// before the second try and doubling it after that
+	Attempts int
+	Backoff  time.Duration
+	Run      RunFunc
+}
+
+// Handle decodes a job's params into P before calling fn
+func Handle[P any](fn func(ctx context.Context, params P, report func(Update)) (any, error)) RunFunc {
+	return func(ctx context.Context, raw json.RawMessage, report func(Update)) (any, error) {
+		var params P
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, Permanent(fmt.Errorf("decoding job params: %w", err))
+		}
+		return fn(ctx, params, report)
+	}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that trying again won't fix
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// retryable errors are those that may go away, the ABS or the network
+// having a bad moment. The ABS's 4xx answers are about what was asked for.
+func retryable(err error) bool {
+	var permanent permanentError
+	var absErr *fetch.StatusError
+	switch {
+	case errors.As(err, &permanent):
+		return false
+	case errors.As(err, &absErr):
+		return absErr.Code >= 500 || absErr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// Store keeps jobs across restarts, *db.Database is one
+type Store interface {
+	InsertJob(ctx context.Context, job db.Job) error
+	UpdateJob(ctx context.Context, job db.Job) error
+	GetJob(ctx context.Context, id string) (db.Job, error)
+	ListJobs(ctx context.Context, filter db.JobFilter) ([]db.Job, error)
+	UnfinishedJobs(ctx context.Context) ([]db.Job, error)
+	DeleteJobsBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// storeTimeout bounds each write to the store, which also happens while
+// shutting down
+const storeTimeout = 5 * time.Second
+
+// pruneInterval is how often finished jobs past the retention are deleted
+const pruneInterval = time.Hour
+
+type job struct {
+	Job
+	dedupKey  string
+	cancel    context.CancelFunc
+	cancelled bool
+	// retry waits to put the job back on the queue
+	retry *time.Timer
+	// each subscriber gets the latest snapshot, older ones are dropped
+	subs map[chan Job]struct{}
+	// done is closed once the job finishes, err is why it failed
+	done chan struct{}
+	err  error
+}
+
+// failure is why a finished job failed, kept with its type so Wait returns
+// the same error however long after the job it's called
+type failure struct {
+	err        error
+	finishedAt time.Time
+}
+
+// Manager runs jobs on a pool of workers. Every job is stored so the list
+// survives restarts and unfinished jobs are run again when the Manager
+// starts. Submitting a job identical to a queued or running one returns
+// that job instead of doing the work twice.
+type Manager struct {
+	logger    *slog.Logger
+	store     Store
+	workers   int
+	retention time.Duration
+	kinds     map[string]Kind
+
+	mu sync.Mutex
+	// jobs holds the queued and running jobs, finished ones are only stored
+	jobs  map[string]*job
+	dedup map[string]string
+	queue []string
+	wake  chan struct{}
+	// failed holds the errors of failed jobs until they are pruned
+	failed map[string]failure
+}
+
+// New returns a Manager, register the kinds of job then call Resume and Run
+func New(store Store, workers int, retention time.Duration, logger *slog.Logger) *Manager {
+	return &Manager{
+		logger:    logger,
+		store:     store,
+		workers:   workers,
+		retention: retention,
+		kinds:     make(map[string]Kind),
+		jobs:      make(map[string]*job),
+		dedup:     make(map[string]string),
+		wake:      make(chan struct{}, 1),
+		failed:    make(map[string]failure),
+	}
+}
+
+func (m *Manager) Register(kind Kind) {
+	m.kinds[kind.Name] = kind
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Run works through the queue until ctx is done. Jobs still running then
+// are left queued for the next start.
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range m.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.work(ctx)
+		}()
+	}
+
+	m.prune(ctx)
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			m.prune(ctx)
+		}
+	}
+}
+
+// Resume queues the jobs left unfinished when the server last stopped, call
+// it before taking requests so an identical submit joins the resumed job
+func (m *Manager) Resume(ctx context.Context) error {
+	records, err := m.store.UnfinishedJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("loading unfinished jobs: %w", err)
+	}
+	for _, record := range records {
+		j := &job{Job: fromRecord(record), dedupKey: record.DedupKey, subs: make(map[chan Job]struct{}), done: make(chan struct{})}
+		if _, ok := m.kinds[j.Kind]; !ok {
+			m.finish(j, nil, fmt.Errorf("%w: %s", ErrUnknownKind, j.Kind))
+			continue
+		}
+		j.State, j.Stage, j.Message = StateQueued, string(StateQueued), "Resumed after a restart"
+		m.mu.Lock()
+		m.jobs[j.ID] = j
+		m.dedup[j.dedupKey] = j.ID
+		m.mu.Unlock()
+		m.save(j.Job, j.dedupKey, nil)
+		m.enqueue(j.ID)
+		m.logger.Info("Job resumed", "job", j.ID, "kind", j.Kind, "title", j.Title)
+	}
+	return nil
+}
+
+func (m *Manager) prune(ctx context.Context) {
+	cutoff := time.Now().Add(-m.retention)
+	m.mu.Lock()
+	for id, f := range m.failed {
+		if f.finishedAt.Before(cutoff) {
+			delete(m.failed, id)
+		}
+	}
+	m.mu.Unlock()
+
+	n, err := m.store.DeleteJobsBefore(ctx, cutoff)
+	if err != nil {
+		m.logger.Warn("Failed to prune jobs", "err", err)
+		return
+	}
+	if n > 0 {
+		m.logger.Info("Pruned finished jobs", "count", n)
+	}
+}
+
+// Submit queues a job of kind with params, the job's JSON arguments. When an
+// identical job is already queued or running that one is returned instead.
+func (m *Manager) Submit(ctx context.Context, kind, title string, params any) (Job, error) {
+	if _, ok := m.kinds[kind]; !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, fmt.Errorf("encoding job params: %w", err)
+	}
+	dedupKey := kind + " " + string(raw)
+
+	now := time.Now()
+	j := &job{
+		Job: Job{
+			ID:        newJobID(),
+			Kind:      kind,
+			Title:     title,
+			Params:    raw,
+			State:     StateQueued,
+			Stage:     string(StateQueued),
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		dedupKey: dedupKey,
+		subs:     make(map[chan Job]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	if id, ok := m.dedup[dedupKey]; ok {
+		existing := m.jobs[id].Job
+		m.mu.Unlock()
+		m.logger.Info("Job already queued", "job", id, "kind", kind, "title", title)
+		return existing, nil
+	}
+	// claim the key before the insert so an identical submit waits for us
+	m.dedup[dedupKey] = j.ID
+	m.jobs[j.ID] = j
+	m.mu.Unlock()
+
+	if err := m.store.InsertJob(ctx, toRecord(j.Job, dedupKey, nil)); err != nil {
+		m.mu.Lock()
+		delete(m.dedup, dedupKey)
+		delete(m.jobs, j.ID)
+		m.mu.Unlock()
+		return Job{}, err
+	}
+	m.logger.Info("Job queued", "job", j.ID, "kind", kind, "title", title)
+	// a worker may have it the moment it's queued
+	snapshot := j.Job
+	m.enqueue(j.ID)
+	return snapshot, nil
+}
+
+func (m *Manager) enqueue(id string) {
+	m.mu.Lock()
+	m.queue = append(m.queue, id)
+	m.mu.Unlock()
+	m.signal()
+}
+
+// signal wakes a worker, one that is busy picks the job up when it's done
+func (m *Manager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) next() (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.queue) > 0 {
+		id := m.queue[0]
+		m.queue = m.queue[1:]
+		if j, ok := m.jobs[id]; ok && j.State == StateQueued {
+			if len(m.queue) > 0 {
+				m.signal()
+			}
+			return j, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Manager) work(ctx context.Context) {
+	for {
+		j, ok := m.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.wake:
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		m.run(ctx, j)
+	}
+}
+
+func (m *Manager) run(ctx context.Context, j *job) {
+	kind := m.kinds[j.Kind]
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	// a job cancelled after a worker took it off the queue is finished already
+	if j.State != StateQueued || j.cancelled {
+		m.mu.Unlock()
+		return
+	}
+	j.cancel = cancel
+	m.mu.Unlock()
+	m.update(j, func(s *Job) bool {
+		s.State, s.Stage, s.Message = StateRunning, string(StateRunning), ""
+		s.Attempts++
+		return true
+	})
+
+	started := time.Now()
+	result, err := kind.Run(ctx, j.Params, func(u Update) {
+		m.update(j, func(s *Job) bool {
+			changed := false
+			if u.Stage != "" && u.Stage != s.Stage {
+				// a new stage starts its own progress
+				s.Stage, s.Message, s.Bytes, s.Total = u.Stage, "", 0, 0
+				changed = true
+			}
+			if u.Message != "" && u.Message != s.Message {
+				s.Message = u.Message
+				changed = true
+			}
+			if u.Bytes != 0 || u.Total != 0 {
+				s.Bytes, s.Total = u.Bytes, u.Total
+			}
+			return changed
+		})
+	})
+
+	m.mu.Lock()
+	cancelled, attempts := j.cancelled, j.Attempts
+	j.cancel = nil
+	m.mu.Unlock()
+	switch {
+	case cancelled:
+		err = ErrCancelled
+	case err != nil && ctx.Err() != nil:
+		// shutting down, the job runs again on the next start
+		m.update(j, func(s *Job) bool {
+			s.State, s.Stage, s.Message, s.Bytes, s.Total = StateQueued, string(StateQueued), "Interrupted by a restart", 0, 0
+			return true
+		})
+		m.logger.Info("Job interrupted", "job", j.ID, "kind", j.Kind)
+		return
+	case err != nil && attempts < kind.Attempts && retryable(err):
+		wait := kind.Backoff << (attempts - 1)
+		m.update(j, func(s *Job) bool {
+			s.State, s.Stage, s.Bytes, s.Total = StateQueued, string(StateQueued), 0, 0
+			s.Message = fmt.Sprintf("Attempt %d of %d failed, retrying in %s: %s", attempts, kind.Attempts, wait, err)
+			return true
+		})
+		m.mu.Lock()
+		j.retry = time.AfterFunc(wait, func() { m.enqueue(j.ID) })
+		m.mu.Unlock()
+		m.logger.Warn("Job failed, retrying", "job", j.ID, "kind", j.Kind, "attempt", attempts, "wait", wait, "err", err)
+		return
+	}
+	final := m.finish(j, result, err)
+	m.logger.Info("Job finished", "job", final.ID, "kind", final.Kind, "state", final.State, "attempts", final.Attempts, "duration", time.Since(started), "err", err)
+}
+
+// finish records the outcome, stores it and closes the subscriptions
+func (m *Manager) finish(j *job, result any, err error) Job {
+	var raw json.RawMessage
+	if err == nil && result != nil {
+		if raw, err = json.Marshal(result); err != nil {
+			err = fmt.Errorf("encoding job result: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	switch {
+	case errors.Is(err, ErrCancelled):
+		j.State = StateCancelled
+	case err != nil:
+		j.State, j.Error = StateFailed, err.Error()
+	default:
+		j.State, j.Result = StateSucceeded, raw
+		if summary, ok := result.(fmt.Stringer); ok {
+			j.Summary = summary.String()
+		}
+	}
+	j.Stage, j.Message, j.Bytes, j.Total = string(j.State), "", 0, 0
+	j.UpdatedAt = time.Now()
+	j.err = err
+	if j.State == StateFailed {
+		m.failed[j.ID] = failure{err: err, finishedAt: j.UpdatedAt}
+	}
+	for ch := range j.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- j.Job
+		close(ch)
+	}
+	j.subs = nil
+	delete(m.jobs, j.ID)
+	if m.dedup[j.dedupKey] == j.ID {
+		delete(m.dedup, j.dedupKey)
+	}
+	close(j.done)
+	final := j.Job
+	m.mu.Unlock()
+
+	finished := final.UpdatedAt
+	m.save(final, j.dedupKey, &finished)
+	return final
+}
+
+// update changes the job and sends the new snapshot to its subscribers. The
+// store is only written when fn says something worth keeping changed, so
+// byte counts don't cost a query each. A finished job is left alone, progress
+// can still arrive from work it started.
+func (m *Manager) update(j *job, fn func(*Job) bool) {
+	m.mu.Lock()
+	if j.State.Done() {
+		m.mu.Unlock()
+		return
+	}
+	persist := fn(&j.Job)
+	j.UpdatedAt = time.Now()
+	for ch := range j.subs {
+		// replace a snapshot the subscriber hasn't read yet, only the newest matters
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- j.Job
+	}
+	snapshot := j.Job
+	m.mu.Unlock()
+	if persist {
+		m.save(snapshot, j.dedupKey, nil)
+	}
+}
+
+// save writes the job to the store, failing to is logged but doesn't stop
+// the job
+func (m *Manager) save(snapshot Job, dedupKey string, finished *time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := m.store.UpdateJob(ctx, toRecord(snapshot, dedupKey, finished)); err != nil {
+		m.logger.Warn("Failed to save job", "job", snapshot.ID, "state", snapshot.State, "err", err)
+	}
+}
+
+// Get returns a job, queued and running ones with their latest progress
+func (m *Manager) Get(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if ok {
+		defer m.mu.Unlock()
+		return j.Job, nil
+	}
+	m.mu.Unlock()
+
+	record, err := m.store.GetJob(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	return fromRecord(record), nil
+}
+
+// Filter narrows List, empty fields match everything
+type Filter struct {
+	Kind  string
+	State State
+	Limit int
+}
+
+// List returns the stored jobs newest first
+func (m *Manager) List(ctx context.Context, filter Filter) ([]Job, error) {
+	records, err := m.store.ListJobs(ctx, db.JobFilter{Kind: filter.Kind, State: string(filter.State), Limit: filter.Limit})
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Job, len(records))
+	for i, record := range records {
+		// the running ones have progress that isn't stored
+		if j, ok := m.jobs[record.ID]; ok {
+			list[i] = j.Job
+			continue
+		}
+		list[i] = fromRecord(record)
+	}
+	return list, nil
+}
+
+// Cancel stops a queued or running job. A running job is cancelled once its
+// work has stopped.
+func (m *Manager) Cancel(ctx context.Context, id string) error {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		job, err := m.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrFinished, job.State)
+	}
+	j.cancelled = true
+	if j.cancel != nil {
+		j.cancel()
+		m.mu.Unlock()
+		return nil
+	}
+	// queued, or waiting to retry, so nothing else will finish it. The state
+	// changes before unlocking so a worker that already took it won't run it.
+	if j.retry != nil {
+		j.retry.Stop()
+	}
+	j.State = StateCancelled
+	m.queue = slices.DeleteFunc(m.queue, func(queued string) bool { return queued == id })
+	m.mu.Unlock()
+	m.finish(j, nil, ErrCancelled)
+	m.logger.Info("Job cancelled", "job", id, "kind", j.Kind)
+	return nil
+}
+
+// Wait blocks until the job finishes and returns it with the error it failed
+// with, which keeps its type when the job ran in this process
+func (m *Manager) Wait(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		job, err := m.Get(ctx, id)
+		if err != nil {
+			return Job{}, err
+		}
+		switch job.State {
+		case StateFailed:
+			m.mu.Lock()
+			f, ok := m.failed[id]
+			m.mu.Unlock()
+			if ok {
+				return job, f.err
+			}
+			// failed before a restart, only the message was kept
+			return job, errors.New(job.Error)
+		case StateCancelled:
+			return job, ErrCancelled
+		}
+		return job, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	case <-j.done:
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return j.Job, j.err
+}
+
+// Subscribe returns the job now and a channel of its later snapshots, which
+// is closed once the job finishes. Call stop when no longer reading.
+func (m *Manager) Subscribe(ctx context.Context, id string) (Job, <-chan Job, func(), error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		job, err := m.Get(ctx, id)
+		if err != nil {
+			return Job{}, nil, nil, err
+		}
+		ch := make(chan Job)
+		close(ch)
+		return job, ch, func() {}, nil
+	}
+	defer m.mu.Unlock()
+	ch := make(chan Job, 1)
+	j.subs[ch] = struct{}{}
+	stop := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := j.subs[ch]; ok {
+			delete(j.subs, ch)
+			close(ch)
+		}
+	}
+	return j.Job, ch, stop, nil
+}
+
+func toRecord(j Job, dedupKey string, finished *time.Time) db.Job {
+	return db.Job{
+		ID:         j.ID,
+		Kind:       j.Kind,
+		Title:      j.Title,
+		DedupKey:   dedupKey,
+		Params:     j.Params,
+		State:      string(j.State),
+		Stage:      j.Stage,
+		Message:    j.Message,
+		Error:      j.Error,
+		Result:     j.Result,
+		Summary:    j.Summary,
+		Attempts:   j.Attempts,
+		FinishedAt: finished,
+		CreatedAt:  j.CreatedAt,
+		UpdatedAt:  j.UpdatedAt,
+	}
+}
+
+func fromRecord(r db.Job) Job {
+	return Job{
+		ID:        r.ID,
+		Kind:      r.Kind,
+		Title:     r.Title,
+		Params:    r.Params,
+		State:     State(r.State),
+		Stage:     r.Stage,
+		Message:   r.Message,
+		Error:     r.Error,
+		Result:    r.Result,
+		Summary:   r.Summary,
+		Attempts:  r.Attempts,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
@@ -0,0 +1,248 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/transform"
+)
+
+// memStore is a Store in memory
+type memStore struct {
+	mu   sync.Mutex
+	jobs map[string]db.Job
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: make(map[string]db.Job)}
+}
+
+func (s *memStore) InsertJob(ctx context.Context, job db.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *memStore) UpdateJob(ctx context.Context, job db.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; !ok {
+		return db.ErrNotFound
+	}
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *memStore) GetJob(ctx context.Context, id string) (db.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return db.Job{}, db.ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *memStore) ListJobs(ctx context.Context, filter db.JobFilter) ([]db.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := []db.Job{}
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *memStore) UnfinishedJobs(ctx context.Context) ([]db.Job, error) {
+	return nil, nil
+}
+
+func (s *memStore) DeleteJobsBefore(ctx context.Context, t time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(t) {
+			delete(s.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestWaitKeepsTheErrorType(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantState State
+		check     func(error) bool
+	}{
+		{
+			name:      "transform error",
+			err:       &transform.Error{Transform: "seasonal", Err: errors.New("series too short")},
+			wantState: StateFailed,
+			check: func(err error) bool {
+				var transformErr *transform.Error
+				return errors.As(err, &transformErr) && transformErr.Transform == "seasonal"
+			},
+		},
+		{
+			name:      "permanent error",
+			err:       Permanent(errors.New("bad params")),
+			wantState: StateFailed,
+			check:     func(err error) bool { return err != nil && err.Error() == "bad params" },
+		},
+		{
+			name:      "success",
+			wantState: StateSucceeded,
+			check:     func(err error) bool { return err == nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m := New(newMemStore(), 1, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			m.Register(Kind{Name: "test", Attempts: 1, Run: func(ctx context.Context, params json.RawMessage, report func(Update)) (any, error) {
+				return nil, tt.err
+			}})
+			go m.Run(ctx)
+
+			job, err := m.Submit(ctx, "test", "test", map[string]string{"case": tt.name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the first wait may catch the job running, the second always
+			// finds it finished
+			for i := range 2 {
+				got, err := m.Wait(ctx, job.ID)
+				if got.State != tt.wantState {
+					t.Errorf("wait %d: state %s, want %s", i, got.State, tt.wantState)
+				}
+				if !tt.check(err) {
+					t.Errorf("wait %d: unexpected error %#v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneForgetsFailures(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	m := New(store, 1, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.Register(Kind{Name: "test", Attempts: 1, Run: func(ctx context.Context, params json.RawMessage, report func(Update)) (any, error) {
+		return nil, errors.New("failed")
+	}})
+	job, err := m.Submit(ctx, "test", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, _ := m.next()
+	m.run(ctx, j)
+	if _, ok := m.failed[job.ID]; !ok {
+		t.Fatalf("failed job's error not kept")
+	}
+
+	m.retention = 0
+	m.prune(ctx)
+	if _, ok := m.failed[job.ID]; ok {
+		t.Errorf("failed job's error kept after it was pruned")
+	}
+	if _, err := m.Wait(ctx, job.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wait on a pruned job: %v, want ErrNotFound", err)
+	}
+}
+
+// TestRunSkipsCancelledJob is the worker getting in between Cancel marking
+// a queued job and finishing it
+func TestRunSkipsCancelledJob(t *testing.T) {
+	ctx := context.Background()
+	m := New(newMemStore(), 1, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ran := false
+	m.Register(Kind{Name: "test", Attempts: 1, Run: func(ctx context.Context, params json.RawMessage, report func(Update)) (any, error) {
+		ran = true
+		return nil, nil
+	}})
+	if _, err := m.Submit(ctx, "test", "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	j, _ := m.next()
+	m.mu.Lock()
+	j.cancelled = true
+	m.mu.Unlock()
+
+	m.run(ctx, j)
+	if ran {
+		t.Errorf("job marked cancelled ran")
+	}
+	// Cancel goes on to finish it, which must be the only finish
+	m.finish(j, nil, ErrCancelled)
+}
+
+func TestCancelAfterWorkerTookTheJob(t *testing.T) {
+	ctx := context.Background()
+	m := New(newMemStore(), 1, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ran := false
+	m.Register(Kind{Name: "test", Attempts: 1, Run: func(ctx context.Context, params json.RawMessage, report func(Update)) (any, error) {
+		ran = true
+		return nil, nil
+	}})
+	job, err := m.Submit(ctx, "test", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a worker has taken the job but not started it when the cancel comes in
+	j, ok := m.next()
+	if !ok {
+		t.Fatal("job not queued")
+	}
+	if err := m.Cancel(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	m.run(ctx, j)
+	if ran {
+		t.Errorf("cancelled job ran")
+	}
+	got, err := m.Wait(ctx, job.ID)
+	if got.State != StateCancelled || !errors.Is(err, ErrCancelled) {
+		t.Errorf("job %s with %v, want cancelled", got.State, err)
+	}
+}
+
+func TestUpdateLeavesFinishedJobs(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	m := New(store, 1, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var report func(Update)
+	m.Register(Kind{Name: "test", Attempts: 1, Run: func(ctx context.Context, params json.RawMessage, r func(Update)) (any, error) {
+		report = r
+		return nil, nil
+	}})
+	job, err := m.Submit(ctx, "test", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, _ := m.next()
+	m.run(ctx, j)
+
+	// progress from a download the job shared with others, arriving late
+	report(Update{Stage: "downloading", Message: "still going"})
+
+	record, err := store.GetJob(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.State != string(StateSucceeded) || record.FinishedAt == nil || record.Message != "" {
+		t.Errorf("stored job %s finished %v message %q, want it left succeeded", record.State, record.FinishedAt, record.Message)
+	}
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+)
+
+// Subsystem logger names, the same keys as logging_config.loggers so the Go
+// and Python sides are configured in one place
+const (
+	Server     = "server"
+	Fetch      = "fetch"
+	DB         = "db"
+	Handlers   = "handlers"
+	Supervisor = "supervisor"
+	Jobs       = "jobs"
+)
+
+// Loggers hands out a logger per subsystem, each at its configured level
+type Loggers struct {
+	w      io.Writer
+	json   bool
+	level  slog.Level
+	levels map[string]slog.Level
+}
+
+func New(cfg config.LoggingConfig, w io.Writer) (*Loggers, error) {
+	l := &Loggers{w: w, level: slog.LevelInfo, levels: make(map[string]slog.Level)}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+	case "json":
+		l.json = true
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", cfg.Format)
+	}
+
+	if cfg.Level != "" {
+		level, err := ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, err
+		}
+		l.level = level
+	}
+
+	for name, raw := range cfg.Loggers {
+		entry, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		s, ok := entry["level"].(string)
+		if !ok {
+			continue
+		}
+		level, err := ParseLevel(s)
+		if err != nil {
+			return nil, fmt.Errorf("logger %s: %w", name, err)
+		}
+		l.levels[name] = level
+	}
+	return l, nil
+}
+
+// Logger returns the logger for a subsystem, unknown names get the default level
+func (l *Loggers) Logger(name string) *slog.Logger {
+	level, ok := l.levels[name]
+	if !ok {
+		level = l.level
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	if l.json {
+		h = slog.NewJSONHandler(l.w, opts)
+	} else {
+		h = slog.NewTextHandler(l.w, opts)
+	}
+	return slog.New(contextHandler{h}).With("logger", name)
+}
+
+// ParseLevel accepts Python's level names as well as slog's
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return slog.LevelDebug, nil
+	case "INFO":
+		return slog.LevelInfo, nil
+	case "WARN", "WARNING":
+		return slog.LevelWarn, nil
+	case "ERROR", "CRITICAL":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level: %s", s)
+}
+
+// contextHandler adds the request id to records logged with a request context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := middleware.RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -5,12 +5,12 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/VooDooM1234/abs-visualiser/go-api/server"
+	"github.com/VooDooM1234/abs-visualiser/go-api/cli"
 )
 
 func main() {
 	ctx := context.Background()
-	if err := server.Run(ctx, os.Stdout, os.Args); err != nil {
+	if err := cli.Run(ctx, os.Stdout, os.Stderr, os.Args); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+var requestDuration = NewHistogramVec(
+	"http_request_duration_seconds",
+	"Latency of HTTP requests by route pattern, method and status code.",
+	nil, "route", "method", "code",
+)
+
+// ObserveRequest records one served request. route is the ServeMux pattern
+// that matched, "" for requests nothing matched.
+func ObserveRequest(route, method string, code int, started time.Time) {
+	if route == "" {
+		route = "unmatched"
+	}
+	requestDuration.With(route, method, strconv.Itoa(code)).Observe(time.Since(started).Seconds())
+}
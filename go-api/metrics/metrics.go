@@ -0,0 +1,346 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets suit request latencies in seconds, from 5ms to a minute
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// collector is one metric family
+type collector interface {
+	describe() (name, help, kind string)
+	write(w *bufio.Writer, name string)
+}
+
+// Registry holds metric families and writes them in the Prometheus text format
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// Default is the registry the package level constructors register with
+var Default = NewRegistry()
+
+func (reg *Registry) register(c collector) {
+	name, _, _ := c.describe()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.collectors[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	reg.collectors[name] = c
+}
+
+// WriteTo writes every metric, sorted by name
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	names := make([]string, 0, len(reg.collectors))
+	for name := range reg.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, reg.collectors[name])
+	}
+	reg.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, kind)
+		c.write(bw, name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// vec keeps one child per combination of label values
+type vec[T any] struct {
+	labels []string
+	newFn  func() *T
+
+	mu       sync.Mutex
+	children map[string]*child[T]
+}
+
+type child[T any] struct {
+	values []string
+	metric *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	c := &child[T]{values: append([]string(nil), values...), metric: v.newFn()}
+	v.children[key] = c
+	return c.metric
+}
+
+// sorted returns the children in a stable order for output
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*child[T], len(keys))
+	for i, k := range keys {
+		out[i] = v.children[k]
+	}
+	return out
+}
+
+// Counter only goes up
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+type CounterVec struct {
+	name, help string
+	vec[Counter]
+}
+
+// NewCounterVec registers a counter family with Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help}
+	c.labels = labels
+	c.newFn = func() *Counter { return &Counter{} }
+	c.children = make(map[string]*child[Counter])
+	Default.register(c)
+	return c
+}
+
+func (c *CounterVec) With(values ...string) *Counter { return c.with(values) }
+
+func (c *CounterVec) describe() (string, string, string) { return c.name, c.help, "counter" }
+
+func (c *CounterVec) write(w *bufio.Writer, name string) {
+	for _, ch := range c.sorted() {
+		writeSample(w, name, c.labels, ch.values, "", "", ch.metric.Value())
+	}
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+type GaugeVec struct {
+	name, help string
+	vec[Gauge]
+}
+
+// NewGaugeVec registers a gauge family with Default
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help}
+	g.labels = labels
+	g.newFn = func() *Gauge { return &Gauge{} }
+	g.children = make(map[string]*child[Gauge])
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge { return g.with(values) }
+
+func (g *GaugeVec) describe() (string, string, string) { return g.name, g.help, "gauge" }
+
+func (g *GaugeVec) write(w *bufio.Writer, name string) {
+	for _, ch := range g.sorted() {
+		writeSample(w, name, g.labels, ch.values, "", "", ch.metric.Value())
+	}
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	addFloat(&h.sumBits, v)
+}
+
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+	vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram family with Default, nil buckets
+// means DefaultBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{name: name, help: help, buckets: buckets}
+	h.labels = labels
+	h.newFn = func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets))}
+	}
+	h.children = make(map[string]*child[Histogram])
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram { return h.with(values) }
+
+func (h *HistogramVec) describe() (string, string, string) { return h.name, h.help, "histogram" }
+
+func (h *HistogramVec) write(w *bufio.Writer, name string) {
+	for _, ch := range h.sorted() {
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += ch.metric.counts[i].Load()
+			writeSample(w, name+"_bucket", h.labels, ch.values, "le", formatFloat(le), float64(cumulative))
+		}
+		count := ch.metric.count.Load()
+		writeSample(w, name+"_bucket", h.labels, ch.values, "le", "+Inf", float64(count))
+		writeSample(w, name+"_sum", h.labels, ch.values, "", "", math.Float64frombits(ch.metric.sumBits.Load()))
+		writeSample(w, name+"_count", h.labels, ch.values, "", "", float64(count))
+	}
+}
+
+// funcCollector reads its value when scraped, for numbers another package
+// already keeps eg. cache stats or pool sizes
+type funcCollector struct {
+	name, help, kind string
+	fn               func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is fn() at scrape time
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(&funcCollector{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is fn() at scrape time, fn
+// must never go down
+func NewCounterFunc(name, help string, fn func() float64) {
+	Default.register(&funcCollector{name: name, help: help, kind: "counter", fn: fn})
+}
+
+func (f *funcCollector) describe() (string, string, string) { return f.name, f.help, f.kind }
+
+func (f *funcCollector) write(w *bufio.Writer, name string) {
+	writeSample(w, name, nil, nil, "", "", f.fn())
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraLabel, escapeLabel(extraValue))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	upstreamDuration = NewHistogramVec(
+		"upstream_request_duration_seconds",
+		"Latency of calls to the ABS API, the plot service and Postgres.",
+		nil, "upstream", "operation",
+	)
+	upstreamErrors = NewCounterVec(
+		"upstream_errors_total",
+		"Failed calls to the ABS API, the plot service and Postgres.",
+		"upstream", "operation",
+	)
+)
+
+// ObserveUpstream records one call to a dependency, err marks it failed
+func ObserveUpstream(upstream, operation string, started time.Time, err error) {
+	upstreamDuration.With(upstream, operation).Observe(time.Since(started).Seconds())
+	if err != nil {
+		upstreamErrors.With(upstream, operation).Inc()
+	}
+}
+
+// Transport records every round trip as a call to Upstream, the operation is
+// the first path segment so /plot/line/CPI is "plot"
+type Transport struct {
+	Upstream string
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	started := time.Now()
+	resp, err := base.RoundTrip(req)
+
+	observed := err
+	if err == nil && resp.StatusCode >= 500 {
+		observed = &statusError{resp.StatusCode}
+	}
+	ObserveUpstream(t.Upstream, PathOperation(req.URL.Path, 1), started, observed)
+	return resp, err
+}
+
+// PathOperation keeps the first n segments of a path for use as a label, ids
+// further along would give every dataflow its own series
+func PathOperation(path string, n int) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > n {
+		parts = parts[:n]
+	}
+	op := strings.Join(parts, "/")
+	if op == "" {
+		return "/"
+	}
+	return op
+}
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string { return "status " + strconv.Itoa(e.code) }
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGzip(t *testing.T) {
+	body := strings.Repeat(`{"period":"2024-Q1","value":1.5},`, 100)
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		contentType    string
+		status         int
+		wantGzip       bool
+	}{
+		{name: "json for a gzip client", acceptEncoding: "gzip, deflate", contentType: "application/json", wantGzip: true},
+		{name: "html with quality", acceptEncoding: "br;q=1.0, gzip;q=0.8", contentType: "text/html", wantGzip: true},
+		{name: "no Accept-Encoding", contentType: "application/json"},
+		{name: "other encodings only", acceptEncoding: "br, deflate", contentType: "application/json"},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0", contentType: "application/json"},
+		{name: "binary content", acceptEncoding: "gzip", contentType: "application/vnd.apache.parquet"},
+		{name: "event stream", acceptEncoding: "gzip", contentType: "text/event-stream"},
+		{name: "head request", method: http.MethodHead, acceptEncoding: "gzip", contentType: "application/json"},
+		{name: "not modified", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			h := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.WriteHeader(status)
+				if status != http.StatusNotModified {
+					io.WriteString(w, body)
+				}
+			}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary %q, want Accept-Encoding", got)
+			}
+			if !tt.wantGzip {
+				if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+					t.Fatalf("Content-Encoding %q, want none", enc)
+				}
+				if rec.Header().Get("Content-Length") == "" {
+					t.Errorf("Content-Length dropped from an uncompressed response")
+				}
+				if method == http.MethodGet && status == http.StatusOK && rec.Body.String() != body {
+					t.Errorf("uncompressed body changed")
+				}
+				return
+			}
+
+			if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+				t.Fatalf("Content-Encoding %q, want gzip", enc)
+			}
+			if cl := rec.Header().Get("Content-Length"); cl != "" {
+				t.Errorf("Content-Length %s kept on a compressed response", cl)
+			}
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("reading gzip: %v", err)
+			}
+			decoded, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("decoding gzip: %v", err)
+			}
+			if string(decoded) != body {
+				t.Errorf("decoded body differs from the original")
+			}
+		})
+	}
+}
+
+func TestGzipSniffsContentType(t *testing.T) {
+	h := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html><body>"+strings.Repeat("x", 500)+"</body></html>")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Content-Type %q, want it sniffed as text/html", rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("sniffed html not compressed")
+	}
+}
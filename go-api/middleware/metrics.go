@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+)
+
+// Metrics records a latency histogram per route. It must wrap the ServeMux
+// with no middleware in between that replaces the request, r.Pattern is only
+// filled in on the request the mux was given.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			metrics.ObserveRequest(r.Pattern, r.Method, status, started)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panicking handler, eg. a template.Must on a broken template,
+// into a 500 for that request instead of a dropped connection
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &responseRecorder{ResponseWriter: w}
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// the server uses this to abort a response on purpose
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				logger.ErrorContext(r.Context(), "Panic serving request",
+					"method", r.Method, "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+				if rec.status == 0 {
+					internalError(w, r)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// internalError writes the same envelope as utils.EncodeError, which can't be
+// used here as utils imports this package
+func internalError(w http.ResponseWriter, r *http.Request) {
+	type errorBody struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId,omitempty"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(struct {
+		Error errorBody `json:"error"`
+	}{errorBody{Code: "internal", Message: "Internal server error", RequestID: RequestIDFromContext(r.Context())}})
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		// want is the id expected, "" for a generated one
+		want string
+	}{
+		{name: "kept", incoming: "abc-123.DEF_4", want: "abc-123.DEF_4"},
+		{name: "missing", incoming: ""},
+		{name: "invalid characters", incoming: "bad id<script>"},
+		{name: "too long", incoming: strings.Repeat("a", 65)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if echoed != seen {
+				t.Errorf("response header %q, context %q, want them equal", echoed, seen)
+			}
+			switch {
+			case tt.want != "" && seen != tt.want:
+				t.Errorf("id %q, want %q", seen, tt.want)
+			case tt.want == "" && (seen == tt.incoming || !validRequestID.MatchString(seen)):
+				t.Errorf("id %q, want a newly generated one", seen)
+			}
+		})
+	}
+}
+
+func TestRequestIDGeneratedIDsDiffer(t *testing.T) {
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ids := make(map[string]bool)
+	for range 10 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		ids[rec.Header().Get(RequestIDHeader)] = true
+	}
+	if len(ids) != 10 {
+		t.Errorf("10 requests got %d distinct ids", len(ids))
+	}
+}
+
+func TestTransportForwardsRequestID(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctxID   string
+		header  string
+		wantHdr string
+	}{
+		{name: "from context", ctxID: "req-1", wantHdr: "req-1"},
+		{name: "no id", wantHdr: ""},
+		{name: "caller's header wins", ctxID: "req-1", header: "own", wantHdr: "own"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get(RequestIDHeader)
+			}))
+			defer srv.Close()
+
+			req := httptest.NewRequest(http.MethodGet, srv.URL, nil)
+			req.RequestURI = ""
+			req = req.WithContext(WithRequestID(req.Context(), tt.ctxID))
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got != tt.wantHdr {
+				t.Errorf("upstream saw %q, want %q", got, tt.wantHdr)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		wantStatus int
+		wantBody   string
+		// wantCancelled is whether the handler should see its context cancelled
+		wantCancelled bool
+	}{
+		{name: "fast handler", delay: 0, wantStatus: http.StatusCreated, wantBody: "done"},
+		{name: "slow handler", delay: time.Second, wantStatus: http.StatusGatewayTimeout, wantBody: "timed out", wantCancelled: true},
+	}
+	timedOut := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte("timed out"))
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := make(chan bool, 1)
+			h := Timeout(50*time.Millisecond, timedOut)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Handler", "yes")
+				select {
+				case <-time.After(tt.delay):
+					cancelled <- false
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte("done"))
+				case <-r.Context().Done():
+					cancelled <- true
+					// too late, this mustn't reach the client
+					w.Write([]byte("late"))
+				}
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := <-cancelled; got != tt.wantCancelled {
+				t.Errorf("handler context cancelled %v, want %v", got, tt.wantCancelled)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			// headers set before timing out belong to the abandoned response
+			if got, want := rec.Header().Get("X-Handler") == "yes", !tt.wantCancelled; got != want {
+				t.Errorf("handler header copied %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	h := Timeout(time.Second, http.NotFoundHandler())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recovered %v, want the handler's panic", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,218 @@
+package plotservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/flight"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+)
+
+// DefaultTimeout covers the slowest endpoints, the dataflow list and data
+// requests go through sdmx to the ABS and can take minutes
+const DefaultTimeout = 2 * time.Minute
+
+// ErrUnavailable wraps failures to reach the plot service at all
+var ErrUnavailable = errors.New("plot service unavailable")
+
+// Error is a failure reported by the plot service, either an error status or
+// the older 200 with {"status": "failed"}
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plot service error %d: %s", e.Status, e.Message)
+}
+
+type Dataflow struct {
+	DataflowID   string `json:"dataflowid"`
+	DataflowName string `json:"dataflowname"`
+}
+
+// Record is one row of a pandas DataFrame, the columns depend on the dataflow
+type Record map[string]any
+
+// Client talks to the Python plot service, one method per endpoint.
+// Concurrent identical data and plot requests share one call.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	Logger  *slog.Logger
+
+	data  flight.Group[[]Record]
+	plots flight.Group[[]byte]
+}
+
+func New(host string, port int, logger *slog.Logger) *Client {
+	c := &Client{
+		BaseURL: "http://" + net.JoinHostPort(host, strconv.Itoa(port)),
+		HTTP: &http.Client{
+			Timeout: DefaultTimeout,
+			// forwards the request id and records upstream metrics
+			Transport: &middleware.Transport{Base: &metrics.Transport{Upstream: "plotservice"}},
+		},
+		Logger: logger,
+	}
+	c.data.Upstream = "plotservice"
+	c.plots.Upstream = "plotservice"
+	return c
+}
+
+// Dataflows is GET /request-dataflow/ABS/
+func (c *Client) Dataflows(ctx context.Context) ([]Dataflow, error) {
+	var dataflows []Dataflow
+	err := c.doJSON(ctx, http.MethodGet, "/request-dataflow/ABS/", nil, &dataflows)
+	return dataflows, err
+}
+
+// Data is POST /request-data/ABS/, the records are shared with concurrent
+// callers for the same dataflow so must not be changed
+func (c *Client) Data(ctx context.Context, dataflowID string) ([]Record, error) {
+	records, _, err := c.data.Do(ctx, dataflowID, func(ctx context.Context) ([]Record, error) {
+		var records []Record
+		err := c.doJSON(ctx, http.MethodPost, "/request-data/ABS/", map[string]string{"dataflowid": dataflowID}, &records)
+		return records, err
+	})
+	return records, err
+}
+
+// Codelists is POST /request-codelist/ABS/
+func (c *Client) Codelists(ctx context.Context, dataflowID string) ([]Record, error) {
+	var records []Record
+	err := c.doJSON(ctx, http.MethodPost, "/request-codelist/ABS/", map[string]string{"dataflowid": dataflowID}, &records)
+	return records, err
+}
+
+// ValidGraphs is GET /metadata/valid-graphs
+func (c *Client) ValidGraphs(ctx context.Context) ([]string, error) {
+	var resp struct {
+		ValidGraphs []string `json:"valid_graphs"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/metadata/valid-graphs", nil, &resp)
+	return resp.ValidGraphs, err
+}
+
+// PlotRequest is the body of POST /plot/{graph}, the observations are
+// fetched and transformed on the Go side and the service only draws them
+type PlotRequest struct {
+	Title string `json:"title"`
+	// html for a fragment, json for the Plotly figure
+	Format       string              `json:"format"`
+	Observations []fetch.Observation `json:"observations"`
+}
+
+// Plot is POST /plot/{graph}, an HTML fragment or a Plotly figure depending
+// on req.Format
+func (c *Client) Plot(ctx context.Context, graph string, req PlotRequest) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// the same graph of the same observations draws the same figure
+	sum := sha256.Sum256(body)
+	key := graph + " " + hex.EncodeToString(sum[:])
+	plot, _, err := c.plots.Do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return c.do(ctx, http.MethodPost, "/plot/"+url.PathEscape(graph), json.RawMessage(body))
+	})
+	return bytes.Clone(plot), err
+}
+
+// TestPlot is GET /plot/test, an HTML fragment
+func (c *Client) TestPlot(ctx context.Context) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, "/plot/test", nil)
+}
+
+// TestPlotJSON is GET /plot/test/json, a Plotly figure
+func (c *Client) TestPlotJSON(ctx context.Context) (json.RawMessage, error) {
+	var fig json.RawMessage
+	err := c.doJSON(ctx, http.MethodGet, "/plot/test/json", nil, &fig)
+	return fig, err
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, payload, out any) error {
+	body, err := c.do(ctx, method, path, payload)
+	if err != nil {
+		return err
+	}
+	if err := utils.CheckFailureResponse(body, c.Logger); err != nil {
+		// the failure came back as a 200, the service couldn't do the work
+		return &Error{Status: http.StatusBadGateway, Message: err.Error()}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding %s response: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, payload any) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	c.Logger.DebugContext(ctx, "Plot service request", "method", method, "path", path)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", ErrUnavailable, err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, &Error{Status: resp.StatusCode, Message: errorMessage(body)}
+	}
+	return body, nil
+}
+
+// errorMessage pulls the message out of an error body, the utils.ErrorResponse
+// envelope or a plain FastAPI {"detail": ...}
+func errorMessage(body []byte) string {
+	var envelope utils.ErrorResponse
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error.Message != "" {
+		return envelope.Error.Message
+	}
+	var fastapi struct {
+		Detail any `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &fastapi); err == nil && fastapi.Detail != nil {
+		if s, ok := fastapi.Detail.(string); ok {
+			return s
+		}
+		// validation errors are a list of objects
+		raw, _ := json.Marshal(fastapi.Detail)
+		return string(raw)
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,258 @@
+package plotservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+// newTestClient points a client built by New at srv
+func newTestClient(t *testing.T, srv *httptest.Server) *Client {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return New(host, port, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestClientRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		call       func(context.Context, *Client) (any, error)
+		wantMethod string
+		wantPath   string
+		// wantBody is the JSON body expected, "" for none
+		wantBody string
+		response string
+		want     any
+	}{
+		{
+			name:       "dataflows",
+			call:       func(ctx context.Context, c *Client) (any, error) { return c.Dataflows(ctx) },
+			wantMethod: http.MethodGet,
+			wantPath:   "/request-dataflow/ABS/",
+			response:   `[{"dataflowid":"CPI","dataflowname":"Consumer Price Index"}]`,
+			want:       []Dataflow{{DataflowID: "CPI", DataflowName: "Consumer Price Index"}},
+		},
+		{
+			name:       "data",
+			call:       func(ctx context.Context, c *Client) (any, error) { return c.Data(ctx, "CPI") },
+			wantMethod: http.MethodPost,
+			wantPath:   "/request-data/ABS/",
+			wantBody:   `{"dataflowid":"CPI"}`,
+			response:   `[{"TIME_PERIOD":"2024-Q1","OBS_VALUE":1.5}]`,
+			want:       []Record{{"TIME_PERIOD": "2024-Q1", "OBS_VALUE": 1.5}},
+		},
+		{
+			name:       "codelists",
+			call:       func(ctx context.Context, c *Client) (any, error) { return c.Codelists(ctx, "CPI") },
+			wantMethod: http.MethodPost,
+			wantPath:   "/request-codelist/ABS/",
+			wantBody:   `{"dataflowid":"CPI"}`,
+			response:   `[{"code":"1","name":"Index"}]`,
+			want:       []Record{{"code": "1", "name": "Index"}},
+		},
+		{
+			name:       "valid graphs",
+			call:       func(ctx context.Context, c *Client) (any, error) { return c.ValidGraphs(ctx) },
+			wantMethod: http.MethodGet,
+			wantPath:   "/metadata/valid-graphs",
+			response:   `{"valid_graphs":["line","bar"]}`,
+			want:       []string{"line", "bar"},
+		},
+		{
+			name: "plot",
+			call: func(ctx context.Context, c *Client) (any, error) {
+				plot, err := c.Plot(ctx, "line", PlotRequest{
+					Title:        "CPI",
+					Format:       "html",
+					Observations: []fetch.Observation{{Period: "2024-Q1", Value: 1.5}},
+				})
+				return string(plot), err
+			},
+			wantMethod: http.MethodPost,
+			wantPath:   "/plot/line",
+			response:   `<div>plot</div>`,
+			want:       `<div>plot</div>`,
+		},
+		{
+			name: "test plot",
+			call: func(ctx context.Context, c *Client) (any, error) {
+				plot, err := c.TestPlot(ctx)
+				return string(plot), err
+			},
+			wantMethod: http.MethodGet,
+			wantPath:   "/plot/test",
+			response:   `<div>test</div>`,
+			want:       `<div>test</div>`,
+		},
+		{
+			name: "test plot json",
+			call: func(ctx context.Context, c *Client) (any, error) {
+				fig, err := c.TestPlotJSON(ctx)
+				return string(fig), err
+			},
+			wantMethod: http.MethodGet,
+			wantPath:   "/plot/test/json",
+			response:   `{"data":[],"layout":{}}`,
+			want:       `{"data":[],"layout":{}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != tt.wantMethod || r.URL.Path != tt.wantPath {
+					t.Errorf("request %s %s, want %s %s", r.Method, r.URL.Path, tt.wantMethod, tt.wantPath)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if tt.wantBody != "" && string(body) != tt.wantBody {
+					t.Errorf("body %s, want %s", body, tt.wantBody)
+				}
+				if len(body) > 0 && r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Content-Type %q, want application/json", r.Header.Get("Content-Type"))
+				}
+				io.WriteString(w, tt.response)
+			}))
+			defer srv.Close()
+
+			got, err := tt.call(context.Background(), newTestClient(t, srv))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		response    string
+		wantStatus  int
+		wantMessage string
+	}{
+		{
+			name:        "failure envelope on a 200",
+			status:      http.StatusOK,
+			response:    `{"status":"failed","message":"unknown dataflow"}`,
+			wantStatus:  http.StatusBadGateway,
+			wantMessage: "unknown dataflow",
+		},
+		{
+			name:        "failure envelope without a message",
+			status:      http.StatusOK,
+			response:    `{"status":"failed"}`,
+			wantStatus:  http.StatusBadGateway,
+			wantMessage: "Unknown error",
+		},
+		{
+			name:        "invalid JSON",
+			status:      http.StatusOK,
+			response:    `not json`,
+			wantStatus:  http.StatusBadGateway,
+			wantMessage: "invalid response format",
+		},
+		{
+			name:        "error envelope",
+			status:      http.StatusNotFound,
+			response:    `{"error":{"code":"not_found","message":"no such dataflow"}}`,
+			wantStatus:  http.StatusNotFound,
+			wantMessage: "no such dataflow",
+		},
+		{
+			name:        "fastapi detail",
+			status:      http.StatusUnprocessableEntity,
+			response:    `{"detail":"dataflowid is required"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantMessage: "dataflowid is required",
+		},
+		{
+			name:        "plain text",
+			status:      http.StatusInternalServerError,
+			response:    "Internal Server Error\n",
+			wantStatus:  http.StatusInternalServerError,
+			wantMessage: "Internal Server Error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			}))
+			defer srv.Close()
+
+			_, err := newTestClient(t, srv).Codelists(context.Background(), "CPI")
+			var plotErr *Error
+			if !errors.As(err, &plotErr) {
+				t.Fatalf("error %v, want a *Error", err)
+			}
+			if plotErr.Status != tt.wantStatus || plotErr.Message != tt.wantMessage {
+				t.Errorf("error %d %q, want %d %q", plotErr.Status, plotErr.Message, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := newTestClient(t, srv)
+	if c.HTTP.Timeout != DefaultTimeout {
+		t.Errorf("client timeout %v, want DefaultTimeout", c.HTTP.Timeout)
+	}
+	c.HTTP.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := c.ValidGraphs(context.Background())
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error %v, want ErrUnavailable", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("error %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v, the timeout didn't apply", elapsed)
+	}
+}
+
+func TestClientUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	c := newTestClient(t, srv)
+	srv.Close()
+
+	if _, err := c.Dataflows(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error %v, want ErrUnavailable", err)
+	}
+}
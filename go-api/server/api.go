@@ -0,0 +1,329 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/config"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/export"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/handlers"
+	"github.com/VooDooM1234/abs-visualiser/go-api/jobs"
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+	"github.com/VooDooM1234/abs-visualiser/go-api/openapi"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/utils"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+// apiVersion is the version of the /api/v1 contract in the OpenAPI document,
+// bump the minor version for additions and add /api/v2 for breaking changes
+const apiVersion = "1.0.0"
+
+// apiRouter registers each route on the mux and documents it in the spec
+type apiRouter struct {
+	mux  *http.ServeMux
+	spec *openapi.Spec
+}
+
+func (a apiRouter) handle(pattern string, h http.Handler, op openapi.Operation) {
+	a.mux.Handle(pattern, h)
+	a.spec.Add(pattern, op)
+}
+
+// errorResponses documents the error envelope for each status
+func errorResponses(spec *openapi.Spec, responses map[string]openapi.Response, statuses ...string) map[string]openapi.Response {
+	descriptions := map[string]string{
+		"400": "Invalid parameters",
+		"404": "Not found",
+		"409": "Conflicts with the current state",
+		"422": "The data can't be transformed as asked",
+		"500": "Internal error",
+		"502": "The upstream service failed",
+		"503": "The upstream service is unreachable",
+		"504": "The upstream service, or the request as a whole, timed out",
+	}
+	for _, status := range statuses {
+		responses[status] = openapi.Response{Description: descriptions[status], Content: spec.JSON(utils.ErrorResponse{})}
+	}
+	return responses
+}
+
+func addAPIRoutes(
+	mux *http.ServeMux,
+	logger *slog.Logger,
+	cfg *config.Config,
+	database *db.Database,
+	abs *fetch.Fetch,
+	data *source.Source,
+	chartTypes *charts.Registry,
+	jobManager *jobs.Manager,
+	pages *views.Registry,
+) {
+	spec := openapi.New("ABS Visualiser API", apiVersion)
+	api := apiRouter{mux: mux, spec: spec}
+	timedOut := handlers.TimeoutError(pages)
+	page := middleware.Timeout(pageTimeout, timedOut)
+	upstream := middleware.Timeout(upstreamTimeout, timedOut)
+
+	dataflow := openapi.Path("dataflow", "ABS dataflow id, eg. CPI")
+	key := openapi.Path("key", "SDMX key, eg. 1.10001.10.50.Q, or all")
+	modes := make([]string, len(source.Modes))
+	for i, m := range source.Modes {
+		modes[i] = string(m)
+	}
+	sourceParam := openapi.Query("source", "read from the database, the ABS (live) or the database when fresh enough (cache), defaults to the configured data source", modes...)
+	dataParams := []openapi.Parameter{
+		dataflow, key,
+		openapi.Query("startPeriod", "first period, eg. 2015 or 2015-Q1"),
+		openapi.Query("endPeriod", "last period"),
+		openapi.Query("transform", "transform applied to the observations, can be repeated", "seasonal"),
+		openapi.Query("model", "seasonal decomposition model", "additive", "multiplicative"),
+		openapi.Query("period", "seasonal period in observations, defaults to the frequency"),
+		sourceParam,
+	}
+	dataHeaders := map[string]openapi.Header{
+		"X-Data-Source": {
+			Description: "where the observations were read from, abs, database or database-stale, a list when there are several datasets. database-stale is stored observations past data_max_age served because the ABS couldn't be reached",
+			Schema:      &openapi.Schema{Type: "string"},
+		},
+		"X-Data-Retrieved-At": {
+			Description: "when the ABS sent the observations, the oldest when there are several datasets",
+			Schema:      &openapi.Schema{Type: "string", Format: "date-time"},
+		},
+	}
+	upstreamErrors := []string{"400", "404", "502", "503", "504"}
+
+	api.handle("GET /api/v1/catalogue", page(handlers.CatalogueListHandler(cfg, logger, database)), openapi.Operation{
+		OperationID: "listDataflows",
+		Summary:     "List the ABS dataflows in the catalogue",
+		Tags:        []string{"catalogue"},
+		Parameters:  []openapi.Parameter{openapi.Query("q", "only dataflows whose id or name contains this")},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Dataflows sorted by id", Content: spec.JSON([]db.ABSDataflow{})},
+		}, "500"),
+	})
+	api.handle("GET /api/v1/catalogue/{dataflow}", page(handlers.CatalogueReadHandler(cfg, logger, database)), openapi.Operation{
+		OperationID: "getDataflow",
+		Summary:     "Get one dataflow from the catalogue",
+		Tags:        []string{"catalogue"},
+		Parameters:  []openapi.Parameter{dataflow},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The dataflow", Content: spec.JSON(db.ABSDataflow{})},
+		}, "404", "500"),
+	})
+
+	api.handle("GET /api/v1/structure/{dataflow}", upstream(handlers.StructureHandler(cfg, logger, abs)), openapi.Operation{
+		OperationID: "getStructure",
+		Summary:     "Get the dimensions of a dataflow and their codes, for building keys",
+		Tags:        []string{"structure"},
+		Parameters:  []openapi.Parameter{dataflow},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Dimensions in key order", Content: spec.JSON(fetch.Structure{})},
+		}, upstreamErrors...),
+	})
+
+	api.handle("GET /api/v1/data/{dataflow}/{key}", upstream(handlers.ABSDataHandler(cfg, logger, data)), openapi.Operation{
+		OperationID: "getData",
+		Summary:     "Get observations from the database or the ABS API",
+		Tags:        []string{"data"},
+		Parameters:  dataParams,
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Observations", Headers: dataHeaders, Content: spec.JSON([]fetch.Observation{})},
+		}, append(upstreamErrors, "422")...),
+	})
+	api.handle("POST /api/v1/data/derive", upstream(handlers.DeriveHandler(cfg, logger, data)), openapi.Operation{
+		OperationID: "deriveSeries",
+		Summary:     "Combine series from one or more dataflows with an expression",
+		Tags:        []string{"data"},
+		Parameters:  []openapi.Parameter{sourceParam},
+		RequestBody: &openapi.RequestBody{Required: true, Content: spec.JSON(handlers.DeriveRequest{})},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The derived series", Headers: dataHeaders, Content: spec.JSON([]fetch.Observation{})},
+		}, append(upstreamErrors, "422")...),
+	})
+
+	api.handle("GET /api/v1/chart/{type}/{dataflow}/{key}", upstream(handlers.ChartHandler(cfg, logger, data, chartTypes, pages)), openapi.Operation{
+		OperationID: "getChart",
+		Summary:     "Render a Plotly chart as an HTML fragment",
+		Tags:        []string{"chart"},
+		Parameters: append([]openapi.Parameter{{
+			Name: "type", In: "path", Required: true,
+			Schema: &openapi.Schema{Type: "string", Enum: chartTypes.Names(charts.RendererGo)},
+		}}, dataParams...),
+		Responses: map[string]openapi.Response{
+			"200":     {Description: "HTML fragment", Headers: dataHeaders, Content: map[string]openapi.MediaType{"text/html": {}}},
+			"default": {Description: "HTML error fragment", Content: map[string]openapi.MediaType{"text/html": {}}},
+		},
+	})
+
+	api.handle("GET /api/v1/charts", page(handlers.ChartTypesHandler(cfg, logger, chartTypes)), openapi.Operation{
+		OperationID: "listChartTypes",
+		Summary:     "List the chart types, their data shapes, parameters and renderers",
+		Tags:        []string{"chart"},
+		Parameters:  []openapi.Parameter{openapi.Query("renderer", "only chart types this renderer can draw, go for /chart and python for /plot", "go", "python")},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Chart types", Content: spec.JSON([]charts.Chart{})},
+		}, "400"),
+	})
+
+	exportContent := map[string]openapi.MediaType{}
+	for _, f := range []export.Format{export.CSV, export.XLSX, export.Parquet} {
+		exportContent[f.ContentType()] = openapi.MediaType{Schema: &openapi.Schema{Type: "string", Format: "binary"}}
+	}
+	api.handle("GET /api/v1/export/{dataflow}/{key}", handlers.ExportHandler(cfg, logger, jobManager, exportDir), openapi.Operation{
+		OperationID: "exportData",
+		Summary:     "Download observations as a file, runs an export job and waits for it",
+		Tags:        []string{"export"},
+		Parameters:  append(dataParams, openapi.Query("format", "file format, default csv", "csv", "xlsx", "parquet")),
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The file, named in Content-Disposition", Headers: dataHeaders, Content: exportContent},
+		}, append(upstreamErrors, "409", "422")...),
+	})
+
+	dashboardID := openapi.Path("id", "dashboard id")
+	api.handle("GET /api/v1/dashboards", page(handlers.DashboardListHandler(cfg, logger, database)), openapi.Operation{
+		OperationID: "listDashboards",
+		Summary:     "List saved dashboards",
+		Tags:        []string{"dashboards"},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Dashboards", Content: spec.JSON([]db.Dashboard{})},
+		}, "500"),
+	})
+	api.handle("POST /api/v1/dashboards", page(handlers.DashboardCreateHandler(cfg, logger, database, chartTypes)), openapi.Operation{
+		OperationID: "createDashboard",
+		Summary:     "Save a dashboard",
+		Tags:        []string{"dashboards"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: spec.JSON(db.Dashboard{})},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"201": {Description: "The saved dashboard", Content: spec.JSON(db.Dashboard{})},
+		}, "400", "500"),
+	})
+	api.handle("GET /api/v1/dashboards/{id}", page(handlers.DashboardReadHandler(cfg, logger, database)), openapi.Operation{
+		OperationID: "getDashboard",
+		Summary:     "Get a saved dashboard",
+		Tags:        []string{"dashboards"},
+		Parameters:  []openapi.Parameter{dashboardID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The dashboard", Content: spec.JSON(db.Dashboard{})},
+		}, "404", "500"),
+	})
+	api.handle("PUT /api/v1/dashboards/{id}", page(handlers.DashboardUpdateHandler(cfg, logger, database, chartTypes)), openapi.Operation{
+		OperationID: "updateDashboard",
+		Summary:     "Replace a saved dashboard",
+		Tags:        []string{"dashboards"},
+		Parameters:  []openapi.Parameter{dashboardID},
+		RequestBody: &openapi.RequestBody{Required: true, Content: spec.JSON(db.Dashboard{})},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The updated dashboard", Content: spec.JSON(db.Dashboard{})},
+		}, "400", "404", "500"),
+	})
+	api.handle("DELETE /api/v1/dashboards/{id}", page(handlers.DashboardDeleteHandler(cfg, logger, database)), openapi.Operation{
+		OperationID: "deleteDashboard",
+		Summary:     "Delete a saved dashboard",
+		Tags:        []string{"dashboards"},
+		Parameters:  []openapi.Parameter{dashboardID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"204": {Description: "Deleted"},
+		}, "404", "500"),
+	})
+	api.handle("POST /api/v1/dashboards/{id}/refresh", page(handlers.DashboardRefreshJobsHandler(cfg, logger, database, jobManager)), openapi.Operation{
+		OperationID: "refreshDashboard",
+		Summary:     "Queue a fetch job for each distinct query on the dashboard's panels",
+		Tags:        []string{"dashboards", "jobs"},
+		Parameters:  []openapi.Parameter{dashboardID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"202": {Description: "The queued jobs, a query already being fetched joins that job", Content: spec.JSON([]jobs.Job{})},
+		}, "404", "500"),
+	})
+
+	jobID := openapi.Path("id", "job id")
+	queuedJob := "The queued job, its URL is in Location. An identical job already queued or running is returned instead."
+	api.handle("POST /api/v1/jobs/fetch", page(handlers.JobCreateFetchHandler(cfg, logger, jobManager)), openapi.Operation{
+		OperationID: "createFetchJob",
+		Summary:     "Queue downloading data from the ABS into the database",
+		Tags:        []string{"jobs"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: spec.JSON(fetch.DataQuery{})},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"202": {Description: queuedJob, Content: spec.JSON(jobs.Job{})},
+		}, "400", "500"),
+	})
+	api.handle("POST /api/v1/jobs/export", page(handlers.ExportJobHandler(cfg, logger, jobManager)), openapi.Operation{
+		OperationID: "createExportJob",
+		Summary:     "Queue writing observations to a file, downloaded from /api/v1/jobs/{id}/file",
+		Tags:        []string{"jobs", "export"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: spec.JSON(jobs.ExportParams{})},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"202": {Description: queuedJob, Content: spec.JSON(jobs.Job{})},
+		}, "400", "500"),
+	})
+	api.handle("POST /api/v1/catalogue/sync", page(handlers.CatalogueSyncJobHandler(cfg, logger, jobManager)), openapi.Operation{
+		OperationID: "createCatalogueSyncJob",
+		Summary:     "Queue importing the ABS dataflow catalogue, which takes the ABS minutes",
+		Tags:        []string{"jobs", "catalogue"},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"202": {Description: queuedJob, Content: spec.JSON(jobs.Job{})},
+		}, "500"),
+	})
+	api.handle("GET /api/v1/jobs", page(handlers.JobListHandler(cfg, logger, jobManager)), openapi.Operation{
+		OperationID: "listJobs",
+		Summary:     "List jobs from the last day, newest first",
+		Tags:        []string{"jobs"},
+		Parameters: []openapi.Parameter{
+			openapi.Query("kind", "only jobs of this kind", jobs.KindFetch, jobs.KindExport, jobs.KindSyncCatalogue),
+			openapi.Query("state", "only jobs in this state", "queued", "running", "succeeded", "failed", "cancelled"),
+			openapi.Query("limit", "at most this many jobs, 1 to 100, default 100"),
+		},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Jobs", Content: spec.JSON([]jobs.Job{})},
+		}, "400", "500"),
+	})
+	api.handle("GET /api/v1/jobs/{id}", page(handlers.JobReadHandler(cfg, logger, jobManager)), openapi.Operation{
+		OperationID: "getJob",
+		Summary:     "Get a job's state, progress and result",
+		Tags:        []string{"jobs"},
+		Parameters:  []openapi.Parameter{jobID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The job", Content: spec.JSON(jobs.Job{})},
+		}, "404", "500"),
+	})
+	api.handle("GET /api/v1/jobs/{id}/file", page(handlers.JobFileHandler(cfg, logger, jobManager, exportDir)), openapi.Operation{
+		OperationID: "getJobFile",
+		Summary:     "Download the file a succeeded export job wrote",
+		Tags:        []string{"jobs", "export"},
+		Parameters:  []openapi.Parameter{jobID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "The file, named in Content-Disposition", Headers: dataHeaders, Content: exportContent},
+		}, "404", "409", "500"),
+	})
+	api.handle("DELETE /api/v1/jobs/{id}", page(handlers.JobDeleteHandler(cfg, logger, jobManager)), openapi.Operation{
+		OperationID: "cancelJob",
+		Summary:     "Cancel a queued or running job",
+		Tags:        []string{"jobs"},
+		Parameters:  []openapi.Parameter{jobID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"202": {Description: "Cancelling, the job is cancelled once its work stops"},
+		}, "404", "409"),
+	})
+	// no timeout, the stream lasts as long as the job
+	api.handle("GET /api/v1/jobs/{id}/events", handlers.JobStreamHandler(cfg, logger, jobManager), openapi.Operation{
+		OperationID: "streamJob",
+		Summary:     "Server-sent events with the job as JSON, progress on each change and done when it finishes",
+		Tags:        []string{"jobs"},
+		Parameters:  []openapi.Parameter{jobID},
+		Responses: errorResponses(spec, map[string]openapi.Response{
+			"200": {Description: "Event stream", Content: map[string]openapi.MediaType{"text/event-stream": {}}},
+		}, "404"),
+	})
+
+	api.handle("GET /api/v1/openapi.json", page(handlers.OpenAPIHandler(cfg, logger, spec)), openapi.Operation{
+		OperationID: "getOpenAPI",
+		Summary:     "This document",
+		Tags:        []string{"meta"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "OpenAPI document", Content: map[string]openapi.MediaType{"application/json": {}}},
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/supervisor"
+)
+
+const (
+	// until the plot service first answers
+	chartRetryInterval = 5 * time.Second
+	// it can be restarted with a different graphRegistry
+	chartSyncInterval = time.Minute
+)
+
+// syncChartTypes merges the graphs the plot service advertises into the
+// registry while it's running and logs when the two sides disagree
+func syncChartTypes(ctx context.Context, registry *charts.Registry, plot *plotservice.Client, plotService *supervisor.Supervisor, logger *slog.Logger) {
+	interval := chartRetryInterval
+	for {
+		if plotService.Status().State == supervisor.StateRunning {
+			graphs, err := plot.ValidGraphs(ctx)
+			if err != nil {
+				logger.WarnContext(ctx, "Failed to get chart types from the plot service", "err", err)
+			} else {
+				added, dropped := registry.MergePython(graphs)
+				if len(added) > 0 || len(dropped) > 0 {
+					logger.WarnContext(ctx, "Chart types differ between Go and the plot service", "pythonOnly", added, "droppedByPython", dropped)
+				}
+				interval = chartSyncInterval
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
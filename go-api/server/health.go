@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/catalogue"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/health"
+	"github.com/VooDooM1234/abs-visualiser/go-api/supervisor"
+)
+
+const (
+	healthCheckTimeout = 2 * time.Second
+	// the dataflow list changes a few times a year, a month old copy is fine
+	maxCatalogueAge = 30 * 24 * time.Hour
+	// how long ABS failures can go on before it counts as unreachable
+	absFailureGrace = 15 * time.Minute
+)
+
+func newHealthChecker(
+	database *db.Database,
+	abs *fetch.Fetch,
+	plotService *supervisor.Supervisor,
+	dataflows *catalogue.Cache,
+) *health.Checker {
+	return health.NewChecker(healthCheckTimeout,
+		health.Check{
+			Name:     "postgres",
+			Critical: true,
+			Run: func(ctx context.Context) (any, error) {
+				stat := database.Pool.Stat()
+				details := map[string]any{
+					"totalConns":    stat.TotalConns(),
+					"idleConns":     stat.IdleConns(),
+					"acquiredConns": stat.AcquiredConns(),
+					"maxConns":      stat.MaxConns(),
+				}
+				return details, database.Pool.Ping(ctx)
+			},
+		},
+		health.Check{
+			Name: "plotService",
+			Run: func(ctx context.Context) (any, error) {
+				status := plotService.Status()
+				if status.State != supervisor.StateRunning && status.State != supervisor.StateStarting {
+					return status, fmt.Errorf("plot service is %s", status.State)
+				}
+				return status, plotService.Probe(ctx)
+			},
+		},
+		health.Check{
+			Name: "absApi",
+			Run: func(ctx context.Context) (any, error) {
+				stats := abs.Stats()
+				details := map[string]any{
+					"stats":         stats,
+					"lastLatencyMs": float64(stats.LastLatency.Microseconds()) / 1000,
+				}
+				switch {
+				case stats.LastSuccess.IsZero() && stats.LastFailure.IsZero():
+					return details, health.ErrUnknown
+				case stats.LastFailure.After(stats.LastSuccess) &&
+					(stats.LastSuccess.IsZero() || stats.LastFailure.Sub(stats.LastSuccess) > absFailureGrace):
+					return details, errors.New("ABS API unreachable since last successful fetch: " + stats.LastError)
+				}
+				return details, nil
+			},
+		},
+		health.Check{
+			Name: "catalogue",
+			Run: func(ctx context.Context) (any, error) {
+				count, updated, err := database.CatalogueStatus(ctx)
+				if err != nil {
+					return nil, err
+				}
+				details := map[string]any{"dataflows": count, "updatedAt": updated}
+				switch {
+				case count == 0:
+					return details, errors.New("dataflow catalogue is empty, run the catalogue sync")
+				case time.Since(updated) > maxCatalogueAge:
+					return details, fmt.Errorf("dataflow catalogue last synced %s ago", time.Since(updated).Round(time.Hour))
+				}
+				return details, nil
+			},
+		},
+		health.Check{
+			Name: "cache",
+			Run: func(ctx context.Context) (any, error) {
+				stats := dataflows.Stats()
+				if stats.LastError != "" {
+					return stats, errors.New(stats.LastError)
+				}
+				return stats, nil
+			},
+		},
+	)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"runtime"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/catalogue"
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+	"github.com/VooDooM1234/abs-visualiser/go-api/supervisor"
+)
+
+// registerMetrics exposes numbers other packages already keep, read at scrape
+// time. Call it once, metric names can only be registered once.
+func registerMetrics(database *db.Database, plotService *supervisor.Supervisor, dataflows *catalogue.Cache) {
+	metrics.NewCounterFunc("catalogue_cache_hits_total", "Dataflow lookups served from the in memory catalogue.",
+		func() float64 { return float64(dataflows.Stats().Hits) })
+	metrics.NewCounterFunc("catalogue_cache_misses_total", "Dataflow lookups that reloaded the catalogue from Postgres.",
+		func() float64 { return float64(dataflows.Stats().Misses) })
+	metrics.NewGaugeFunc("catalogue_dataflows", "Dataflows in the in memory catalogue.",
+		func() float64 { return float64(dataflows.Stats().Entries) })
+
+	metrics.NewGaugeFunc("postgres_pool_total_conns", "Connections in the Postgres pool.",
+		func() float64 { return float64(database.Pool.Stat().TotalConns()) })
+	metrics.NewGaugeFunc("postgres_pool_acquired_conns", "Postgres connections in use.",
+		func() float64 { return float64(database.Pool.Stat().AcquiredConns()) })
+
+	metrics.NewGaugeFunc("plot_service_ready", "1 when the plot service answers its readiness probe.",
+		func() float64 {
+			if plotService.Status().Ready {
+				return 1
+			}
+			return 0
+		})
+	metrics.NewCounterFunc("plot_service_restarts_total", "Times the plot service has been restarted after exiting.",
+		func() float64 { return float64(plotService.Status().Restarts) })
+
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+}
@@ -1,42 +1,94 @@
 package server
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/VooDooM1234/abs-visualiser/go-api/assets"
+	"github.com/VooDooM1234/abs-visualiser/go-api/catalogue"
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
 	"github.com/VooDooM1234/abs-visualiser/go-api/config"
 	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
 	"github.com/VooDooM1234/abs-visualiser/go-api/handlers"
+	"github.com/VooDooM1234/abs-visualiser/go-api/health"
+	"github.com/VooDooM1234/abs-visualiser/go-api/jobs"
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
+)
+
+const (
+	pageTimeout = 10 * time.Second
+	// routes that wait on the ABS or the plot service
+	upstreamTimeout = 2 * time.Minute
 )
 
 func AddRoutes(
 	mux *http.ServeMux,
-	logger *log.Logger,
+	logger *slog.Logger,
 	cfg *config.Config,
 	db *db.Database,
+	abs *fetch.Fetch,
+	data *source.Source,
+	dataflows *catalogue.Cache,
+	checker *health.Checker,
+	pages *views.Registry,
+	static *assets.Server,
+	plot *plotservice.Client,
+	chartTypes *charts.Registry,
+	jobManager *jobs.Manager,
 ) {
+	timedOut := handlers.TimeoutError(pages)
+	page := middleware.Timeout(pageTimeout, timedOut)
+	upstream := middleware.Timeout(upstreamTimeout, timedOut)
+
 	// page handlers
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	mux.Handle("/", handlers.IndexHandler(cfg, logger))
-	mux.Handle("/dashboard", handlers.DashboardHandler(cfg, logger))
-	mux.Handle("/home", handlers.HomeHandler(cfg, logger))
+	mux.Handle(assets.Prefix, http.StripPrefix(assets.Prefix, static))
+	mux.Handle("/", page(handlers.IndexHandler(cfg, logger, pages)))
+	mux.Handle("/dashboard", page(handlers.DashboardHandler(cfg, logger, pages)))
+	mux.Handle("/home", page(handlers.HomeHandler(cfg, logger, pages)))
 
-	mux.Handle("/sidebar", handlers.SidebarHandler(cfg, logger))
-	mux.Handle("/health", handlers.HealthHandler(cfg, logger))
+	mux.Handle("/sidebar", page(handlers.SidebarHandler(cfg, logger, pages)))
+	mux.Handle("/health", page(handlers.HealthReadyHandler(cfg, logger, checker)))
+	mux.Handle("/health/live", page(handlers.HealthLiveHandler(cfg, logger, checker)))
+	mux.Handle("/health/ready", page(handlers.HealthReadyHandler(cfg, logger, checker)))
+	mux.Handle("/metrics", page(handlers.MetricsHandler(cfg, logger)))
 
-	mux.Handle("/dataflow/ABS/", handlers.RequestDataflowABS(cfg, logger))
+	mux.Handle("/dataflow/ABS/", upstream(handlers.RequestDataflowABS(cfg, logger, plot, pages)))
 
-	mux.Handle("/request-data/ABS/", handlers.RequestABSData(cfg, logger))
+	mux.Handle("/request-data/ABS/", upstream(handlers.RequestABSData(cfg, logger, plot)))
+	mux.Handle("/data/ABS/", upstream(handlers.ABSDataHandler(cfg, logger, data)))
+	mux.Handle("/data/derive/", upstream(handlers.DeriveHandler(cfg, logger, data)))
+	mux.Handle("/api/export", handlers.ExportHandler(cfg, logger, jobManager, exportDir))
 	//plotting routes
-	// mux.Handle("/refresh-dashboard/", handlers.RefreshDashboardhandler(cfg, logger, db))
-	mux.Handle("/plot/", handlers.PlotHandler(cfg, logger, db))
+	mux.Handle("GET /chart/{type}", upstream(handlers.ChartHandler(cfg, logger, data, chartTypes, pages)))
+	mux.Handle("GET /plot/{graph}/{dataflow}", upstream(handlers.PlotHandler(cfg, logger, data, plot, chartTypes, dataflows, pages)))
+
+	// {$} so these don't overlap the {graph}/{dataflow} pattern
+	mux.Handle("/plot/test/{$}", upstream(handlers.PlotTestHandler(cfg, logger, plot, pages)))
+	mux.Handle("/plot/test/json/{$}", upstream(handlers.PlotTestJSONHandler(cfg, logger, plot)))
+	mux.Handle("/plot/decompose/{$}", upstream(handlers.PlotDecomposeHandler(cfg, logger, data, pages)))
 
-	mux.Handle("/plot/test/", handlers.PlotTestHandler(cfg, logger))
-	mux.Handle("/plot/test/json/", handlers.PlotTestJSONHandler(cfg, logger))
+	// saved dashboards
+	mux.Handle("GET /api/dashboards", page(handlers.DashboardListHandler(cfg, logger, db)))
+	mux.Handle("POST /api/dashboards", page(handlers.DashboardCreateHandler(cfg, logger, db, chartTypes)))
+	mux.Handle("GET /api/dashboards/{id}", page(handlers.DashboardReadHandler(cfg, logger, db)))
+	mux.Handle("PUT /api/dashboards/{id}", page(handlers.DashboardUpdateHandler(cfg, logger, db, chartTypes)))
+	mux.Handle("DELETE /api/dashboards/{id}", page(handlers.DashboardDeleteHandler(cfg, logger, db)))
+	mux.Handle("GET /dashboards/{id}", page(handlers.SavedDashboardPageHandler(cfg, logger, pages)))
+	mux.Handle("GET /dashboards/{id}/panels", page(handlers.SavedDashboardPanelsHandler(cfg, logger, db, pages)))
+	mux.Handle("POST /dashboards/{id}/refresh", page(handlers.DashboardRefreshHandler(cfg, logger, db, jobManager, pages)))
 
-	// reverse proxy
-	mux.Handle("/dashboard/", handlers.ReverseProxyDashHandler(cfg, logger))
+	mux.Handle("/get-dashboard/", page(handlers.GetDashboardHandler(cfg, logger, pages)))
 
-	mux.Handle("/get-dashboard/", handlers.GetDashboardHandler(cfg, logger))
+	// background jobs, the event stream is long lived so has no timeout
+	mux.Handle("POST /jobs/fetch", page(handlers.FetchJobHandler(cfg, logger, jobManager, pages)))
+	mux.Handle("POST /jobs/{id}/cancel", page(handlers.JobCancelHandler(cfg, logger, jobManager, pages)))
+	mux.Handle("GET /jobs/{id}/events", handlers.JobEventsHandler(cfg, logger, jobManager, pages))
 
+	// versioned JSON API, documented at /api/v1/openapi.json
+	addAPIRoutes(mux, logger, cfg, db, abs, data, chartTypes, jobManager, pages)
 }
@@ -2,115 +2,249 @@ package server
 
 import (
 	"context"
-	"fmt"
-	"io"
-	"log"
+	"html/template"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
-	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"sync"
 	"time"
 
+	web "github.com/VooDooM1234/abs-visualiser"
+	"github.com/VooDooM1234/abs-visualiser/go-api/assets"
+	"github.com/VooDooM1234/abs-visualiser/go-api/catalogue"
+	"github.com/VooDooM1234/abs-visualiser/go-api/charts"
 	"github.com/VooDooM1234/abs-visualiser/go-api/config"
 	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/health"
+	"github.com/VooDooM1234/abs-visualiser/go-api/jobs"
+	"github.com/VooDooM1234/abs-visualiser/go-api/logging"
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+	"github.com/VooDooM1234/abs-visualiser/go-api/plotservice"
+	"github.com/VooDooM1234/abs-visualiser/go-api/source"
+	"github.com/VooDooM1234/abs-visualiser/go-api/supervisor"
+	"github.com/VooDooM1234/abs-visualiser/go-api/views"
 )
 
+const (
+	// how often dev mode checks the templates for changes
+	templatePollInterval = 500 * time.Millisecond
+	// jobs that run at once, the rest wait their turn
+	jobWorkers = 2
+	// how long finished jobs and their export files are kept
+	jobRetention = 24 * time.Hour
+)
+
+// exportDir holds the files written by export jobs until they expire
+var exportDir = filepath.Join(os.TempDir(), "abs-visualiser-exports")
+
 func NewServer(
-	logger *log.Logger,
+	loggers *logging.Loggers,
 	cfg *config.Config,
 	db *db.Database,
+	abs *fetch.Fetch,
+	data *source.Source,
+	dataflows *catalogue.Cache,
+	checker *health.Checker,
+	pages *views.Registry,
+	static *assets.Server,
+	plot *plotservice.Client,
+	chartTypes *charts.Registry,
+	jobManager *jobs.Manager,
 ) http.Handler {
 	mux := http.NewServeMux()
 
-	AddRoutes(mux, logger, cfg, db)
+	AddRoutes(mux, loggers.Logger(logging.Handlers), cfg, db, abs, data, dataflows, checker, pages, static, plot, chartTypes, jobManager)
 
+	logger := loggers.Logger(logging.Server)
 	var handler http.Handler = mux
-	// wrap middlewares here if you want
+	handler = middleware.Chain(handler,
+		middleware.RequestID,
+		middleware.AccessLog(logger),
+		middleware.Metrics,
+		middleware.Recover(logger),
+		middleware.Gzip,
+	)
 
 	return handler
 }
 
-func launchPythonMicroservice(config *config.Config) {
-	cmd := exec.Command(
-		config.PythonPath,
-		"-m", "uvicorn",
-		"plotapp.main:app",
-		"--host", config.PlotServiceHost,
-		"--port", strconv.Itoa(config.PlotServicePort),
-	)
-
-	cmd.Env = append(os.Environ(),
-		"PLOT_SERVICE_HOST="+config.PlotServiceHost,
-		"PLOT_SERVICE_PORT="+strconv.Itoa(config.PlotServicePort),
-	)
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Start()
-	if err != nil {
-		log.Fatalf("Failed to launch Python microservice: %v", err)
+// assetFS is dir on disk when one is configured, otherwise the embedded copy
+func assetFS(dir string, embedded fs.FS) fs.FS {
+	if dir == "" {
+		return embedded
 	}
-
-	log.Printf("Python microservice running at http://%s:%d\n", config.PlotServiceHost, config.PlotServicePort)
+	return os.DirFS(dir)
 }
 
-// STOP STEALIN MY PORTS
-func KillPort(port string) error {
-	psCommand := fmt.Sprintf(`$connections = Get-NetTCPConnection -LocalPort %s -ErrorAction SilentlyContinue;
-		foreach ($c in $connections) {
-			Stop-Process -Id $c.OwningProcess -Force -ErrorAction SilentlyContinue
-		}`, port)
+// pythonExecutable falls back to the usual virtualenv locations when the
+// configured interpreter doesn't exist, config.json ships with the Windows path
+func pythonExecutable(configured string) string {
+	candidates := []string{configured}
+	if runtime.GOOS == "windows" {
+		candidates = append(candidates, filepath.Join(".venv", "Scripts", "python.exe"), "python.exe")
+	} else {
+		candidates = append(candidates, filepath.Join(".venv", "bin", "python"), "python3")
+	}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if _, err := os.Stat(c); err == nil {
+			return c
+		}
+		if path, err := exec.LookPath(c); err == nil {
+			return path
+		}
+	}
+	return configured
+}
 
-	cmd := exec.Command("powershell", "-Command", psCommand)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func newPlotService(config *config.Config, logger *slog.Logger) *supervisor.Supervisor {
+	addr := net.JoinHostPort(config.PlotServiceHost, strconv.Itoa(config.PlotServicePort))
+	script := config.PlotServiceScript
+	if script == "" {
+		script = "plotapp.main:app"
+	}
 
-	return cmd.Run()
+	return supervisor.New(supervisor.Config{
+		Name:    "plotapp",
+		Command: pythonExecutable(config.PythonPath),
+		Args: []string{
+			"-m", "uvicorn",
+			script,
+			"--host", config.PlotServiceHost,
+			"--port", strconv.Itoa(config.PlotServicePort),
+		},
+		Env: []string{
+			"PLOT_SERVICE_HOST=" + config.PlotServiceHost,
+			"PLOT_SERVICE_PORT=" + strconv.Itoa(config.PlotServicePort),
+			// the plot service reads the same settings the server was started with
+			"DATABASE_URL=" + config.PostgresURL,
+			"ABSVIS_CONFIG=" + config.ConfigFile,
+		},
+		Addr:     addr,
+		ReadyURL: "http://" + addr + "/metadata/valid-graphs",
+		Logger:   logger,
+	})
 }
 
-func Run(ctx context.Context, w io.Writer, args []string) error {
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
-	defer cancel()
-	logger := log.Default()
-	config, err := config.Init()
+// Run serves the web app and supervises the plot service until ctx is cancelled
+func Run(ctx context.Context, config *config.Config, loggers *logging.Loggers) error {
+	logger := loggers.Logger(logging.Server)
+	if err := config.RequireDatabase(); err != nil {
+		return err
+	}
+
+	static, err := assets.New(assetFS(config.StaticDir, web.Static), config.Dev)
 	if err != nil {
-		fmt.Println("Failed to load config:", err)
+		logger.Error("Failed to load static files", "err", err)
 		return err
 	}
-	databaseConnect, err := db.NewDatabase(ctx)
+	if missing := static.MissingVendored(); len(missing) > 0 {
+		logger.Warn("Front-end libraries not vendored, the UI loads them from CDNs, run vendor-assets to work offline", "missing", missing)
+	}
+	funcs := template.FuncMap{"asset": static.Path, "vendor": static.VendorPath}
+	// a broken template should stop startup, not surface on the first request
+	pages, err := views.New(assetFS(config.HTMLTemplates, web.Templates), funcs, config.Dev, loggers.Logger(logging.Handlers))
 	if err != nil {
-		fmt.Println("Failed to connect:", err)
+		logger.Error("Failed to parse templates", "err", err)
+		return err
+	}
+
+	databaseConnect, err := db.NewDatabase(ctx, config.PostgresURL, loggers.Logger(logging.DB))
+	if err != nil {
+		logger.Error("Failed to connect to database", "err", err)
 		return err
 	}
 	defer databaseConnect.Close()
 
+	if err := databaseConnect.Migrate(); err != nil {
+		logger.Error("Failed to migrate database", "err", err)
+		return err
+	}
+
+	absFetch := fetch.NewABS(loggers.Logger(logging.Fetch))
+	absFetch.Client = &http.Client{Transport: &middleware.Transport{}}
+	data := source.New(absFetch, databaseConnect, source.Policy{
+		Mode:     source.Mode(config.DataSource),
+		MaxAge:   time.Duration(config.DataMaxAge),
+		MaxStale: time.Duration(config.DataMaxStale),
+	}, loggers.Logger(logging.Fetch))
+	dataflows := catalogue.New(databaseConnect, time.Hour)
+	plotService := newPlotService(config, loggers.Logger(logging.Supervisor))
+	checker := newHealthChecker(databaseConnect, absFetch, plotService, dataflows)
+	registerMetrics(databaseConnect, plotService, dataflows)
+
+	jobManager := jobs.New(databaseConnect, jobWorkers, jobRetention, loggers.Logger(logging.Jobs))
+	jobManager.Register(jobs.FetchKind(absFetch, data))
+	jobManager.Register(jobs.SyncCatalogueKind(absFetch, databaseConnect, dataflows))
+	jobManager.Register(jobs.ExportKind(data, exportDir, jobRetention))
+	if err := jobManager.Resume(ctx); err != nil {
+		logger.Error("Failed to resume jobs", "err", err)
+		return err
+	}
+	plot := plotservice.New(config.PlotServiceHost, config.PlotServicePort, loggers.Logger(logging.Fetch))
+	chartTypes := charts.Default()
+
 	srv := NewServer(
-		logger,
+		loggers,
 		config,
 		databaseConnect,
+		absFetch,
+		data,
+		dataflows,
+		checker,
+		pages,
+		static,
+		plot,
+		chartTypes,
+		jobManager,
 	)
 
 	httpServer := &http.Server{
-		Addr:    net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
-		Handler: srv,
+		Addr:     net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		Handler:  srv,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
-	KillPort(strconv.Itoa(config.PlotServicePort))
-	KillPort(strconv.Itoa(config.Port))
-	launchPythonMicroservice(config)
-
 	go func() {
-		log.Printf("listening on %s\n", httpServer.Addr)
+		logger.Info("Listening", "addr", httpServer.Addr)
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Fprintf(os.Stderr, "error listening and serving: %s\n", err)
+			logger.Error("Error listening and serving", "err", err)
 		}
 	}()
 	var wg sync.WaitGroup
 	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		plotService.Run(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jobManager.Run(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		syncChartTypes(ctx, chartTypes, plot, plotService, logger)
+	}()
+	if config.Dev {
+		logger.Info("Development mode, watching templates", "dir", config.HTMLTemplates)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pages.Watch(ctx, templatePollInterval)
+		}()
+	}
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
@@ -118,7 +252,7 @@ func Run(ctx context.Context, w io.Writer, args []string) error {
 		shutdownCtx, cancel := context.WithTimeout(shutdownCtx, 10*time.Second)
 		defer cancel()
 		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			fmt.Fprintf(os.Stderr, "error shutting down http server: %s\n", err)
+			logger.Error("Error shutting down http server", "err", err)
 		}
 	}()
 	wg.Wait()
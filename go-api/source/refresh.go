@@ -0,0 +1,164 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+)
+
+var revisions = metrics.NewCounterVec(
+	"observation_revisions_total",
+	"Stored observations the ABS has since published a different value for, by dataflow.",
+	"dataflow",
+)
+
+// Revision is a stored observation the ABS has changed
+type Revision struct {
+	SeriesKey string  `json:"seriesKey"`
+	Period    string  `json:"period"`
+	Old       float64 `json:"old"`
+	New       float64 `json:"new"`
+}
+
+// Refreshed is what a Refresh found. Changed counts every observation the ABS
+// sent back, Added those that weren't stored before and Revised those that
+// were stored with another value.
+type Refreshed struct {
+	DataflowID  string     `json:"dataflowid"`
+	Key         string     `json:"key"`
+	StartPeriod string     `json:"startPeriod,omitempty"`
+	EndPeriod   string     `json:"endPeriod,omitempty"`
+	Series      int        `json:"series"`
+	Changed     int        `json:"changed"`
+	Added       int        `json:"added"`
+	Revised     []Revision `json:"revised"`
+	RetrievedAt time.Time  `json:"retrievedAt"`
+}
+
+// Refresh asks the ABS for the observations added or revised in the answer to
+// a stored query since it was retrieved, stores them and moves the retrieval
+// time on. Revisions are logged. The ABS doesn't report withdrawn
+// observations this way, they stay stored.
+func (s *Source) Refresh(ctx context.Context, r db.Retrieval) (Refreshed, error) {
+	refreshed := Refreshed{
+		DataflowID:  r.DataflowID,
+		Key:         r.Key,
+		StartPeriod: r.StartPeriod,
+		EndPeriod:   r.EndPeriod,
+		Revised:     []Revision{},
+	}
+	query := retrievalQuery(r)
+	query.UpdatedAfter = r.RetrievedAt
+
+	// taken before asking so the next refresh can't miss anything published
+	// while this one ran
+	asked := time.Now().UTC()
+	ds, err := s.abs.ABSRestDataset(ctx, query)
+	var statusErr *fetch.StatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound:
+		// the ABS answers 404 when nothing has changed
+		ds = &fetch.Dataset{}
+	case err != nil:
+		return refreshed, err
+	}
+
+	var seriesKeys []string
+	seen := make(map[string]bool)
+	for _, o := range ds.Observations {
+		if !seen[o.SeriesKey] {
+			seen[o.SeriesKey] = true
+			seriesKeys = append(seriesKeys, o.SeriesKey)
+		}
+	}
+	var stored map[db.ObservationKey]float64
+	if len(seriesKeys) > 0 {
+		if stored, err = s.database.StoredValues(ctx, r.DataflowID, seriesKeys); err != nil {
+			return refreshed, databaseError(err)
+		}
+	}
+	for _, o := range ds.Observations {
+		old, ok := stored[db.ObservationKey{SeriesKey: o.SeriesKey, Period: o.Period}]
+		switch {
+		case !ok:
+			refreshed.Added++
+		case old != o.Value:
+			refreshed.Revised = append(refreshed.Revised, Revision{SeriesKey: o.SeriesKey, Period: o.Period, Old: old, New: o.Value})
+		}
+	}
+	refreshed.Series = len(seriesKeys)
+	refreshed.Changed = len(ds.Observations)
+
+	r.RetrievedAt = asked
+	r.Observations += refreshed.Added
+	if len(ds.Dimensions) > 0 {
+		r.Dimensions, r.Attributes = ds.Dimensions, ds.Attributes
+	}
+	if err := s.database.StoreRetrieval(ctx, r, fetch.StoredObservations(r.DataflowID, ds.Observations)); err != nil {
+		return refreshed, databaseError(err)
+	}
+	refreshed.RetrievedAt = asked
+
+	for _, rev := range refreshed.Revised {
+		s.logger.InfoContext(ctx, "ABS revised an observation",
+			"dataflow", r.DataflowID, "series", rev.SeriesKey, "period", rev.Period, "old", rev.Old, "new", rev.New)
+	}
+	revisions.With(r.DataflowID).Add(float64(len(refreshed.Revised)))
+	s.logger.InfoContext(ctx, "Refreshed stored observations", "dataflow", r.DataflowID, "key", r.Key,
+		"changed", refreshed.Changed, "added", refreshed.Added, "revised", len(refreshed.Revised))
+	return refreshed, nil
+}
+
+// RefreshAll refreshes every stored query of a dataflow, or of every dataflow
+// when dataflowID is "". Queries another stored query covers are refreshed
+// along with it. It carries on past failures and returns the first.
+func (s *Source) RefreshAll(ctx context.Context, dataflowID string) ([]Refreshed, error) {
+	retrievals, err := s.database.Retrievals(ctx, dataflowID)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	var firstErr error
+	all := []Refreshed{}
+	for i, r := range retrievals {
+		if coveredByAnother(i, retrievals) {
+			continue
+		}
+		refreshed, err := s.Refresh(ctx, r)
+		if err != nil {
+			s.logger.WarnContext(ctx, "Failed to refresh stored observations", "dataflow", r.DataflowID, "key", r.Key, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		all = append(all, refreshed)
+	}
+	return all, firstErr
+}
+
+// coveredByAnother is true when another stored query's answer includes that
+// of retrievals[i], of two that cover each other the first listed is kept
+func coveredByAnother(i int, retrievals []db.Retrieval) bool {
+	for j, other := range retrievals {
+		if j == i || !Covers(other, retrievalQuery(retrievals[i])) {
+			continue
+		}
+		if j > i && Covers(retrievals[i], retrievalQuery(other)) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func retrievalQuery(r db.Retrieval) fetch.DataQuery {
+	return fetch.DataQuery{DataflowID: r.DataflowID, Key: r.Key, StartPeriod: r.StartPeriod, EndPeriod: r.EndPeriod}
+}
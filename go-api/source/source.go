@@ -0,0 +1,283 @@
+package source
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/db"
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+	"github.com/VooDooM1234/abs-visualiser/go-api/metrics"
+)
+
+// Mode is where a Source reads observations from
+type Mode string
+
+const (
+	// Database only serves stored observations, download jobs fill the store
+	Database Mode = "database"
+	// Live always asks the ABS and leaves the store alone
+	Live Mode = "live"
+	// Cache serves stored observations younger than Policy.MaxAge. Older ones
+	// are refreshed with what the ABS has changed since, a query that isn't
+	// stored is downloaded and stored for next time.
+	Cache Mode = "cache"
+)
+
+// Modes in the order they are documented
+var Modes = []Mode{Database, Live, Cache}
+
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown data source %q, expected database, live or cache", s)
+}
+
+// ErrNotStored is returned in Database mode when no download covers the query
+var ErrNotStored = errors.New("no stored observations cover the query")
+
+// ErrDatabase wraps failures to read or write the store, so they aren't
+// mistaken for the ABS failing
+var ErrDatabase = errors.New("database unavailable")
+
+func databaseError(err error) error {
+	return fmt.Errorf("%w: %w", ErrDatabase, err)
+}
+
+// Policy decides between the database and the ABS
+type Policy struct {
+	Mode Mode
+	// MaxAge is how old stored observations can be before Cache asks the ABS again
+	MaxAge time.Duration
+	// MaxStale is how old stored observations can be and still be served
+	// when the ABS can't be reached, 0 never serves them
+	MaxStale time.Duration
+}
+
+var reads = metrics.NewCounterVec(
+	"data_reads_total",
+	"Data queries answered by where the observations came from, refreshed is the database after fetching what the ABS changed, stale the database standing in for an unreachable ABS.",
+	"source",
+)
+
+// Source answers data queries from the database or the ABS by its Policy
+type Source struct {
+	abs      *fetch.Fetch
+	database *db.Database
+	policy   Policy
+	logger   *slog.Logger
+}
+
+func New(abs *fetch.Fetch, database *db.Database, policy Policy, logger *slog.Logger) *Source {
+	return &Source{abs: abs, database: database, policy: policy, logger: logger}
+}
+
+func (s *Source) Policy() Policy {
+	return s.policy
+}
+
+// Dataset answers query in mode, "" is the mode of the Policy. The dataset's
+// Source and RetrievedAt say where the observations came from and when the
+// ABS sent them.
+func (s *Source) Dataset(ctx context.Context, query fetch.DataQuery, mode Mode) (*fetch.Dataset, error) {
+	query.Key = cmp.Or(query.Key, "all")
+	mode = cmp.Or(mode, s.policy.Mode)
+	if mode == Live {
+		return s.live(ctx, query, false)
+	}
+
+	stored, err := s.Covering(ctx, query)
+	if err != nil {
+		if mode == Database {
+			return nil, err
+		}
+		// the ABS can still answer
+		s.logger.WarnContext(ctx, "Failed to look up stored observations", "dataflow", query.DataflowID, "err", err)
+	}
+	switch {
+	case mode == Database && stored == nil:
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotStored, query.DataflowID, query.Key)
+	case mode == Database, stored != nil && time.Since(stored.RetrievedAt) <= s.policy.MaxAge:
+		return s.read(ctx, query, *stored, fetch.SourceDatabase)
+	}
+
+	ds, err := s.update(ctx, query, stored)
+	if err == nil || stored == nil || s.policy.MaxStale <= 0 || time.Since(stored.RetrievedAt) > s.policy.MaxStale || rejected(err) {
+		return ds, err
+	}
+	s.logger.WarnContext(ctx, "ABS unavailable, serving stored observations",
+		"dataflow", query.DataflowID, "key", query.Key, "retrievedAt", stored.RetrievedAt, "err", err)
+	if stale, readErr := s.read(ctx, query, *stored, "stale"); readErr == nil {
+		stale.Source = fetch.SourceStale
+		return stale, nil
+	}
+	return nil, err
+}
+
+// update brings the answer to query up to date, refreshing the stored
+// answer when there is one and downloading all of it when there isn't
+func (s *Source) update(ctx context.Context, query fetch.DataQuery, stored *db.Retrieval) (*fetch.Dataset, error) {
+	if stored == nil {
+		return s.live(ctx, query, true)
+	}
+	refreshed, err := s.Refresh(ctx, *stored)
+	if err != nil {
+		return nil, err
+	}
+	r := *stored
+	r.RetrievedAt = refreshed.RetrievedAt
+	return s.read(ctx, query, r, "refreshed")
+}
+
+// live asks the ABS, store keeps the answer for next time
+func (s *Source) live(ctx context.Context, query fetch.DataQuery, store bool) (*fetch.Dataset, error) {
+	ds, err := s.abs.ABSRestDataset(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	reads.With(fetch.SourceABS).Inc()
+	if store {
+		// the data is good whether or not it could be kept
+		if err := s.Store(ctx, query, ds); err != nil {
+			s.logger.WarnContext(ctx, "Failed to store observations", "dataflow", query.DataflowID, "key", query.Key, "err", err)
+		}
+	}
+	return ds, nil
+}
+
+// Store saves what the ABS answered to query so it can be served from the
+// database and refreshed later
+func (s *Source) Store(ctx context.Context, query fetch.DataQuery, ds *fetch.Dataset) error {
+	if err := fetch.StoreDataset(ctx, s.database, query, ds); err != nil {
+		return databaseError(err)
+	}
+	return nil
+}
+
+// Covering finds the newest download whose answer includes query's, nil when
+// there isn't one
+func (s *Source) Covering(ctx context.Context, query fetch.DataQuery) (*db.Retrieval, error) {
+	retrievals, err := s.database.Retrievals(ctx, query.DataflowID)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	for _, r := range retrievals {
+		if Covers(r, query) {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// read answers query from the observations stored by r, label is the
+// data_reads_total source
+func (s *Source) read(ctx context.Context, query fetch.DataQuery, r db.Retrieval, label string) (*fetch.Dataset, error) {
+	stored, err := s.database.ReadObservations(ctx, query.DataflowID, keyPattern(query.Key))
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	ds := fetch.StoredDataset(r, stored)
+	ds.Key = query.Key
+	if query.StartPeriod != "" || query.EndPeriod != "" {
+		kept := ds.Observations[:0]
+		for _, o := range ds.Observations {
+			if query.InPeriods(o.Period) {
+				kept = append(kept, o)
+			}
+		}
+		ds.Observations = kept
+	}
+	reads.With(label).Inc()
+	return ds, nil
+}
+
+// Covers reports whether the download r returned every observation query asks for
+func Covers(r db.Retrieval, query fetch.DataQuery) bool {
+	if r.DataflowID != query.DataflowID || !keyCovers(r.Key, cmp.Or(query.Key, "all")) {
+		return false
+	}
+	if r.StartPeriod != "" {
+		have, _, _ := fetch.PeriodBounds(r.StartPeriod)
+		want, _, ok := fetch.PeriodBounds(query.StartPeriod)
+		if !ok || want.Before(have) {
+			return false
+		}
+	}
+	if r.EndPeriod != "" {
+		_, have, _ := fetch.PeriodBounds(r.EndPeriod)
+		_, want, ok := fetch.PeriodBounds(query.EndPeriod)
+		if !ok || want.After(have) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyCovers reports whether the SDMX key have selects every series want does.
+// Keys are dimension codes joined by dots, an empty part is any code and
+// A+B is either.
+func keyCovers(have, want string) bool {
+	if have == "all" {
+		return true
+	}
+	if want == "all" {
+		return false
+	}
+	haveParts, wantParts := strings.Split(have, "."), strings.Split(want, ".")
+	if len(haveParts) != len(wantParts) {
+		return false
+	}
+	for i, part := range haveParts {
+		if part == "" {
+			continue
+		}
+		if wantParts[i] == "" {
+			return false
+		}
+		codes := strings.Split(part, "+")
+		for _, code := range strings.Split(wantParts[i], "+") {
+			if !slices.Contains(codes, code) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keyPattern is the regular expression for the series keys an SDMX key
+// selects, "" for all of them
+func keyPattern(key string) string {
+	if key == "all" {
+		return ""
+	}
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = `[^.]*`
+			continue
+		}
+		codes := strings.Split(part, "+")
+		for j, code := range codes {
+			codes[j] = regexp.QuoteMeta(code)
+		}
+		parts[i] = "(" + strings.Join(codes, "|") + ")"
+	}
+	return "^" + strings.Join(parts, `\.`) + "$"
+}
+
+// rejected is an ABS answer about the query itself, the stored copy of a
+// query the ABS no longer accepts shouldn't hide that
+func rejected(err error) bool {
+	var statusErr *fetch.StatusError
+	return errors.As(err, &statusErr) && statusErr.Code < 500 && statusErr.Code != http.StatusTooManyRequests
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package supervisor
+
+import "os/exec"
+
+func configureCommand(cmd *exec.Cmd) {}
+
+// windows has no SIGTERM for console processes we don't share a console
+// group with, so stopping is a kill
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
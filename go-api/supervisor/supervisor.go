@@ -0,0 +1,303 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateStopped  State = "stopped"
+	StateStarting State = "starting"
+	StateRunning  State = "running"
+	StateBackoff  State = "backoff"
+)
+
+// Config describes the child process and how to look after it. Zero values
+// get the defaults from New.
+type Config struct {
+	// Name is added to every log line about the child, eg. "plotapp"
+	Name    string
+	Command string
+	Args    []string
+	Env     []string
+	Dir     string
+
+	// Addr is the host:port the child listens on. It is checked before each
+	// start so a stale process holding the port is reported, not fought with.
+	Addr string
+	// ReadyURL is polled until it returns 2xx, then every ProbeInterval
+	ReadyURL      string
+	ProbeInterval time.Duration
+	ReadyTimeout  time.Duration
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// a run longer than this resets the backoff
+	StableAfter time.Duration
+	// how long to wait after asking the child to stop before killing it
+	StopTimeout time.Duration
+
+	Logger *slog.Logger
+}
+
+// Status is a point in time view of the child for health checks
+type Status struct {
+	Name      string    `json:"name"`
+	State     State     `json:"state"`
+	PID       int       `json:"pid,omitempty"`
+	Ready     bool      `json:"ready"`
+	Restarts  int       `json:"restarts"`
+	StartedAt time.Time `json:"startedAt,omitzero"`
+	LastExit  string    `json:"lastExit,omitempty"`
+}
+
+// Supervisor starts a child process, restarts it with exponential backoff when
+// it exits and stops it when the context given to Run is cancelled.
+type Supervisor struct {
+	cfg    Config
+	logger *slog.Logger
+	client *http.Client
+
+	mu     sync.Mutex
+	status Status
+}
+
+func New(cfg Config) *Supervisor {
+	if cfg.ProbeInterval == 0 {
+		cfg.ProbeInterval = 10 * time.Second
+	}
+	if cfg.ReadyTimeout == 0 {
+		cfg.ReadyTimeout = 60 * time.Second
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.StableAfter == 0 {
+		cfg.StableAfter = time.Minute
+	}
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = 10 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Supervisor{
+		cfg:    cfg,
+		logger: cfg.Logger.With("service", cfg.Name),
+		client: &http.Client{Timeout: 2 * time.Second},
+		status: Status{Name: cfg.Name, State: StateStopped},
+	}
+}
+
+func (s *Supervisor) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Supervisor) update(fn func(*Status)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+}
+
+// Run keeps the child running until ctx is cancelled, then stops it and
+// returns once it has exited.
+func (s *Supervisor) Run(ctx context.Context) error {
+	backoff := s.cfg.MinBackoff
+	for {
+		started := time.Now()
+		err := s.runOnce(ctx)
+		if ctx.Err() != nil {
+			s.update(func(st *Status) {
+				st.State, st.PID, st.Ready = StateStopped, 0, false
+			})
+			return nil
+		}
+
+		if time.Since(started) > s.cfg.StableAfter {
+			backoff = s.cfg.MinBackoff
+		}
+		s.logger.Warn("Child exited, restarting", "err", err, "backoff", backoff)
+		s.update(func(st *Status) {
+			st.State, st.PID, st.Ready = StateBackoff, 0, false
+			st.LastExit = err.Error()
+		})
+
+		select {
+		case <-ctx.Done():
+			s.update(func(st *Status) { st.State = StateStopped })
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, s.cfg.MaxBackoff)
+		s.update(func(st *Status) { st.Restarts++ })
+	}
+}
+
+// runOnce starts the child and blocks until it exits or ctx is cancelled
+func (s *Supervisor) runOnce(ctx context.Context) error {
+	if s.cfg.Addr != "" {
+		if err := checkPortFree(s.cfg.Addr); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command(s.cfg.Command, s.cfg.Args...)
+	cmd.Env = append(os.Environ(), s.cfg.Env...)
+	cmd.Dir = s.cfg.Dir
+	cmd.Stdout = &lineWriter{logger: s.logger.With("stream", "stdout")}
+	cmd.Stderr = &lineWriter{logger: s.logger.With("stream", "stderr")}
+	// don't let a grandchild holding stdout open block Wait forever
+	cmd.WaitDelay = s.cfg.StopTimeout
+	configureCommand(cmd)
+
+	s.update(func(st *Status) { st.State, st.Ready = StateStarting, false })
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", s.cfg.Command, err)
+	}
+	s.logger.Info("Child started", "pid", cmd.Process.Pid)
+	s.update(func(st *Status) {
+		st.PID = cmd.Process.Pid
+		st.StartedAt = time.Now()
+	})
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	probeCtx, stopProbe := context.WithCancel(ctx)
+	defer stopProbe()
+	if s.cfg.ReadyURL != "" {
+		go s.probe(probeCtx)
+	} else {
+		s.update(func(st *Status) { st.State, st.Ready = StateRunning, true })
+	}
+
+	select {
+	case err := <-exited:
+		if err == nil {
+			err = errors.New("exited with status 0")
+		}
+		return err
+	case <-ctx.Done():
+		return s.stop(cmd, exited)
+	}
+}
+
+// stop asks the child to exit and kills it if it hasn't after StopTimeout
+func (s *Supervisor) stop(cmd *exec.Cmd, exited <-chan error) error {
+	s.logger.Info("Stopping child", "pid", cmd.Process.Pid)
+	if err := terminate(cmd); err != nil {
+		s.logger.Error("Failed to signal child", "err", err)
+	}
+
+	select {
+	case err := <-exited:
+		s.logger.Info("Child stopped")
+		return err
+	case <-time.After(s.cfg.StopTimeout):
+		s.logger.Warn("Child did not stop, killing it", "timeout", s.cfg.StopTimeout)
+		if err := kill(cmd); err != nil {
+			s.logger.Error("Failed to kill child", "err", err)
+		}
+		return <-exited
+	}
+}
+
+// probe polls ReadyURL quickly until the first success, then settles into
+// ProbeInterval so a hung child shows up as not ready
+func (s *Supervisor) probe(ctx context.Context) {
+	interval := 250 * time.Millisecond
+	deadline := time.Now().Add(s.cfg.ReadyTimeout)
+	everReady := false
+
+	for {
+		ready := s.Probe(ctx) == nil
+		if ctx.Err() != nil {
+			return
+		}
+		s.update(func(st *Status) {
+			st.Ready = ready
+			if ready {
+				st.State = StateRunning
+			}
+		})
+		if ready && !everReady {
+			everReady = true
+			interval = s.cfg.ProbeInterval
+			s.logger.Info("Child is ready", "url", s.cfg.ReadyURL)
+		}
+		if !everReady && time.Now().After(deadline) {
+			s.logger.Warn("Child not ready yet, still waiting", "timeout", s.cfg.ReadyTimeout)
+			deadline = time.Now().Add(s.cfg.ReadyTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Probe requests ReadyURL once, health checks use it for a live answer
+// rather than the last background probe
+func (s *Supervisor) Probe(ctx context.Context) error {
+	if s.cfg.ReadyURL == "" {
+		return errors.New("no ready url configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.ReadyURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", s.cfg.ReadyURL, resp.Status)
+	}
+	return nil
+}
+
+func checkPortFree(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%s is already in use, is an old instance still running? %w", addr, err)
+	}
+	return ln.Close()
+}
+
+// lineWriter forwards child output to the logger one line at a time
+type lineWriter struct {
+	logger *slog.Logger
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logger.Info(string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
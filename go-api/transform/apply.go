@@ -0,0 +1,53 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+// ErrUnknown is a transform name that isn't one of ours
+var ErrUnknown = errors.New("invalid transform")
+
+// Error is a transform that couldn't be applied to the data, eg. a series
+// too short to decompose
+type Error struct {
+	Transform string
+	Err       error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Check reports the first name that isn't a transform
+func Check(names []string) error {
+	for _, name := range names {
+		switch name {
+		case "", "seasonal":
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknown, name)
+		}
+	}
+	return nil
+}
+
+// Apply runs the named transforms over ds in order, opts are the settings
+// for seasonal
+func Apply(ds *fetch.Dataset, names []string, opts SeasonalOptions) error {
+	if err := Check(names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		switch name {
+		case "seasonal":
+			observations, err := DecomposeObservations(ds.Observations, opts)
+			if err != nil {
+				return &Error{Transform: name, Err: err}
+			}
+			ds.Observations = observations
+			ds.Dimensions = append(ds.Dimensions, ComponentDimension)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed arithmetic expression over named series such as "a / b * 100"
+type Expr interface {
+	Eval(vars map[string]float64) (float64, error)
+}
+
+type number float64
+
+type variable string
+
+type unary struct {
+	op byte
+	x  Expr
+}
+
+type binary struct {
+	op   byte
+	l, r Expr
+}
+
+func (n number) Eval(map[string]float64) (float64, error) { return float64(n), nil }
+
+func (v variable) Eval(vars map[string]float64) (float64, error) {
+	val, ok := vars[string(v)]
+	if !ok {
+		return 0, fmt.Errorf("undefined series: %s", string(v))
+	}
+	return val, nil
+}
+
+func (u unary) Eval(vars map[string]float64) (float64, error) {
+	x, err := u.x.Eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	return -x, nil
+}
+
+func (b binary) Eval(vars map[string]float64) (float64, error) {
+	l, err := b.l.Eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.r.Eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	case '/':
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", b.op)
+}
+
+// Variables lists the series names an expression refers to
+func Variables(e Expr) []string {
+	seen := make(map[string]bool)
+	var names []string
+	var walk func(Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case variable:
+			if !seen[string(e)] {
+				seen[string(e)] = true
+				names = append(names, string(e))
+			}
+		case unary:
+			walk(e.x)
+		case binary:
+			walk(e.l)
+			walk(e.r)
+		}
+	}
+	walk(e)
+	return names
+}
+
+const (
+	// MaxExprLength is the longest expression ParseExpr accepts, in bytes
+	MaxExprLength = 1024
+	// maxExprDepth caps nested parentheses and minus signs, each is a level
+	// of recursion when parsing and evaluating
+	maxExprDepth = 32
+)
+
+// ParseExpr parses + - * / expressions with parentheses, numbers and series
+// names made of letters, digits and underscores.
+func ParseExpr(s string) (Expr, error) {
+	if len(s) > MaxExprLength {
+		return nil, fmt.Errorf("expression is longer than %d bytes", MaxExprLength)
+	}
+	p := &exprParser{src: s}
+	p.next()
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tok, p.pos)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src   string
+	off   int
+	pos   int
+	tok   string
+	depth int
+}
+
+// nest goes a level deeper for the parenthesis or minus sign at pos, the
+// caller undoes it with unnest once the level is parsed
+func (p *exprParser) nest(pos int) error {
+	p.depth++
+	if p.depth > maxExprDepth {
+		return fmt.Errorf("expression nests deeper than %d levels at position %d", maxExprDepth, pos)
+	}
+	return nil
+}
+
+func (p *exprParser) unnest() {
+	p.depth--
+}
+
+func (p *exprParser) next() {
+	for p.off < len(p.src) && p.src[p.off] == ' ' {
+		p.off++
+	}
+	p.pos = p.off
+	if p.off >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	c := rune(p.src[p.off])
+	switch {
+	case strings.ContainsRune("+-*/()", c):
+		p.tok = string(c)
+		p.off++
+	case unicode.IsDigit(c) || c == '.':
+		end := p.off
+		for end < len(p.src) && (unicode.IsDigit(rune(p.src[end])) || p.src[end] == '.') {
+			end++
+		}
+		p.tok = p.src[p.off:end]
+		p.off = end
+	case unicode.IsLetter(c) || c == '_':
+		end := p.off
+		for end < len(p.src) && (unicode.IsLetter(rune(p.src[end])) || unicode.IsDigit(rune(p.src[end])) || p.src[end] == '_') {
+			end++
+		}
+		p.tok = p.src[p.off:end]
+		p.off = end
+	default:
+		p.tok = string(c)
+		p.off++
+	}
+}
+
+func (p *exprParser) parseSum() (Expr, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseProduct() (Expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok[0]
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	if p.tok == "-" {
+		if err := p.nest(p.pos); err != nil {
+			return nil, err
+		}
+		defer p.unnest()
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: '-', x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	tok, pos := p.tok, p.pos
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		if err := p.nest(pos); err != nil {
+			return nil, err
+		}
+		defer p.unnest()
+		p.next()
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos)
+		}
+		p.next()
+		return e, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok, pos)
+		}
+		p.next()
+		return number(f), nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		return variable(tok), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok, pos)
+}
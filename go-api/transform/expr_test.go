@@ -0,0 +1,54 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	vars := map[string]float64{"a": 10, "b": 4, "cpi_2": 2}
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"a + b * 2", 18},
+		{"(a + b) * 2", 28},
+		{"a / b / cpi_2", 1.25},
+		{"-a - -b", -6},
+		{"--a", 10},
+		{strings.Repeat("(", maxExprDepth) + "a" + strings.Repeat(")", maxExprDepth), 10},
+		{strings.Repeat("-", maxExprDepth) + "a", 10},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%.20q): %v", tt.expr, err)
+			continue
+		}
+		if got, err := e.Eval(vars); err != nil || got != tt.want {
+			t.Errorf("%.20q = %v, %v, want %v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"a +", "unexpected end of expression"},
+		{"(a + b", "missing ) at position 6"},
+		{"a $ b", `unexpected "$" at position 2`},
+		{"1.2.3", `invalid number "1.2.3" at position 0`},
+		{strings.Repeat("a+", MaxExprLength/2) + "a", "expression is longer than 1024 bytes"},
+		{strings.Repeat("(", maxExprDepth+1) + "a" + strings.Repeat(")", maxExprDepth+1), "expression nests deeper than 32 levels at position 32"},
+		{"a*" + strings.Repeat("-", maxExprDepth+1) + "a", "expression nests deeper than 32 levels at position 34"},
+		{strings.Repeat("(-", maxExprDepth/2) + "(a", "expression nests deeper than 32 levels at position 32"},
+	}
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.expr)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("ParseExpr(%.20q) error %v, want %s", tt.expr, err, tt.want)
+		}
+	}
+}
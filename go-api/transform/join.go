@@ -0,0 +1,167 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+type JoinType string
+
+const (
+	InnerJoin JoinType = "inner"
+	OuterJoin JoinType = "outer"
+)
+
+// DerivedDimension holds the name of a derived series in the observation model
+const DerivedDimension = "DERIVED"
+
+// ErrOuterJoinFill is returned for an outer join without a fill value, the
+// periods missing an operand would otherwise be dropped like an inner join
+var ErrOuterJoinFill = errors.New("an outer join needs a fill value for missing operands")
+
+func ParseJoinType(s string) (JoinType, error) {
+	switch JoinType(s) {
+	case "", InnerJoin:
+		return InnerJoin, nil
+	case OuterJoin:
+		return OuterJoin, nil
+	}
+	return "", fmt.Errorf("invalid join type: %s", s)
+}
+
+// DeriveOptions controls Derive. On lists dimension IDs that must match as
+// well as the period, eg. REGION when combining state level series. Fill is
+// used for a missing operand in an outer join and is required for one.
+type DeriveOptions struct {
+	Expression string
+	Join       JoinType
+	On         []string
+	Fill       *float64
+	Name       string
+}
+
+type joinKey struct {
+	period string
+	on     string
+}
+
+// Derive aligns the named series by period (and the On dimensions) and
+// evaluates the expression for every aligned row. Each name must resolve to
+// one series per join key, otherwise the alignment would be ambiguous. A
+// period the expression can't be evaluated for, eg. dividing by zero, is an
+// *Error rather than a gap in the result.
+func Derive(series map[string][]fetch.Observation, opts DeriveOptions) ([]fetch.Observation, error) {
+	if opts.Join == OuterJoin && opts.Fill == nil {
+		return nil, ErrOuterJoinFill
+	}
+	expr, err := ParseExpr(opts.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("parsing expression: %w", err)
+	}
+	names := Variables(expr)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("expression does not reference any series")
+	}
+
+	type joined struct {
+		values map[string]float64
+		sample fetch.Observation
+	}
+	rows := make(map[joinKey]*joined)
+	for _, name := range names {
+		observations, ok := series[name]
+		if !ok {
+			return nil, fmt.Errorf("expression references undefined series: %s", name)
+		}
+		seen := make(map[joinKey]string)
+		for _, obs := range observations {
+			k := joinKey{period: obs.Period, on: onKey(obs, opts.On)}
+			if other, dup := seen[k]; dup {
+				return nil, fmt.Errorf("series %s is ambiguous: %s and %s both have period %s, narrow the key or join on more dimensions", name, other, obs.SeriesKey, obs.Period)
+			}
+			seen[k] = obs.SeriesKey
+
+			row, ok := rows[k]
+			if !ok {
+				row = &joined{values: make(map[string]float64, len(names)), sample: obs}
+				rows[k] = row
+			}
+			row.values[name] = obs.Value
+		}
+	}
+
+	keys := make([]joinKey, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].on != keys[j].on {
+			return keys[i].on < keys[j].on
+		}
+		return keys[i].period < keys[j].period
+	})
+
+	name := opts.Name
+	if name == "" {
+		name = opts.Expression
+	}
+
+	var out []fetch.Observation
+	for _, k := range keys {
+		row := rows[k]
+		if len(row.values) < len(names) {
+			if opts.Join != OuterJoin {
+				continue
+			}
+			for _, n := range names {
+				if _, ok := row.values[n]; !ok {
+					row.values[n] = *opts.Fill
+				}
+			}
+		}
+
+		val, err := expr.Eval(row.values)
+		if err != nil {
+			at := "period " + k.period
+			if k.on != "" {
+				at += " of " + k.on
+			}
+			return nil, &Error{Transform: "derive", Err: fmt.Errorf("%s: %w", at, err)}
+		}
+		out = append(out, derivedObservation(row.sample, opts.On, name, k.period, val))
+	}
+	return out, nil
+}
+
+func onKey(obs fetch.Observation, on []string) string {
+	parts := make([]string, len(on))
+	for i, id := range on {
+		parts[i] = obs.Dimensions[id]
+	}
+	return strings.Join(parts, ".")
+}
+
+func derivedObservation(sample fetch.Observation, on []string, name, period string, value float64) fetch.Observation {
+	obs := fetch.Observation{
+		Period:     period,
+		Value:      value,
+		Dimensions: map[string]string{DerivedDimension: name},
+		Labels:     map[string]string{DerivedDimension: name},
+	}
+	keyParts := make([]string, 0, len(on)+1)
+	for _, id := range on {
+		obs.Dimensions[id] = sample.Dimensions[id]
+		if label, ok := sample.Labels[id]; ok {
+			obs.Labels[id] = label
+		}
+		keyParts = append(keyParts, sample.Dimensions[id])
+	}
+	obs.SeriesKey = strings.Join(append(keyParts, name), ".")
+	obs.Region = obs.Labels["REGION"]
+	obs.Measure = name
+	return obs
+}
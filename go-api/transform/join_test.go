@@ -0,0 +1,112 @@
+package transform
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+func TestDerive(t *testing.T) {
+	obs := func(key, period string, value float64) fetch.Observation {
+		return fetch.Observation{SeriesKey: key, Period: period, Value: value, Dimensions: map[string]string{"REGION": key}}
+	}
+	zero := 0.0
+	series := map[string][]fetch.Observation{
+		"a": {obs("A", "2020-Q1", 10), obs("A", "2020-Q2", 20), obs("A", "2020-Q3", 30)},
+		"b": {obs("B", "2020-Q1", 2), obs("B", "2020-Q2", 4)},
+	}
+	tests := []struct {
+		name   string
+		series map[string][]fetch.Observation
+		opts   DeriveOptions
+		want   map[string]float64
+		// wantErr is a substring of the error, "" when it should derive
+		wantErr string
+		// wantTransformErr is whether the error should be an *Error
+		wantTransformErr bool
+	}{
+		{
+			name:   "inner join keeps matched periods",
+			series: series,
+			opts:   DeriveOptions{Expression: "a / b"},
+			want:   map[string]float64{"2020-Q1": 5, "2020-Q2": 5},
+		},
+		{
+			name:   "outer join fills missing operands",
+			series: series,
+			opts:   DeriveOptions{Expression: "a + b", Join: OuterJoin, Fill: &zero},
+			want:   map[string]float64{"2020-Q1": 12, "2020-Q2": 24, "2020-Q3": 30},
+		},
+		{
+			name:    "outer join without fill",
+			series:  series,
+			opts:    DeriveOptions{Expression: "a + b", Join: OuterJoin},
+			wantErr: ErrOuterJoinFill.Error(),
+		},
+		{
+			name:             "division by a filled zero",
+			series:           series,
+			opts:             DeriveOptions{Expression: "a / b", Join: OuterJoin, Fill: &zero},
+			wantErr:          "period 2020-Q3: division by zero",
+			wantTransformErr: true,
+		},
+		{
+			name: "division by a zero value",
+			series: map[string][]fetch.Observation{
+				"a": {obs("A", "2020-Q1", 10)},
+				"b": {obs("B", "2020-Q1", 0)},
+			},
+			opts:             DeriveOptions{Expression: "a / b"},
+			wantErr:          "period 2020-Q1: division by zero",
+			wantTransformErr: true,
+		},
+		{
+			name: "division by zero names the join dimensions",
+			series: map[string][]fetch.Observation{
+				"a": {obs("NSW", "2020-Q1", 10)},
+				"b": {obs("NSW", "2020-Q1", 0)},
+			},
+			opts:             DeriveOptions{Expression: "a / b", On: []string{"REGION"}},
+			wantErr:          "period 2020-Q1 of NSW: division by zero",
+			wantTransformErr: true,
+		},
+		{
+			name:    "undefined series",
+			series:  series,
+			opts:    DeriveOptions{Expression: "a / c"},
+			wantErr: "undefined series: c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Derive(tt.series, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+				}
+				var transformErr *Error
+				if got := errors.As(err, &transformErr); got != tt.wantTransformErr {
+					t.Errorf("error is a *Error %v, want %v", got, tt.wantTransformErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make(map[string]float64, len(out))
+			for _, o := range out {
+				got[o.Period] = o.Value
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for period, v := range tt.want {
+				if got[period] != v {
+					t.Errorf("%s = %v, want %v", period, got[period], v)
+				}
+			}
+		})
+	}
+}
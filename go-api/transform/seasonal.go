@@ -0,0 +1,306 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+// Model is how the seasonal component combines with the trend
+type Model string
+
+const (
+	Additive       Model = "additive"
+	Multiplicative Model = "multiplicative"
+)
+
+// Component names used in the COMPONENT dimension of decomposed observations
+const (
+	ComponentTrend    = "trend"
+	ComponentSeasonal = "seasonal"
+	ComponentResidual = "residual"
+	ComponentAdjusted = "adjusted"
+
+	ComponentDimension = "COMPONENT"
+)
+
+// TSEST codes used by the ABS for the adjustment type dimension
+const (
+	TSESTOriginal           = "10"
+	TSESTSeasonallyAdjusted = "20"
+	TSESTTrend              = "30"
+)
+
+// Decomposition holds the components of a classical seasonal decomposition.
+// Trend and Residual are NaN for the half window at each end of the series
+// where the centred moving average is undefined.
+type Decomposition struct {
+	Model    Model
+	Period   int
+	Observed []float64
+	Trend    []float64
+	Seasonal []float64
+	Residual []float64
+	Adjusted []float64
+}
+
+func ParseModel(s string) (Model, error) {
+	switch Model(s) {
+	case "", Additive:
+		return Additive, nil
+	case Multiplicative:
+		return Multiplicative, nil
+	}
+	return "", fmt.Errorf("invalid decomposition model: %s", s)
+}
+
+// PeriodForFrequency returns the number of observations per year for an ABS
+// FREQ code, or 0 when the frequency has no seasonal cycle we can remove.
+func PeriodForFrequency(freq string) int {
+	switch freq {
+	case "M":
+		return 12
+	case "Q":
+		return 4
+	case "H", "S":
+		return 2
+	}
+	return 0
+}
+
+// Decompose splits a regularly spaced series into trend, seasonal and residual
+// components using a centred moving average of length period.
+// https://otexts.com/fpp3/classical-decomposition.html
+func Decompose(values []float64, period int, model Model) (*Decomposition, error) {
+	if period < 2 {
+		return nil, fmt.Errorf("seasonal period must be at least 2, got %d", period)
+	}
+	if len(values) < 2*period {
+		return nil, fmt.Errorf("need at least %d observations for period %d, got %d", 2*period, period, len(values))
+	}
+	if model == Multiplicative {
+		for _, v := range values {
+			if v <= 0 {
+				return nil, fmt.Errorf("multiplicative decomposition needs positive values")
+			}
+		}
+	}
+
+	n := len(values)
+	trend := centredMovingAverage(values, period)
+
+	// average the detrended values at each position in the cycle
+	sums := make([]float64, period)
+	counts := make([]int, period)
+	for i, v := range values {
+		if math.IsNaN(trend[i]) {
+			continue
+		}
+		if model == Multiplicative {
+			sums[i%period] += v / trend[i]
+		} else {
+			sums[i%period] += v - trend[i]
+		}
+		counts[i%period]++
+	}
+
+	indices := make([]float64, period)
+	var total float64
+	for i := range indices {
+		indices[i] = sums[i] / float64(counts[i])
+		total += indices[i]
+	}
+	// normalise so the seasonal effects cancel out over a year
+	mean := total / float64(period)
+	for i := range indices {
+		if model == Multiplicative {
+			indices[i] /= mean
+		} else {
+			indices[i] -= mean
+		}
+	}
+
+	d := &Decomposition{
+		Model:    model,
+		Period:   period,
+		Observed: values,
+		Trend:    trend,
+		Seasonal: make([]float64, n),
+		Residual: make([]float64, n),
+		Adjusted: make([]float64, n),
+	}
+	for i, v := range values {
+		s := indices[i%period]
+		d.Seasonal[i] = s
+		if model == Multiplicative {
+			d.Adjusted[i] = v / s
+			d.Residual[i] = v / (trend[i] * s)
+		} else {
+			d.Adjusted[i] = v - s
+			d.Residual[i] = v - trend[i] - s
+		}
+	}
+	return d, nil
+}
+
+// centredMovingAverage uses a 2xm moving average for even periods so the
+// result lines up with the observations
+func centredMovingAverage(values []float64, period int) []float64 {
+	n := len(values)
+	out := make([]float64, n)
+	half := period / 2
+	for i := range out {
+		if i-half < 0 || i+half >= n {
+			out[i] = math.NaN()
+			continue
+		}
+		var sum float64
+		if period%2 == 1 {
+			for j := i - half; j <= i+half; j++ {
+				sum += values[j]
+			}
+			out[i] = sum / float64(period)
+			continue
+		}
+		sum = 0.5*values[i-half] + 0.5*values[i+half]
+		for j := i - half + 1; j < i+half; j++ {
+			sum += values[j]
+		}
+		out[i] = sum / float64(period)
+	}
+	return out
+}
+
+// SeasonalOptions controls DecomposeObservations. A zero Period means the
+// period is taken from each series' FREQ dimension.
+type SeasonalOptions struct {
+	Model  Model
+	Period int
+}
+
+// DecomposeObservations groups observations by series and decomposes every
+// series that the ABS publishes as an original (unadjusted) estimate. The
+// result is in the observation model with a COMPONENT dimension added, so it
+// can be served and plotted like any other series.
+func DecomposeObservations(observations []fetch.Observation, opts SeasonalOptions) ([]fetch.Observation, error) {
+	var out []fetch.Observation
+	for _, series := range GroupSeries(observations) {
+		first := series[0]
+		if tsest, ok := first.Dimensions["TSEST"]; ok && tsest != TSESTOriginal {
+			continue
+		}
+
+		period := opts.Period
+		if period == 0 {
+			period = PeriodForFrequency(first.Dimensions["FREQ"])
+		}
+		if period == 0 {
+			return nil, fmt.Errorf("series %s: cannot work out seasonal period from frequency %q", first.SeriesKey, first.Dimensions["FREQ"])
+		}
+
+		if err := checkContiguous(series); err != nil {
+			return nil, fmt.Errorf("series %s: %w", first.SeriesKey, err)
+		}
+		values := make([]float64, len(series))
+		for i, obs := range series {
+			values[i] = obs.Value
+		}
+		d, err := Decompose(values, period, opts.Model)
+		if err != nil {
+			return nil, fmt.Errorf("series %s: %w", first.SeriesKey, err)
+		}
+
+		components := []struct {
+			name   string
+			values []float64
+		}{
+			{ComponentTrend, d.Trend},
+			{ComponentSeasonal, d.Seasonal},
+			{ComponentResidual, d.Residual},
+			{ComponentAdjusted, d.Adjusted},
+		}
+		for _, c := range components {
+			for i, obs := range series {
+				if math.IsNaN(c.values[i]) {
+					continue
+				}
+				out = append(out, withComponent(obs, c.name, c.values[i]))
+			}
+		}
+	}
+	return out, nil
+}
+
+// checkContiguous makes sure a series sorted by period has one observation
+// for every period from the first to the last, the cycle position in
+// Decompose is counted in observations
+func checkContiguous(series []fetch.Observation) error {
+	var prevEnd time.Time
+	for i, obs := range series {
+		start, end, ok := fetch.PeriodBounds(obs.Period)
+		if !ok {
+			return fmt.Errorf("cannot decompose, unrecognised period %q", obs.Period)
+		}
+		if i > 0 && !start.Equal(prevEnd) {
+			return fmt.Errorf("cannot decompose, periods %s and %s are not consecutive", series[i-1].Period, obs.Period)
+		}
+		prevEnd = end
+	}
+	return nil
+}
+
+// GroupSeries splits observations by SeriesKey, each series sorted by period.
+// Series are returned in the order they first appear.
+func GroupSeries(observations []fetch.Observation) [][]fetch.Observation {
+	index := make(map[string]int)
+	var groups [][]fetch.Observation
+	for _, obs := range observations {
+		i, ok := index[obs.SeriesKey]
+		if !ok {
+			i = len(groups)
+			index[obs.SeriesKey] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], obs)
+	}
+	for _, g := range groups {
+		sort.SliceStable(g, func(a, b int) bool { return g[a].Period < g[b].Period })
+	}
+	return groups
+}
+
+func withComponent(obs fetch.Observation, component string, value float64) fetch.Observation {
+	dims := make(map[string]string, len(obs.Dimensions)+1)
+	for k, v := range obs.Dimensions {
+		dims[k] = v
+	}
+	dims[ComponentDimension] = component
+
+	labels := make(map[string]string, len(obs.Labels)+1)
+	for k, v := range obs.Labels {
+		labels[k] = v
+	}
+	labels[ComponentDimension] = component
+
+	obs.Value = value
+	obs.Dimensions = dims
+	obs.Labels = labels
+	obs.SeriesKey = obs.SeriesKey + "." + component
+	return obs
+}
+
+// ParsePeriod reads an optional seasonal period query parameter
+func ParsePeriod(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 2 {
+		return 0, fmt.Errorf("invalid seasonal period: %s", s)
+	}
+	return p, nil
+}
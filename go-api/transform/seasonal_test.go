@@ -0,0 +1,202 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/fetch"
+)
+
+const testSeriesKey = "1.10001.10.50.Q"
+
+// quarters is a quarterly series with an observation for each period given
+func quarters(periods ...string) []fetch.Observation {
+	obs := make([]fetch.Observation, len(periods))
+	for i, p := range periods {
+		obs[i] = fetch.Observation{
+			Period:     p,
+			Value:      float64(100 + i%4),
+			SeriesKey:  testSeriesKey,
+			Dimensions: map[string]string{"FREQ": "Q", "TSEST": TSESTOriginal},
+		}
+	}
+	return obs
+}
+
+// quarterly is an original series from 2019-Q1 with the values given
+func quarterly(values ...float64) []fetch.Observation {
+	periods := make([]string, len(values))
+	for i := range values {
+		periods[i] = fmt.Sprintf("%d-Q%d", 2019+i/4, i%4+1)
+	}
+	obs := quarters(periods...)
+	for i, v := range values {
+		obs[i].Value = v
+	}
+	return obs
+}
+
+// components maps component then period to the decomposed value
+func components(t *testing.T, obs []fetch.Observation) map[string]map[string]float64 {
+	t.Helper()
+	out := make(map[string]map[string]float64)
+	for _, o := range obs {
+		c := o.Dimensions[ComponentDimension]
+		if o.SeriesKey != testSeriesKey+"."+c || o.Labels[ComponentDimension] != c {
+			t.Errorf("%s %s has series key %s and label %q", c, o.Period, o.SeriesKey, o.Labels[ComponentDimension])
+		}
+		if out[c] == nil {
+			out[c] = make(map[string]float64)
+		}
+		out[c][o.Period] = o.Value
+	}
+	return out
+}
+
+func checkComponent(t *testing.T, got map[string]float64, name string, want map[string]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s has %d periods, want %d", name, len(got), len(want))
+	}
+	for period, w := range want {
+		if g, ok := got[period]; !ok || math.Abs(g-w) > 1e-9 {
+			t.Errorf("%s %s = %v, want %v", name, period, g, w)
+		}
+	}
+}
+
+// A linear trend plus a seasonal pattern that sums to zero over the year is
+// recovered exactly, except the trend is undefined for the half year at
+// each end
+func TestDecomposeObservationsAdditive(t *testing.T) {
+	seasonal := []float64{2, -1, -3, 2}
+	values := make([]float64, 12)
+	for i := range values {
+		values[i] = 100 + float64(i) + seasonal[i%4]
+	}
+	obs := quarterly(values...)
+	// the order observations arrive in doesn't matter
+	obs[0], obs[5] = obs[5], obs[0]
+
+	out, err := DecomposeObservations(obs, SeasonalOptions{Model: Additive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := components(t, out)
+	trend, season, residual, adjusted := map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}
+	for i := range values {
+		period := fmt.Sprintf("%d-Q%d", 2019+i/4, i%4+1)
+		season[period] = seasonal[i%4]
+		adjusted[period] = 100 + float64(i)
+		if i >= 2 && i < len(values)-2 {
+			trend[period] = 100 + float64(i)
+			residual[period] = 0
+		}
+	}
+	checkComponent(t, got[ComponentTrend], ComponentTrend, trend)
+	checkComponent(t, got[ComponentSeasonal], ComponentSeasonal, season)
+	checkComponent(t, got[ComponentResidual], ComponentResidual, residual)
+	checkComponent(t, got[ComponentAdjusted], ComponentAdjusted, adjusted)
+}
+
+// Seasonal factors averaging one on a flat series come back as the factors,
+// with residuals of one
+func TestDecomposeObservationsMultiplicative(t *testing.T) {
+	factors := []float64{1.1, 0.9, 0.95, 1.05}
+	values := make([]float64, 8)
+	for i := range values {
+		values[i] = 200 * factors[i%4]
+	}
+
+	out, err := DecomposeObservations(quarterly(values...), SeasonalOptions{Model: Multiplicative, Period: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := components(t, out)
+	trend, season, residual, adjusted := map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}
+	for i := range values {
+		period := fmt.Sprintf("%d-Q%d", 2019+i/4, i%4+1)
+		season[period] = factors[i%4]
+		adjusted[period] = 200
+		if i >= 2 && i < len(values)-2 {
+			trend[period] = 200
+			residual[period] = 1
+		}
+	}
+	checkComponent(t, got[ComponentTrend], ComponentTrend, trend)
+	checkComponent(t, got[ComponentSeasonal], ComponentSeasonal, season)
+	checkComponent(t, got[ComponentResidual], ComponentResidual, residual)
+	checkComponent(t, got[ComponentAdjusted], ComponentAdjusted, adjusted)
+}
+
+// Series the ABS has already adjusted aren't decomposed again
+func TestDecomposeObservationsSkipsAdjustedSeries(t *testing.T) {
+	obs := quarterly(1, 2, 3, 4, 5, 6, 7, 8)
+	for i := range obs {
+		obs[i].Dimensions["TSEST"] = TSESTSeasonallyAdjusted
+	}
+	out, err := DecomposeObservations(obs, SeasonalOptions{Model: Additive})
+	if err != nil || len(out) != 0 {
+		t.Errorf("got %d observations and error %v, want none", len(out), err)
+	}
+}
+
+func TestDecomposeObservationsErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		observations []fetch.Observation
+		opts         SeasonalOptions
+		want         string
+	}{
+		{
+			name:         "missing quarter",
+			observations: quarters("2019-Q1", "2019-Q2", "2019-Q4", "2020-Q1", "2020-Q2", "2020-Q3", "2020-Q4", "2021-Q1"),
+			want:         "series 1.10001.10.50.Q: cannot decompose, periods 2019-Q2 and 2019-Q4 are not consecutive",
+		},
+		{
+			name:         "missing year",
+			observations: quarters("2018-Q1", "2018-Q2", "2018-Q3", "2018-Q4", "2020-Q1", "2020-Q2", "2020-Q3", "2020-Q4"),
+			want:         "series 1.10001.10.50.Q: cannot decompose, periods 2018-Q4 and 2020-Q1 are not consecutive",
+		},
+		{
+			name:         "repeated quarter",
+			observations: quarters("2019-Q1", "2019-Q2", "2019-Q2", "2019-Q3", "2019-Q4", "2020-Q1", "2020-Q2", "2020-Q3"),
+			want:         "series 1.10001.10.50.Q: cannot decompose, periods 2019-Q2 and 2019-Q2 are not consecutive",
+		},
+		{
+			name:         "unrecognised period",
+			observations: quarters("2019-Q1", "2019-Q2", "2019-Q3", "2019-Q4", "2020-Q1", "2020-Q2", "2020-Q3", "2020 Q4"),
+			want:         `series 1.10001.10.50.Q: cannot decompose, unrecognised period "2020 Q4"`,
+		},
+		{
+			name:         "under two years",
+			observations: quarterly(1, 2, 3, 4, 5, 6, 7),
+			want:         "series 1.10001.10.50.Q: need at least 8 observations for period 4, got 7",
+		},
+		{
+			name:         "multiplicative with a zero",
+			observations: quarterly(1, 2, 0, 4, 5, 6, 7, 8),
+			opts:         SeasonalOptions{Model: Multiplicative},
+			want:         "series 1.10001.10.50.Q: multiplicative decomposition needs positive values",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecomposeObservations(tt.observations, tt.opts)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyReportsGapsAsTransformErrors(t *testing.T) {
+	ds := &fetch.Dataset{Observations: quarters("2019-Q1", "2019-Q3", "2019-Q4", "2020-Q1", "2020-Q2", "2020-Q3", "2020-Q4", "2021-Q1")}
+	err := Apply(ds, []string{"seasonal"}, SeasonalOptions{Model: Additive})
+	var transformErr *Error
+	if !errors.As(err, &transformErr) || transformErr.Transform != "seasonal" {
+		t.Errorf("error %v, want a seasonal *Error", err)
+	}
+}
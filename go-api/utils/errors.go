@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net/http"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+)
+
+// Error codes, the status says what kind of failure it is, the code says
+// which one for clients that want to branch on it
+const (
+	CodeBadRequest          = "bad_request"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodeUnprocessable       = "unprocessable"
+	CodeInternal            = "internal"
+	CodeUpstreamError       = "upstream_error"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamTimeout     = "upstream_timeout"
+)
+
+// Error is the body of every JSON error response, wrapped as {"error": ...}
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// CodeForStatus is the code used when a handler has nothing more specific
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	case http.StatusServiceUnavailable:
+		return CodeUpstreamUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	}
+	return CodeInternal
+}
+
+// EncodeError writes the error envelope with the request id of r
+func EncodeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details any) error {
+	return Encode(w, status, ErrorResponse{Error{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: middleware.RequestIDFromContext(r.Context()),
+	}})
+}
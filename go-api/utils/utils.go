@@ -2,11 +2,10 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"os/exec"
 )
 
 func Decode[T any](r *http.Request) (T, error) {
@@ -26,35 +25,26 @@ func Encode[T any](w http.ResponseWriter, status int, v T) error {
 	return nil
 }
 
-// STOP STEALIN MY PORTS
-func KillPort(port string) error {
-	psCommand := fmt.Sprintf(`$connections = Get-NetTCPConnection -LocalPort %s -ErrorAction SilentlyContinue;
-		foreach ($c in $connections) {
-			Stop-Process -Id $c.OwningProcess -Force -ErrorAction SilentlyContinue
-		}`, port)
-
-	cmd := exec.Command("powershell", "-Command", psCommand)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
-}
-
-// generic check for mservice response of {"status": "success/fail"}
-func CheckFailureResponse(body []byte, logger *log.Logger) error {
-	var genericResp map[string]interface{}
-	if err := json.Unmarshal(body, &genericResp); err != nil {
-		logger.Printf("Failed to parse JSON: %v", err)
+// generic check for mservice response of {"status": "success/fail"}, a
+// response that isn't an object, eg. a list of records, can't be a failure
+func CheckFailureResponse(body []byte, logger *slog.Logger) error {
+	var resp any
+	if err := json.Unmarshal(body, &resp); err != nil {
+		logger.Error("Failed to parse JSON", "err", err)
 		return fmt.Errorf("invalid response format")
 	}
+	genericResp, ok := resp.(map[string]any)
+	if !ok {
+		return nil
+	}
 
 	if status, ok := genericResp["status"]; ok && status == "failed" {
 		message := "Unknown error"
 		if msg, exists := genericResp["message"]; exists {
 			message = fmt.Sprintf("%v", msg)
 		}
-		logger.Printf("Backend returned failure: %s", message)
-		return fmt.Errorf(message)
+		logger.Error("Backend returned failure", "message", message)
+		return errors.New(message)
 	}
 
 	return nil
@@ -0,0 +1,195 @@
+package views
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VooDooM1234/abs-visualiser/go-api/middleware"
+)
+
+// Directories under the template root whose files are parsed into every page
+const (
+	LayoutDir  = "layouts"
+	PartialDir = "partials"
+)
+
+// Registry parses every page once with the shared layouts and partials. In
+// dev mode Watch reparses them when a file changes, a broken edit keeps the
+// last good templates and the error is shown on every render until fixed.
+type Registry struct {
+	fsys   fs.FS
+	funcs  template.FuncMap
+	dev    bool
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	pages    map[string]*template.Template
+	parseErr error
+}
+
+// New parses the templates in fsys, either the embedded copy or a directory
+// on disk. An error here means a template is broken and the server shouldn't
+// start. funcs are available to every template.
+func New(fsys fs.FS, funcs template.FuncMap, dev bool, logger *slog.Logger) (*Registry, error) {
+	reg := &Registry{fsys: fsys, funcs: funcs, dev: dev, logger: logger}
+	pages, err := parse(fsys, funcs)
+	if err != nil {
+		return nil, err
+	}
+	reg.pages = pages
+	return reg, nil
+}
+
+func parse(fsys fs.FS, funcs template.FuncMap) (map[string]*template.Template, error) {
+	var shared []string
+	for _, dir := range []string{LayoutDir, PartialDir} {
+		files, err := fs.Glob(fsys, dir+"/*.html")
+		if err != nil {
+			return nil, err
+		}
+		shared = append(shared, files...)
+	}
+
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no templates found")
+	}
+
+	pages := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		tmpl, err := template.New(file).Funcs(funcs).ParseFS(fsys, append([]string{file}, shared...)...)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s: %w", file, err)
+		}
+		pages[file] = tmpl
+	}
+	return pages, nil
+}
+
+// Render executes the page into a buffer first so a failing template becomes
+// a clean 500 instead of half a page
+func (reg *Registry) Render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	reg.RenderStatus(w, r, http.StatusOK, name, data)
+}
+
+// RenderStatus is Render with a status other than 200, eg. for error fragments
+func (reg *Registry) RenderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	body, err := reg.Execute(name, data)
+	if err != nil {
+		reg.fail(w, r, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Execute renders a page to bytes, for fragments that don't go straight into
+// a response such as server-sent events
+func (reg *Registry) Execute(name string, data any) ([]byte, error) {
+	reg.mu.RLock()
+	tmpl, parseErr := reg.pages[name], reg.parseErr
+	reg.mu.RUnlock()
+
+	switch {
+	case parseErr != nil:
+		return nil, parseErr
+	case tmpl == nil:
+		return nil, fmt.Errorf("no template named %s", name)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (reg *Registry) fail(w http.ResponseWriter, r *http.Request, name string, err error) {
+	reg.logger.ErrorContext(r.Context(), "Template error", "template", name, "path", r.URL.Path, "err", err)
+	if reg.dev {
+		http.Error(w, fmt.Sprintf("Template error rendering %s for %s:\n\n%v", name, r.URL.Path, err), http.StatusInternalServerError)
+		return
+	}
+	msg := "Failed to render page"
+	if id := middleware.RequestIDFromContext(r.Context()); id != "" {
+		msg += " (request id " + id + ")"
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// Watch polls the templates in dev mode and reparses on any change, only
+// useful when they are read from disk.
+// There is no fsnotify here, a stat of a dozen files every interval is cheap.
+func (reg *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if !reg.dev {
+		return
+	}
+	last, _ := fingerprint(reg.fsys)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := fingerprint(reg.fsys)
+		if err != nil || current == last {
+			continue
+		}
+		last = current
+		reg.reload()
+	}
+}
+
+func (reg *Registry) reload() {
+	pages, err := parse(reg.fsys, reg.funcs)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if err != nil {
+		reg.parseErr = err
+		reg.logger.Error("Template reload failed", "err", err)
+		return
+	}
+	reg.pages, reg.parseErr = pages, nil
+	reg.logger.Info("Templates reloaded", "pages", len(pages))
+}
+
+// fingerprint changes when any html file in fsys is added, removed or modified
+func fingerprint(fsys fs.FS) (uint64, error) {
+	var entries []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".html" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fmt.Sprintf("%s %d %d", p, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(entries)
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(entries, "\n")))
+	return h.Sum64(), nil
+}
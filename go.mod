@@ -3,7 +3,6 @@ module github.com/VooDooM1234/abs-visualiser
 go 1.24.5
 
 require (
-	github.com/gocarina/gocsv v0.0.0-20240520201108-78e41c74b4b1
 	github.com/jackc/pgx/v5 v5.7.5
 	github.com/joho/godotenv v1.5.1
 )
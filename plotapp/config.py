@@ -34,10 +34,9 @@ def load_config():
     db_port = parsed.port
     db_name = parsed.path.lstrip("/")
 
-    # Load config.json
-    with open("config.json", "r") as f:
+    # Load config.json, or the file the Go server was started with
+    with open(os.getenv("ABSVIS_CONFIG") or "config.json", "r") as f:
         config_json = json.load(f)
-        dash_port = config_json.get("dash_port")
         logging_config = config_json.get("logging_config")
          
     config = {
@@ -46,7 +45,6 @@ def load_config():
         "DB_PASSWORD": db_password,
         "DB_HOST": db_host,
         "DB_PORT": db_port,
-        "DASH_PORT": dash_port,
     }
     
     logging.config.dictConfig(logging_config)
@@ -8,9 +8,11 @@ import plotly.io as pio
 import sys
 
 from fastapi import FastAPI, HTTPException, Request, Form
+from fastapi.encoders import jsonable_encoder
+from fastapi.exceptions import RequestValidationError
 from fastapi.responses import HTMLResponse, JSONResponse, RedirectResponse
+from starlette.exceptions import HTTPException as StarletteHTTPException
 from fastapi.staticfiles import StaticFiles
-from fastapi.middleware.wsgi import WSGIMiddleware
 
 from pydantic import BaseModel
 from yaspin import yaspin
@@ -19,23 +21,54 @@ from plotapp.config import load_config
 from plotapp import fetch_ABS_SDMX as fsdmx
 
 import uvicorn
-from plotapp.dashapp import app as dash_app
 
 config = load_config()
 logger = logging.getLogger("main")
 logging.info("plotapp microservice started")
 app = FastAPI()
 
+# same envelope as the Go API, {"error": {"code", "message", "details", "requestId"}}
+ERROR_CODES = {
+    400: "bad_request",
+    404: "not_found",
+    405: "method_not_allowed",
+    422: "unprocessable",
+    502: "upstream_error",
+    503: "upstream_unavailable",
+    504: "upstream_timeout",
+}
+
+def error_response(request: Request, status_code: int, message: str, details=None):
+    error = {
+        "code": ERROR_CODES.get(status_code, "internal"),
+        "message": message,
+        "requestId": request.headers.get("X-Request-ID", ""),
+    }
+    if details is not None:
+        error["details"] = details
+    return JSONResponse(status_code=status_code, content={"error": error})
+
+@app.exception_handler(StarletteHTTPException)
+async def http_exception_handler(request: Request, exc: StarletteHTTPException):
+    return error_response(request, exc.status_code, str(exc.detail))
+
+@app.exception_handler(RequestValidationError)
+async def validation_exception_handler(request: Request, exc: RequestValidationError):
+    return error_response(request, 422, "invalid request", jsonable_encoder(exc.errors()))
+
+# df has a row per observation with timePeriod, value and series columns
 def bar_plot(df):
-    return px.bar(df, x="timePeriod", y="value", title="Bar Chart")
+    return px.bar(df, x="timePeriod", y="value", color="series", title="Bar Chart")
 def line_plot(df):
-    return px.line(df, x="timePeriod", y="value", title="Line Chart")
+    return px.line(df, x="timePeriod", y="value", color="series", title="Line Chart")
 def scatter_plot(df):
-    return px.scatter(df, x="timePeriod", y="value", title="Scatter Plot")
+    return px.scatter(df, x="timePeriod", y="value", color="series", title="Scatter Plot")
 def pie_plot(df):
-    return px.pie(df, names="timePeriod", values="value", title="Pie Chart")
+    # latest value of each series
+    latest = df.sort_values("timePeriod").groupby("series", as_index=False).last()
+    return px.pie(latest, names="series", values="value", title="Pie Chart")
 def histogram_plot(df):
-    return px.histogram(df, x="timePeriod", y="value", title="Histogram")
+    return px.histogram(df, x="timePeriod", y="value", color="series", title="Histogram")
 
 graphRegistry = {
     "bar": bar_plot,
@@ -48,13 +81,39 @@ graphRegistry = {
 @app.get("/plot/test", response_class=HTMLResponse)
 async def get_plot():
     fig = px.line(x=[1, 2, 3], y=[10, 20, 15], title="Sample Line Plot")
-    return pio.to_html(fig, full_html=False, include_plotlyjs='cdn')
+    # plotly.js is already on the page, index.html loads the vendored copy
+    return pio.to_html(fig, full_html=False, include_plotlyjs=False)
 
 @app.get("/plot/test/json", response_class=JSONResponse)
 async def get_plot_json():
     fig = px.line(x=[1, 2, 3], y=[10, 20, 15], title="Sample Line Plot")
     return fig.to_dict()
     
+# the Go API fetches, validates and transforms the observations, this only draws them
+class PlotRequest(BaseModel):
+    title: str = ""
+    format: str = "html"
+    observations: list[dict]
+
+@app.post("/plot/{graph}")
+async def post_plot(graph: str, payload: PlotRequest):
+    plot = graphRegistry.get(graph)
+    if plot is None:
+        raise HTTPException(status_code=400, detail=f"unknown graph {graph!r}, expected one of {', '.join(graphRegistry)}")
+    if payload.format not in ("html", "json"):
+        raise HTTPException(status_code=400, detail=f"unknown format {payload.format!r}, expected html or json")
+    if not payload.observations:
+        raise HTTPException(status_code=404, detail="no observations to plot")
+
+    df = pd.DataFrame(payload.observations).rename(columns={"period": "timePeriod", "seriesKey": "series"})
+    fig = plot(df)
+    if payload.title:
+        fig.update_layout(title=payload.title)
+    if payload.format == "json":
+        return JSONResponse(content=json.loads(pio.to_json(fig)))
+    # plotly.js is already on the page, index.html loads the vendored copy
+    return HTMLResponse(pio.to_html(fig, full_html=False, include_plotlyjs=False))
+
 @app.get("/metadata/valid-graphs", response_class=JSONResponse)
 async def put_plot_metadata_valid_graphs():
     valid_graphs = list(graphRegistry.keys())
@@ -81,7 +140,7 @@ async def get_dataflow_all():
     
     except Exception as e:
         logger.error(f"Failed to fetch dataflow: {e}")
-        return JSONResponse(content={"status": "failed", "message": {e}})
+        raise HTTPException(status_code=502, detail=f"Failed to fetch dataflow: {e}")
 class requestDataABS(BaseModel):
     dataflowid: str
 
@@ -114,10 +173,5 @@ async def get_data_abs(payload: requestCodelistABS):
         raise HTTPException(status_code=400, detail=f"requestDataCodelist: {str(e)}")
     
 
-# dash_app = create_dashboard(requests_pathname_prefix="/dashboard/")
-# dash_app.enable_dev_tools()
-app.mount("/dashboard", WSGIMiddleware(dash_app.server))
-
-
 # if __name__ == "__main__":
 #     uvicorn.run(app, port=8000)
\ No newline at end of file
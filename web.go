@@ -0,0 +1,27 @@
+// Package web embeds the templates and static files so the server binary
+// runs from any directory. It sits at the module root because go:embed can't
+// reach outside the package directory.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates/html static
+var files embed.FS
+
+var (
+	// Templates is templates/html
+	Templates = sub("templates/html")
+	// Static is static, the stylesheet and the dataflow snapshot
+	Static = sub("static")
+)
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}